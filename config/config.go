@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -31,3 +32,84 @@ func LoadConfig() Config {
 
 	return config
 }
+
+// MpesaConfig holds the Daraja API credentials for M-Pesa STK push
+type MpesaConfig struct {
+	BaseURL         string // Sandbox or production Daraja host
+	ConsumerKey     string
+	ConsumerSecret  string
+	ShortCode       string // Paybill or head office number payments are made to
+	Passkey         string
+	TransactionType string // CustomerPayBillOnline or CustomerBuyGoodsOnline
+	PartyB          string // Till number for Buy Goods; defaults to ShortCode
+	CallbackURL     string // Public URL of the callback endpoint
+	CallbackToken   string // Secret expected in the callback's ?token=, since Daraja doesn't sign callbacks
+}
+
+// LoadMpesaConfig reads the M-Pesa settings from the environment, pointing
+// at the Daraja sandbox unless MPESA_BASE_URL says otherwise.
+func LoadMpesaConfig() MpesaConfig {
+	cfg := MpesaConfig{
+		BaseURL:         os.Getenv("MPESA_BASE_URL"),
+		ConsumerKey:     os.Getenv("MPESA_CONSUMER_KEY"),
+		ConsumerSecret:  os.Getenv("MPESA_CONSUMER_SECRET"),
+		ShortCode:       os.Getenv("MPESA_SHORTCODE"),
+		Passkey:         os.Getenv("MPESA_PASSKEY"),
+		TransactionType: os.Getenv("MPESA_TRANSACTION_TYPE"),
+		PartyB:          os.Getenv("MPESA_PARTY_B"),
+		CallbackURL:     os.Getenv("MPESA_CALLBACK_URL"),
+		CallbackToken:   os.Getenv("MPESA_CALLBACK_TOKEN"),
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://sandbox.safaricom.co.ke"
+	}
+	if cfg.TransactionType == "" {
+		cfg.TransactionType = "CustomerPayBillOnline"
+	}
+	if cfg.PartyB == "" {
+		cfg.PartyB = cfg.ShortCode
+	}
+	return cfg
+}
+
+// EtimsConfig holds the KRA eTIMS details sales are fiscalized with
+type EtimsConfig struct {
+	BaseURL    string // Sandbox or production eTIMS API
+	PIN        string // Seller's KRA PIN
+	BranchID   string // Branch registered with KRA, "00" for head office
+	CommKey    string // Key issued when the device was initialised
+	ItemClass  string // Item classification code sent for items without their own
+	ReceiptURL string // Where receipt QR codes point; the PIN, branch and signature are appended
+}
+
+// LoadEtimsConfig reads the eTIMS settings from the environment, pointing
+// at the KRA sandbox unless ETIMS_BASE_URL says otherwise.
+func LoadEtimsConfig() EtimsConfig {
+	cfg := EtimsConfig{
+		BaseURL:    os.Getenv("ETIMS_BASE_URL"),
+		PIN:        os.Getenv("ETIMS_PIN"),
+		BranchID:   os.Getenv("ETIMS_BRANCH_ID"),
+		CommKey:    os.Getenv("ETIMS_CMC_KEY"),
+		ItemClass:  os.Getenv("ETIMS_ITEM_CLASS"),
+		ReceiptURL: os.Getenv("ETIMS_RECEIPT_URL"),
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://etims-api-sbx.kra.go.ke/etims-api"
+	}
+	if cfg.BranchID == "" {
+		cfg.BranchID = "00"
+	}
+	if cfg.ReceiptURL == "" {
+		cfg.ReceiptURL = "https://etims-sbx.kra.go.ke/common/link/etims/receipt/indexEtimsReceiptData?Data="
+	}
+	return cfg
+}
+
+// AdjustmentApprovalThreshold is the largest stock adjustment, in units,
+// that can be applied without an admin's approval.
+func AdjustmentApprovalThreshold() int {
+	if threshold, err := strconv.Atoi(os.Getenv("ADJUSTMENT_APPROVAL_THRESHOLD")); err == nil && threshold >= 0 {
+		return threshold
+	}
+	return 10
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var (
+	errCustomerNotFound = errors.New("customer not found in your business")
+	errCustomerRequired = errors.New("credit sales need a customer")
+	errCreditLimit      = errors.New("credit limit exceeded")
+)
+
+// lockCustomer loads one of the business's customers and locks the row, so
+// balance changes made through it are never lost to a concurrent writer.
+func lockCustomer(tx *gorm.DB, businessID, customerID uint) (models.Customer, error) {
+	var customer models.Customer
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", businessID, customerID).
+		First(&customer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return customer, errCustomerNotFound
+	}
+	return customer, err
+}
+
+// adjustBalance changes a locked customer's balance by amount (positive
+// when they owe more).
+func adjustBalance(tx *gorm.DB, customer *models.Customer, amount float64) error {
+	customer.Balance = roundMoney(customer.Balance + amount)
+	return tx.Model(customer).UpdateColumn("balance", customer.Balance).Error
+}
+
+// lockSaleOrder loads one of the business's sale orders and locks it,
+// locking its customer first. Customer payments lock the customer before
+// their orders, so taking the order first could deadlock against one.
+func lockSaleOrder(tx *gorm.DB, businessID interface{}, orderID string) (models.SaleOrder, error) {
+	var order models.SaleOrder
+	if err := tx.Where("business_id = ? AND id = ?", businessID, orderID).First(&order).Error; err != nil {
+		return order, err
+	}
+	if order.CustomerID != 0 {
+		if _, err := lockCustomer(tx, order.BusinessID, order.CustomerID); err != nil && !errors.Is(err, errCustomerNotFound) {
+			return order, err
+		}
+	}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").First(&order, order.ID).Error
+	return order, err
+}
+
+// settleOrder takes amount off what is owed on a credit sale order and off
+// its customer's balance. The order must already be locked.
+func settleOrder(tx *gorm.DB, order *models.SaleOrder, amount float64) error {
+	customer, err := lockCustomer(tx, order.BusinessID, order.CustomerID)
+	if err != nil {
+		return err
+	}
+	if err := adjustBalance(tx, &customer, -amount); err != nil {
+		return err
+	}
+	order.AmountDue = roundMoney(order.AmountDue - amount)
+	return tx.Model(order).UpdateColumn("amount_due", order.AmountDue).Error
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/config"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+func isAdjustmentReason(reason string) bool {
+	for _, r := range models.AdjustmentReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAdjustment approves adj and books it to the stock ledger inside tx
+func applyAdjustment(tx *gorm.DB, adj *models.StockAdjustment, approverID uint) error {
+	now := time.Now()
+	adj.Status = models.AdjustmentApproved
+	adj.ApprovedBy = approverID
+	adj.ApprovedAt = &now
+
+	if err := tx.Save(adj).Error; err != nil {
+		return err
+	}
+
+	movement := models.StockMovement{
+		BusinessID:    adj.BusinessID,
+		ProductID:     adj.ProductID,
+		LocationID:    adj.LocationID,
+		Type:          models.MovementAdjustment,
+		Quantity:      adj.Quantity,
+		Reason:        adj.Reason,
+		UserID:        approverID,
+		ReferenceType: "adjustment",
+		ReferenceID:   adj.ID,
+	}
+	if adj.Quantity < 0 {
+		// Written-off units leave the cost layers too, expired or not
+		_, err := issueStock(tx, &movement, models.CostingFIFO, adj.LotID, true)
+		return err
+	}
+
+	// Found units become a lot at the current average cost, which leaves
+	// the average as it is and gives FIFO a cost to draw on
+	var product models.Product
+	if err := tx.Select("id, cost_price, average_cost").First(&product, adj.ProductID).Error; err != nil {
+		return err
+	}
+	unitCost := product.AverageCost
+	if unitCost == 0 {
+		unitCost = product.CostPrice
+	}
+	stock := models.Stock{
+		BusinessID:   adj.BusinessID,
+		ProductID:    adj.ProductID,
+		LocationID:   adj.LocationID,
+		AdjustmentID: adj.ID,
+		Quantity:     adj.Quantity,
+		Remaining:    adj.Quantity,
+		UnitCost:     unitCost,
+		AddedAt:      now,
+	}
+	if err := tx.Create(&stock).Error; err != nil {
+		return err
+	}
+	return moveStock(tx, &movement)
+}
+
+// CreateAdjustment - Records a stock adjustment with a reason code. Admins and
+// adjustments within the approval threshold are applied immediately.
+func CreateAdjustment(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input struct {
+		ProductID  uint    `json:"product_id" binding:"required"`
+		Quantity   float64 `json:"quantity" binding:"required"` // In the base unit
+		Reason     string  `json:"reason" binding:"required"`
+		Note       string  `json:"note"`
+		LotID      uint    `json:"lot_id"` // Lot to write off first, e.g. for expiry
+		LocationID uint    `json:"location_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !isAdjustmentReason(input.Reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid reason, expected one of %v", models.AdjustmentReasons)})
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.
+		Where("business_id = ? AND id = ?", businessID, input.ProductID).
+		First(&product).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+	// Stock is held by the variants, never by their parent
+	if product.HasVariants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Adjust each variant of " + product.Name + " instead"})
+		return
+	}
+	if !product.AllowDecimal && !isWhole(input.Quantity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be a whole number of " + product.BaseUnit})
+		return
+	}
+
+	location, err := resolveLocation(database.DB, businessID.(uint), input.LocationID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	adjustment := models.StockAdjustment{
+		BusinessID:  businessID.(uint),
+		ProductID:   product.ID,
+		LocationID:  location.ID,
+		Quantity:    input.Quantity,
+		Reason:      input.Reason,
+		Note:        input.Note,
+		LotID:       input.LotID,
+		Status:      models.AdjustmentPending,
+		RequestedBy: userID,
+	}
+
+	size := input.Quantity
+	if size < 0 {
+		size = -size
+	}
+	needsApproval := c.GetString("role") != "admin" && size > float64(config.AdjustmentApprovalThreshold())
+
+	tx := database.DB.Begin()
+
+	if err := tx.Create(&adjustment).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record adjustment"})
+		return
+	}
+
+	if !needsApproval {
+		if err := applyAdjustment(tx, &adjustment, userID); errors.Is(err, errInsufficientStock) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+			return
+		} else if errors.Is(err, errLotUnavailable) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Lot not found or sold out"})
+			return
+		} else if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply adjustment"})
+			return
+		}
+	}
+
+	tx.Commit()
+
+	if needsApproval {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":    "Adjustment is awaiting admin approval",
+			"adjustment": adjustment,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, adjustment)
+}
+
+// GetAdjustments - Lists adjustments, optionally filtered by ?status=
+func GetAdjustments(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := database.DB.Preload("Product").Where("business_id = ?", businessID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var adjustments []models.StockAdjustment
+	if err := query.Order("created_at DESC").Find(&adjustments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch adjustments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, adjustments)
+}
+
+// findPendingAdjustment loads a pending adjustment for review, writing the
+// error response itself when it can't.
+func findPendingAdjustment(c *gin.Context, tx *gorm.DB) (*models.StockAdjustment, bool) {
+	var adjustment models.StockAdjustment
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", c.GetUint("business_id"), c.Param("id")).
+		First(&adjustment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adjustment not found"})
+		return nil, false
+	}
+
+	if adjustment.Status != models.AdjustmentPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Adjustment has already been " + adjustment.Status})
+		return nil, false
+	}
+
+	return &adjustment, true
+}
+
+// ApproveAdjustment - Lets an admin apply a pending adjustment
+func ApproveAdjustment(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	adjustment, ok := findPendingAdjustment(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	if err := applyAdjustment(tx, adjustment, c.GetUint("user_id")); errors.Is(err, errInsufficientStock) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+		return
+	} else if errors.Is(err, errLotUnavailable) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot not found or sold out"})
+		return
+	} else if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply adjustment"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, adjustment)
+}
+
+// RejectAdjustment - Lets an admin discard a pending adjustment
+func RejectAdjustment(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	adjustment, ok := findPendingAdjustment(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	now := time.Now()
+	adjustment.Status = models.AdjustmentRejected
+	adjustment.ApprovedBy = c.GetUint("user_id")
+	adjustment.ApprovedAt = &now
+	if err := tx.Save(adjustment).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject adjustment"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, adjustment)
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+)
+
+// salesExportHeader is the first row of the sales CSV export
+var salesExportHeader = []string{
+	"order_id", "sale_id", "sold_at", "product", "sku", "quantity", "unit",
+	"unit_price", "total", "cost", "returned", "payment_method", "voided", "void_reason", "archived_at",
+}
+
+// ExportSales - Streams every sale line for the business as CSV, archived
+// or not, so the history can be kept before it is archived.
+// ?start_date and ?end_date (RFC3339) narrow it to a period.
+func ExportSales(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	startDate, endDate := time.Time{}, time.Now()
+	if raw := c.Query("start_date"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format"})
+			return
+		}
+		startDate = parsed
+	}
+	if raw := c.Query("end_date"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format"})
+			return
+		}
+		endDate = parsed
+	}
+
+	rows, err := database.DB.Raw(
+		`SELECT s.sale_order_id, s.id, s.sold_at, p.name, p.sku,
+			CASE WHEN s.unit_quantity > 0 THEN s.unit_quantity ELSE s.quantity END,
+			COALESCE(NULLIF(s.unit_name, ''), p.base_unit),
+			COALESCE(s.unit_price, 0), s.total, COALESCE(s.cost, 0), COALESCE(s.returned, 0),
+			COALESCE(o.payment_method, ''), COALESCE(s.voided, false), COALESCE(o.void_reason, ''), s.archived_at
+		FROM sales s
+		JOIN products p ON p.id = s.product_id
+		LEFT JOIN sale_orders o ON o.id = s.sale_order_id
+		WHERE s.business_id = ? AND s.deleted_at IS NULL AND s.sold_at BETWEEN ? AND ?
+		ORDER BY s.sold_at, s.id`,
+		businessID, startDate, endDate,
+	).Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sales"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=sales-%s.csv", time.Now().Format("20060102")))
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(salesExportHeader)
+	for rows.Next() {
+		var orderID, saleID uint
+		var soldAt time.Time
+		var archivedAt *time.Time
+		var product, sku, unit, paymentMethod, voidReason string
+		var quantity, unitPrice, total, cost, returned float64
+		var voided bool
+		if err := rows.Scan(&orderID, &saleID, &soldAt, &product, &sku, &quantity, &unit,
+			&unitPrice, &total, &cost, &returned, &paymentMethod, &voided, &voidReason, &archivedAt); err != nil {
+			// Headers are already sent, so all that can be done is stop
+			break
+		}
+		var archived string
+		if archivedAt != nil {
+			archived = archivedAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			strconv.FormatUint(uint64(orderID), 10),
+			strconv.FormatUint(uint64(saleID), 10),
+			soldAt.Format(time.RFC3339),
+			product,
+			sku,
+			strconv.FormatFloat(quantity, 'f', -1, 64),
+			unit,
+			fmt.Sprintf("%.2f", unitPrice),
+			fmt.Sprintf("%.2f", total),
+			fmt.Sprintf("%.2f", cost),
+			strconv.FormatFloat(returned, 'f', -1, 64),
+			paymentMethod,
+			strconv.FormatBool(voided),
+			voidReason,
+			archived,
+		})
+	}
+	writer.Flush()
+}
+
+// ArchiveSales - Hides sales made before the given date from the day-to-day
+// sales listings. Nothing is deleted: archived sales still count in reports
+// and in the CSV export.
+func ArchiveSales(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Before string `json:"before" binding:"required"` // RFC3339
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, err := time.Parse(time.RFC3339, input.Before)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format"})
+		return
+	}
+
+	now := time.Now()
+	tx := database.DB.Begin()
+
+	orders := tx.Exec(
+		`UPDATE sale_orders SET archived_at = ?
+		WHERE business_id = ? AND sold_at < ? AND archived_at IS NULL AND deleted_at IS NULL`,
+		now, businessID, before,
+	)
+	if orders.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive sale orders"})
+		return
+	}
+
+	sales := tx.Exec(
+		`UPDATE sales SET archived_at = ?
+		WHERE business_id = ? AND sold_at < ? AND archived_at IS NULL AND deleted_at IS NULL`,
+		now, businessID, before,
+	)
+	if sales.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive sales"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Sales archived successfully",
+		"orders_archived": orders.RowsAffected,
+		"sales_archived":  sales.RowsAffected,
+	})
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var errInvalidBarcode = errors.New("invalid barcode")
+
+func isDigits(code string) bool {
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return code != ""
+}
+
+// gtinCheckDigit computes the check digit for the digits of an EAN/UPC code
+// that precede it: weights alternate 3, 1 starting from the rightmost digit.
+func gtinCheckDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte((10-sum%10)%10) + '0'
+}
+
+// detectSymbology guesses a code's symbology from its shape
+func detectSymbology(code string) string {
+	switch {
+	case len(code) == 13 && isDigits(code):
+		return models.BarcodeEAN13
+	case len(code) == 12 && isDigits(code):
+		return models.BarcodeUPCA
+	default:
+		return models.BarcodeCode128
+	}
+}
+
+// validateBarcode checks a code is well formed for its symbology, including
+// the check digit of EAN-13 and UPC-A codes
+func validateBarcode(symbology, code string) error {
+	switch symbology {
+	case models.BarcodeEAN13, models.BarcodeUPCA:
+		length := 13
+		if symbology == models.BarcodeUPCA {
+			length = 12
+		}
+		if len(code) != length || !isDigits(code) {
+			return fmt.Errorf("%w: %s must be %d digits", errInvalidBarcode, strings.ToUpper(symbology), length)
+		}
+		if gtinCheckDigit(code[:length-1]) != code[length-1] {
+			return fmt.Errorf("%w: check digit should be %c", errInvalidBarcode, gtinCheckDigit(code[:length-1]))
+		}
+	case models.BarcodeCode128:
+		if code == "" || len(code) > 48 {
+			return fmt.Errorf("%w: Code 128 must be 1 to 48 characters", errInvalidBarcode)
+		}
+		for _, r := range code {
+			if r < 32 || r > 126 {
+				return fmt.Errorf("%w: Code 128 must be printable ASCII", errInvalidBarcode)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown symbology %q", errInvalidBarcode, symbology)
+	}
+	return nil
+}
+
+// barcodeCandidates lists the forms a scanned code may be stored under:
+// many scanners report a UPC-A as a 13-digit EAN with a leading zero
+func barcodeCandidates(code string) []string {
+	candidates := []string{code}
+	if len(code) == 13 && code[0] == '0' && isDigits(code) {
+		candidates = append(candidates, code[1:])
+	} else if len(code) == 12 && isDigits(code) {
+		candidates = append(candidates, "0"+code)
+	}
+	return candidates
+}
+
+// skuTaken reports whether another live product in the business already
+// uses sku, ignoring case
+func skuTaken(db *gorm.DB, businessID, excludeID uint, sku string) (bool, error) {
+	if sku == "" {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&models.Product{}).
+		Where("business_id = ? AND LOWER(sku) = ? AND id != ?", businessID, strings.ToLower(sku), excludeID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// code128Patterns holds the bar/space widths of each Code 128 symbol value;
+// 103-105 are the start codes and 106 is the stop pattern
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// encodeCode128 returns the modules (1 for bar, 0 for space) of a Code 128
+// symbol for code, using code set C for even-length digit strings and code
+// set B otherwise. The code must already have passed validateBarcode.
+func encodeCode128(code string) string {
+	var values []int
+	if len(code) >= 4 && len(code)%2 == 0 && isDigits(code) {
+		values = append(values, code128StartC)
+		for i := 0; i < len(code); i += 2 {
+			values = append(values, int(code[i]-'0')*10+int(code[i+1]-'0'))
+		}
+	} else {
+		values = append(values, code128StartB)
+		for i := 0; i < len(code); i++ {
+			values = append(values, int(code[i])-32)
+		}
+	}
+
+	checksum := values[0]
+	for i := 1; i < len(values); i++ {
+		checksum += i * values[i]
+	}
+	values = append(values, checksum%103, code128Stop)
+
+	var modules strings.Builder
+	for _, value := range values {
+		for i, width := range code128Patterns[value] {
+			bit := "1"
+			if i%2 == 1 {
+				bit = "0"
+			}
+			modules.WriteString(strings.Repeat(bit, int(width-'0')))
+		}
+	}
+	return modules.String()
+}
+
+var (
+	eanLeft  = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanEven  = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	eanRight = [10]string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+	// eanParity picks odd (L) or even (G) encoding for the left half from the first digit
+	eanParity = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+)
+
+// encodeEAN13 returns the 95 modules of an EAN-13 symbol. A 12-digit UPC-A
+// code is encoded as the EAN-13 with a leading zero, which scans the same.
+func encodeEAN13(code string) string {
+	if len(code) == 12 {
+		code = "0" + code
+	}
+
+	var modules strings.Builder
+	modules.WriteString("101")
+	parity := eanParity[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		if parity[i-1] == 'L' {
+			modules.WriteString(eanLeft[code[i]-'0'])
+		} else {
+			modules.WriteString(eanEven[code[i]-'0'])
+		}
+	}
+	modules.WriteString("01010")
+	for i := 7; i <= 12; i++ {
+		modules.WriteString(eanRight[code[i]-'0'])
+	}
+	modules.WriteString("101")
+	return modules.String()
+}
+
+// encodeBarcode returns the modules for code in the given symbology
+func encodeBarcode(symbology, code string) string {
+	if symbology == models.BarcodeEAN13 || symbology == models.BarcodeUPCA {
+		return encodeEAN13(code)
+	}
+	return encodeCode128(code)
+}
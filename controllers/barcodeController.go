@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// AddBarcode - Attaches a barcode to a product. The symbology is worked
+// out from the code when it isn't given.
+func AddBarcode(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Code      string `json:"code" binding:"required"`
+		Symbology string `json:"symbology" binding:"omitempty,oneof=ean13 upca code128"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := strings.TrimSpace(input.Code)
+	if input.Symbology == "" {
+		input.Symbology = detectSymbology(code)
+	}
+	if err := validateBarcode(input.Symbology, code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&product).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+	if product.HasVariants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Add barcodes to each variant instead"})
+		return
+	}
+
+	var existing models.ProductBarcode
+	if err := database.DB.Where("business_id = ? AND code IN (?)", businessID, barcodeCandidates(code)).
+		First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Barcode is already assigned to a product"})
+		return
+	}
+
+	barcode := models.ProductBarcode{
+		BusinessID: product.BusinessID,
+		ProductID:  product.ID,
+		Code:       code,
+		Symbology:  input.Symbology,
+	}
+	if err := database.DB.Create(&barcode).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add barcode"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, barcode)
+}
+
+func DeleteBarcode(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := database.DB.
+		Where("business_id = ? AND product_id = ? AND id = ?", businessID, c.Param("id"), c.Param("barcode_id")).
+		Delete(&models.ProductBarcode{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete barcode"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Barcode not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Barcode deleted"})
+}
+
+// LookupProduct - Finds the product for a scanned barcode, falling back to
+// an exact SKU match. Returns what the till needs to add it to a basket.
+func LookupProduct(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	code := strings.TrimSpace(c.Query("barcode"))
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "barcode is required"})
+		return
+	}
+
+	query := database.DB.Select("id, name, sku, price, quantity, base_unit, allow_decimal, is_kit").
+		Preload("Units", "for_sale = ?", true).
+		Where("business_id = ? AND has_variants IS NOT TRUE", businessID)
+
+	var product models.Product
+	err := query.
+		Where("id = (SELECT product_id FROM product_barcodes WHERE business_id = ? AND code IN (?) AND deleted_at IS NULL LIMIT 1)",
+			businessID, barcodeCandidates(code)).
+		First(&product).Error
+	if err != nil {
+		err = query.Where("LOWER(sku) = ?", strings.ToLower(code)).First(&product).Error
+	}
+	if err != nil {
+		handleProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
+}
@@ -490,3 +490,56 @@ func EditCategory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Category updated successfully", "category": category})
 }
+
+// UpdateCostingMethod - Lets an admin choose how cost of goods sold is valued
+func UpdateCostingMethod(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Business context required"})
+		return
+	}
+
+	var input struct {
+		CostingMethod string `json:"costing_method" binding:"required,oneof=fifo average latest"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Model(&models.Business{}).
+		Where("id = ?", businessID).
+		Update("costing_method", input.CostingMethod).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update costing method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Costing method updated", "costing_method": input.CostingMethod})
+}
+
+// UpdateTaxPricing - Lets an admin choose whether product prices already
+// include tax or have it added at the till
+func UpdateTaxPricing(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Business context required"})
+		return
+	}
+
+	var input struct {
+		PricesIncludeTax *bool `json:"prices_include_tax" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Model(&models.Business{}).
+		Where("id = ?", businessID).
+		Update("prices_include_tax", *input.PricesIncludeTax).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tax pricing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tax pricing updated", "prices_include_tax": *input.PricesIncludeTax})
+}
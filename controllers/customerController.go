@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+type customerInput struct {
+	Name        string  `json:"name" binding:"required,min=2"`
+	Phone       string  `json:"phone"`
+	Email       string  `json:"email" binding:"omitempty,email"`
+	Address     string  `json:"address"`
+	CreditLimit float64 `json:"credit_limit" binding:"gte=0"`
+	Notes       string  `json:"notes"`
+	PriceListID uint    `json:"price_list_id"`
+}
+
+func CreateCustomer(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input customerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Names repeat, phone numbers shouldn't
+	phone := strings.TrimSpace(input.Phone)
+	if phone != "" {
+		var existing models.Customer
+		if err := database.DB.Where("business_id = ? AND phone = ?", businessID, phone).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "A customer with this phone number already exists"})
+			return
+		}
+	}
+	if input.PriceListID != 0 {
+		if _, err := findPriceList(database.DB, businessID.(uint), input.PriceListID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	customer := models.Customer{
+		BusinessID:  businessID.(uint),
+		Name:        strings.TrimSpace(input.Name),
+		Phone:       phone,
+		Email:       input.Email,
+		Address:     input.Address,
+		CreditLimit: input.CreditLimit,
+		Notes:       input.Notes,
+		PriceListID: input.PriceListID,
+	}
+	if err := database.DB.Create(&customer).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, customer)
+}
+
+// GetCustomers - Lists the business's customers; ?search= matches name or
+// phone, for picking a customer at the till
+func GetCustomers(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := database.DB.Where("business_id = ?", businessID)
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		query = query.Where("name ILIKE ? OR phone LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+
+	var customers []models.Customer
+	if err := query.Order("name").Find(&customers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, customers)
+}
+
+func GetCustomer(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var customer models.Customer
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&customer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	c.JSON(http.StatusOK, customer)
+}
+
+func UpdateCustomer(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var customer models.Customer
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&customer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	var input customerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone := strings.TrimSpace(input.Phone)
+	if phone != "" && phone != customer.Phone {
+		var existing models.Customer
+		if err := database.DB.Where("business_id = ? AND phone = ? AND id <> ?", businessID, phone, customer.ID).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "A customer with this phone number already exists"})
+			return
+		}
+	}
+	if input.PriceListID != 0 {
+		if _, err := findPriceList(database.DB, businessID.(uint), input.PriceListID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Balance is only changed by sales, returns and payments, never here
+	if err := database.DB.Model(&customer).Updates(map[string]interface{}{
+		"name":          strings.TrimSpace(input.Name),
+		"phone":         phone,
+		"email":         input.Email,
+		"address":       input.Address,
+		"credit_limit":  input.CreditLimit,
+		"notes":         input.Notes,
+		"price_list_id": input.PriceListID,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, customer)
+}
+
+// DeleteCustomer - Deletes a customer who owes nothing
+func DeleteCustomer(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var customer models.Customer
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&customer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if customer.Balance > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete a customer with an outstanding balance"})
+		return
+	}
+
+	if err := database.DB.Delete(&customer).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Customer deleted successfully"})
+}
+
+// RecordCustomerPayment - Takes a payment, in full or in part, against a
+// customer's balance and applies it to their oldest unpaid credit sales
+func RecordCustomerPayment(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Amount    float64 `json:"amount" binding:"required,gt=0"`
+		Method    string  `json:"method" binding:"required,oneof=cash mpesa card"`
+		Reference string  `json:"reference"`
+		Note      string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	var customer models.Customer
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&customer).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	amount := roundMoney(input.Amount)
+	if amount > customer.Balance {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment is more than the customer owes"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	shiftID, err := openShiftID(tx, customer.BusinessID, userID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	payment := models.CustomerPayment{
+		BusinessID: customer.BusinessID,
+		CustomerID: customer.ID,
+		UserID:     userID,
+		ShiftID:    shiftID,
+		Amount:     amount,
+		Method:     input.Method,
+		Reference:  strings.TrimSpace(input.Reference),
+		Note:       input.Note,
+		PaidAt:     time.Now(),
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	var orders []models.SaleOrder
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("customer_id = ? AND amount_due > 0 AND voided_at IS NULL", customer.ID).
+		Order("sold_at, id").
+		Find(&orders).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch unpaid sales"})
+		return
+	}
+
+	remaining := amount
+	for _, order := range orders {
+		if remaining <= 0 {
+			break
+		}
+		applied := math.Min(remaining, order.AmountDue)
+		if err := tx.Model(&order).UpdateColumn("amount_due", roundMoney(order.AmountDue-applied)).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply payment"})
+			return
+		}
+		allocation := models.CustomerPaymentAllocation{
+			PaymentID:   payment.ID,
+			SaleOrderID: order.ID,
+			Amount:      applied,
+		}
+		if err := tx.Create(&allocation).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply payment"})
+			return
+		}
+		payment.Allocations = append(payment.Allocations, allocation)
+		remaining = roundMoney(remaining - applied)
+	}
+
+	if err := adjustBalance(tx, &customer, -amount); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer balance"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, gin.H{"payment": payment, "balance": customer.Balance})
+}
+
+func GetCustomerPayments(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var payments []models.CustomerPayment
+	if err := database.DB.Preload("Allocations").
+		Where("business_id = ? AND customer_id = ?", businessID, c.Param("id")).
+		Order("paid_at DESC").
+		Find(&payments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	c.JSON(http.StatusOK, payments)
+}
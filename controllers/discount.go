@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var (
+	errDiscountTooLarge = errors.New("discount is more than the price")
+	errDiscountLimit    = errors.New("discount is more than your role may give")
+)
+
+// Discount types
+const (
+	discountPercent = "percent"
+	discountFixed   = "fixed"
+)
+
+// discountInput is a discount the cashier gives by hand on a line or a
+// whole order
+type discountInput struct {
+	Type  string  `json:"type" binding:"required,oneof=percent fixed"`
+	Value float64 `json:"value" binding:"required,gt=0"` // Percent, or shillings off
+}
+
+// amount is what the discount takes off price
+func (d discountInput) amount(price float64) float64 {
+	if d.Type == discountPercent {
+		return roundMoney(price * d.Value / 100)
+	}
+	return roundMoney(d.Value)
+}
+
+// defaultDiscountLimits apply to roles a business hasn't set a limit for.
+// Older accounts with no role are held to the employee limit.
+var defaultDiscountLimits = map[string]float64{
+	models.RoleAdmin:    100,
+	models.RoleManager:  25,
+	models.RoleEmployee: 10,
+}
+
+// discountLimit returns the largest percentage a role may take off by hand
+func discountLimit(tx *gorm.DB, businessID uint, role string) (float64, error) {
+	var limit models.DiscountLimit
+	err := tx.Where("business_id = ? AND role = ?", businessID, role).First(&limit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if max, ok := defaultDiscountLimits[role]; ok {
+			return max, nil
+		}
+		return defaultDiscountLimits[models.RoleEmployee], nil
+	}
+	return limit.MaxPercent, err
+}
+
+// discountChecker checks discounts given by hand on an order against the
+// cashier's limit, which is only looked up once one is given. Line and
+// order discounts count together against what the order lists at.
+type discountChecker struct {
+	tx         *gorm.DB
+	businessID uint
+	userID     uint
+	limit      *float64
+	gross      float64 // List price of the lines checked so far
+	manual     float64 // Given by hand on those lines
+}
+
+// line checks a hand discount of amount off a line listed at gross, which
+// costs price after promotions
+func (d *discountChecker) line(amount, price, gross float64) error {
+	d.gross += gross
+	if amount > price+0.005 {
+		return errDiscountTooLarge
+	}
+	if err := d.withinLimit(amount, gross); err != nil {
+		return err
+	}
+	d.manual += amount
+	return nil
+}
+
+// order checks a hand discount of amount off the whole order, which costs
+// price after its lines' discounts
+func (d *discountChecker) order(amount, price float64) error {
+	if amount > price+0.005 {
+		return errDiscountTooLarge
+	}
+	return d.withinLimit(d.manual+amount, d.gross)
+}
+
+// withinLimit returns an error if taking amount off gross is more than the
+// cashier may give
+func (d *discountChecker) withinLimit(amount, gross float64) error {
+	if amount <= 0 {
+		return nil
+	}
+	if d.limit == nil {
+		var user models.User
+		if err := d.tx.Select("id, role").First(&user, d.userID).Error; err != nil {
+			return err
+		}
+		limit, err := discountLimit(d.tx, d.businessID, user.Role)
+		if err != nil {
+			return err
+		}
+		d.limit = &limit
+	}
+	if gross <= 0 || amount/gross*100 > *d.limit+1e-9 {
+		return errDiscountLimit
+	}
+	return nil
+}
+
+// activePromotions returns the business's promotions running at a time
+func activePromotions(tx *gorm.DB, businessID uint, at time.Time) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+	if err := tx.Where("business_id = ? AND active = ?", businessID, true).
+		Where("(starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", at, at).
+		Find(&promotions).Error; err != nil {
+		return nil, err
+	}
+
+	running := promotions[:0]
+	for _, promotion := range promotions {
+		if promotion.Type == models.PromotionHappyHour && !inDailyWindow(promotion.DailyFrom, promotion.DailyTo, at) {
+			continue
+		}
+		running = append(running, promotion)
+	}
+	return running, nil
+}
+
+// eat is East Africa Time, which happy hours are set in whatever zone the
+// server runs in
+var eat = time.FixedZone("EAT", 3*60*60)
+
+// inDailyWindow reports whether at's time of day in East Africa falls
+// between from and to, given as "HH:MM". A window ending earlier than it
+// starts runs past midnight.
+func inDailyWindow(from, to string, at time.Time) bool {
+	now := at.In(eat).Format("15:04")
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+// promotionCovers reports whether a promotion on a product applies to p,
+// which may be one of its variants
+func promotionCovers(promotion models.Promotion, p models.Product) bool {
+	return promotion.ProductID == p.ID || (p.ParentID != nil && *p.ParentID == promotion.ProductID)
+}
+
+// bestPromotion returns the promotion taking the most off a line of
+// quantity units at price each, and how much it takes off
+func bestPromotion(promotions []models.Promotion, product models.Product, quantity, price float64) (*models.Promotion, float64) {
+	var best *models.Promotion
+	var bestAmount float64
+	gross := quantity * price
+	for i, promotion := range promotions {
+		var amount float64
+		switch promotion.Type {
+		case models.PromotionBuyXGetY:
+			if !promotionCovers(promotion, product) || promotion.BuyQuantity <= 0 || promotion.GetQuantity <= 0 {
+				continue
+			}
+			sets := math.Floor(roundQuantity(quantity / (promotion.BuyQuantity + promotion.GetQuantity)))
+			amount = sets * promotion.GetQuantity * price
+		case models.PromotionCategory:
+			if product.CategoryID != promotion.CategoryID {
+				continue
+			}
+			amount = gross * promotion.Percent / 100
+		case models.PromotionHappyHour:
+			if promotion.ProductID != 0 && !promotionCovers(promotion, product) {
+				continue
+			}
+			if promotion.CategoryID != 0 && product.CategoryID != promotion.CategoryID {
+				continue
+			}
+			amount = gross * promotion.Percent / 100
+		}
+		if amount = roundMoney(amount); amount > bestAmount {
+			best, bestAmount = &promotions[i], amount
+		}
+	}
+	return best, bestAmount
+}
+
+// applyOrderDiscount takes a discount off a whole order's lines, sharing it
+// between them by what each costs after its own discounts
+func applyOrderDiscount(tx *gorm.DB, order *models.SaleOrder, discount discountInput, checker *discountChecker) error {
+	nets := make([]float64, len(order.Items))
+	var base float64
+	for i, sale := range order.Items {
+		nets[i] = roundMoney(sale.UnitQuantity*sale.UnitPrice) - sale.Discount
+		base += nets[i]
+	}
+	base = roundMoney(base)
+
+	amount := discount.amount(base)
+	if err := checker.order(amount, base); err != nil {
+		return err
+	}
+	if amount == 0 {
+		return nil
+	}
+
+	// The last line takes what rounding leaves so the shares add up
+	remaining := amount
+	for i := range order.Items {
+		sale := &order.Items[i]
+		share := remaining
+		if i < len(order.Items)-1 {
+			share = roundMoney(amount * nets[i] / base)
+			remaining = roundMoney(remaining - share)
+		}
+		sale.Discount = roundMoney(sale.Discount + share)
+		sale.Total, sale.Tax = lineTax(nets[i]-share, sale.TaxRate, order.TaxInclusive)
+		if err := tx.Model(sale).UpdateColumns(map[string]interface{}{
+			"discount": sale.Discount,
+			"total":    sale.Total,
+			"tax":      sale.Tax,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/fiscal"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// fiscalDevice signs sales with the tax authority; nil when fiscalization
+// isn't set up
+var fiscalDevice fiscal.Device
+
+// fiscalClaim is how long one attempt has to send an invoice before
+// another may pick it up
+const fiscalClaim = 2 * time.Minute
+
+// SetFiscalDevice - Installs the device sales are fiscalized with
+func SetFiscalDevice(device fiscal.Device) {
+	fiscalDevice = device
+}
+
+// fiscalRetryDelay is the wait after a failed attempt, doubling from a
+// minute up to an hour
+func fiscalRetryDelay(attempts int) time.Duration {
+	if attempts > 6 {
+		return time.Hour
+	}
+	delay := time.Minute << (attempts - 1)
+	if delay > time.Hour {
+		return time.Hour
+	}
+	return delay
+}
+
+// queueFiscalInvoice records inside the sale's transaction that the order
+// must be reported, so a crash before it is sent can't lose it. Orders
+// waiting on an M-Pesa prompt are queued once the payment confirms.
+func queueFiscalInvoice(tx *gorm.DB, order *models.SaleOrder) error {
+	if fiscalDevice == nil || order.PaymentStatus == models.OrderPendingPayment {
+		return nil
+	}
+	return tx.Create(&models.FiscalInvoice{
+		BusinessID:    order.BusinessID,
+		SaleOrderID:   order.ID,
+		Type:          models.FiscalSale,
+		Status:        models.FiscalPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// queueFiscalCreditNote records inside a void's or return's transaction
+// that the order's sale invoice must be reversed: in full when
+// saleReturnID is 0, otherwise by what was returned. Orders that were
+// never fiscalized, or were voided before their invoice was sent, need
+// nothing.
+func queueFiscalCreditNote(tx *gorm.DB, order *models.SaleOrder, saleReturnID uint) error {
+	var original models.FiscalInvoice
+	err := tx.Where("sale_order_id = ? AND type = ?", order.ID, models.FiscalSale).First(&original).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if original.Status == models.FiscalCancelled {
+		return nil
+	}
+	return tx.Create(&models.FiscalInvoice{
+		BusinessID:    order.BusinessID,
+		SaleOrderID:   order.ID,
+		Type:          models.FiscalCreditNote,
+		SaleReturnID:  saleReturnID,
+		OriginalID:    original.ID,
+		Status:        models.FiscalPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// fiscalizeOrder sends an order's newly queued invoices in the background,
+// so the till isn't kept waiting on the tax authority and the receipt
+// carries the control number as soon as it is signed. Failures stay
+// queued. Orders with nothing queued, such as ones still waiting on
+// payment, are skipped.
+func fiscalizeOrder(orderID uint) {
+	if fiscalDevice == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fiscalClaim)
+		defer cancel()
+
+		// Oldest first, so a sale is signed before its credit notes
+		var invoices []models.FiscalInvoice
+		if err := database.DB.Where("sale_order_id = ? AND status = ?", orderID, models.FiscalPending).
+			Order("id").Find(&invoices).Error; err != nil {
+			log.Printf("Failed to load fiscal invoices for sale order %d: %v", orderID, err)
+			return
+		}
+		for i := range invoices {
+			if err := submitFiscalInvoice(ctx, &invoices[i]); err != nil {
+				log.Printf("Failed to fiscalize sale order %d: %v", orderID, err)
+			}
+		}
+	}()
+}
+
+// submitFiscalInvoice sends a pending invoice that is due, first claiming
+// it so the request path and the retry worker never send it twice. A
+// failed send is retried later; a rejection is left for someone to look
+// at. The error is only for problems saving the outcome.
+func submitFiscalInvoice(ctx context.Context, invoice *models.FiscalInvoice) error {
+	now := time.Now()
+	claim := database.DB.Model(&models.FiscalInvoice{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", invoice.ID, models.FiscalPending, now).
+		UpdateColumn("next_attempt_at", now.Add(fiscalClaim))
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil // Being sent already, or not due
+	}
+
+	var order models.SaleOrder
+	if err := database.DB.Preload("Items").Preload("Items.Product").Preload("Customer").
+		First(&order, invoice.SaleOrderID).Error; err != nil {
+		return err
+	}
+
+	if invoice.Type == models.FiscalCreditNote {
+		var original models.FiscalInvoice
+		if err := database.DB.First(&original, invoice.OriginalID).Error; err != nil {
+			return err
+		}
+		switch original.Status {
+		case models.FiscalSigned:
+		case models.FiscalCancelled:
+			return database.DB.Model(invoice).Update("status", models.FiscalCancelled).Error
+		default:
+			return nil // Sent once the claim lapses, if the sale is signed by then
+		}
+	} else if order.VoidedAt != nil {
+		return database.DB.Model(invoice).Update("status", models.FiscalCancelled).Error
+	}
+
+	document, err := fiscalInvoiceFor(*invoice, order)
+	if err != nil {
+		return err
+	}
+	result, err := fiscalDevice.Submit(ctx, document)
+	invoice.Attempts++
+	updates := map[string]interface{}{"attempts": invoice.Attempts}
+	var rejection fiscal.Rejection
+	switch {
+	case err == nil:
+		updates["status"] = models.FiscalSigned
+		updates["control_number"] = result.ControlNumber
+		updates["qr_code"] = result.QRCode
+		updates["signature"] = result.Signature
+		updates["signed_at"] = result.SignedAt
+		updates["last_error"] = ""
+	case errors.As(err, &rejection):
+		log.Printf("Fiscal invoice %d for sale order %d rejected: %v", invoice.ID, order.ID, err)
+		updates["status"] = models.FiscalRejected
+		updates["last_error"] = err.Error()
+	default:
+		log.Printf("Fiscal invoice %d for sale order %d not sent: %v", invoice.ID, order.ID, err)
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = time.Now().Add(fiscalRetryDelay(invoice.Attempts))
+	}
+	return database.DB.Model(invoice).Updates(updates).Error
+}
+
+// fiscalInvoiceFor describes an invoice for an order the way the fiscal
+// device reports it: the whole order for a sale or a void, or what was
+// brought back for a return
+func fiscalInvoiceFor(invoice models.FiscalInvoice, order models.SaleOrder) (fiscal.Invoice, error) {
+	var cashier models.User
+	database.DB.Unscoped().Select("id, first_name, last_name").First(&cashier, order.UserID)
+
+	var classIDs []uint
+	for _, item := range order.Items {
+		classIDs = append(classIDs, item.TaxClassID)
+	}
+	var classes []models.TaxClass
+	database.DB.Unscoped().Where("id IN (?)", classIDs).Find(&classes)
+	codes := make(map[uint]string)
+	for _, class := range classes {
+		codes[class.ID] = class.Code
+	}
+
+	document := fiscal.Invoice{
+		Number:        invoice.ID,
+		Reference:     receiptNumber(order.ID),
+		IssuedAt:      order.SoldAt,
+		Cashier:       strings.TrimSpace(cashier.FirstName + " " + cashier.LastName),
+		PaymentMethod: order.PaymentMethod,
+	}
+	if order.Customer != nil {
+		document.BuyerName = order.Customer.Name
+	}
+
+	// The part of each line the invoice covers
+	type part struct {
+		sale       models.Sale
+		quantity   float64
+		total, tax float64
+	}
+	var parts []part
+	if invoice.SaleReturnID != 0 {
+		var saleReturn models.SaleReturn
+		if err := database.DB.Preload("Lines").First(&saleReturn, invoice.SaleReturnID).Error; err != nil {
+			return document, err
+		}
+		sales := make(map[uint]models.Sale)
+		for _, item := range order.Items {
+			sales[item.ID] = item
+		}
+		for _, line := range saleReturn.Lines {
+			parts = append(parts, part{sales[line.SaleID], line.UnitQuantity, line.RefundAmount, line.Tax})
+		}
+		document.Reference = fmt.Sprintf("%s-R%d", document.Reference, saleReturn.ID)
+		document.IssuedAt = saleReturn.ReturnedAt
+		document.PaymentMethod = saleReturn.RefundMethod
+	} else {
+		for _, item := range order.Items {
+			parts = append(parts, part{item, soldQuantity(item), item.Total, item.Tax})
+		}
+		if invoice.Type == models.FiscalCreditNote && order.VoidedAt != nil {
+			document.Reference += "-V"
+			document.IssuedAt = *order.VoidedAt
+		}
+	}
+	if invoice.Type == models.FiscalCreditNote {
+		document.OriginalNumber = invoice.OriginalID
+	}
+
+	for _, p := range parts {
+		code := p.sale.Product.SKU
+		if code == "" {
+			code = strconv.FormatUint(uint64(p.sale.ProductID), 10)
+		}
+		// Tax inclusive, whichever way the price was set
+		discount := p.sale.Discount * p.quantity / soldQuantity(p.sale)
+		if !order.TaxInclusive {
+			discount *= 1 + p.sale.TaxRate/100
+		}
+		discount = roundMoney(discount)
+		document.Items = append(document.Items, fiscal.Item{
+			Code:      code,
+			Name:      p.sale.Product.Name,
+			Quantity:  p.quantity,
+			UnitPrice: (p.total + discount) / p.quantity,
+			Discount:  discount,
+			Total:     p.total,
+			TaxCode:   codes[p.sale.TaxClassID],
+			TaxRate:   p.sale.TaxRate,
+			Tax:       p.tax,
+		})
+		document.Total += p.total
+		document.TaxTotal += p.tax
+	}
+	document.Total = roundMoney(document.Total)
+	document.TaxTotal = roundMoney(document.TaxTotal)
+	return document, nil
+}
+
+// StartFiscalRetries - Resends queued fiscal invoices every interval until
+// ctx is done
+func StartFiscalRetries(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				retryFiscalInvoices(ctx)
+			}
+		}
+	}()
+}
+
+// retryFiscalInvoices sends the invoices that are due, oldest first
+func retryFiscalInvoices(ctx context.Context) {
+	var due []models.FiscalInvoice
+	if err := database.DB.Where("status = ? AND next_attempt_at <= ?", models.FiscalPending, time.Now()).
+		Order("next_attempt_at").Limit(50).Find(&due).Error; err != nil {
+		log.Println("Failed to load queued fiscal invoices:", err)
+		return
+	}
+	for i := range due {
+		if err := submitFiscalInvoice(ctx, &due[i]); err != nil {
+			log.Printf("Failed to fiscalize sale order %d: %v", due[i].SaleOrderID, err)
+		}
+	}
+}
+
+// GetFiscalInvoice - Returns whether a sale order has been reported to the
+// tax authority, and its control number once signed, with the credit
+// notes for any void or returns
+func GetFiscalInvoice(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var invoices []models.FiscalInvoice
+	if err := database.DB.Where("business_id = ? AND sale_order_id = ?", businessID, c.Param("id")).
+		Order("id").Find(&invoices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fiscal invoices"})
+		return
+	}
+	if len(invoices) == 0 || invoices[0].Type != models.FiscalSale {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sale order has no fiscal invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoice": invoices[0], "credit_notes": invoices[1:]})
+}
+
+// RetryFiscalInvoice - Sends again a sale order's invoice or credit notes
+// the tax authority rejected, once the problem has been fixed. Invoices
+// still queued are sent if due.
+func RetryFiscalInvoice(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if fiscalDevice == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fiscal device is not set up"})
+		return
+	}
+
+	// Oldest first, so a sale is signed before its credit notes
+	var invoices []models.FiscalInvoice
+	if err := database.DB.Where("business_id = ? AND sale_order_id = ? AND status IN (?)",
+		businessID, c.Param("id"), []string{models.FiscalPending, models.FiscalRejected}).
+		Order("id").Find(&invoices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fiscal invoices"})
+		return
+	}
+	if len(invoices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order has no fiscal invoices waiting to be sent"})
+		return
+	}
+
+	for i := range invoices {
+		invoice := &invoices[i]
+		if invoice.Status == models.FiscalRejected {
+			if err := database.DB.Model(invoice).Updates(map[string]interface{}{
+				"status":          models.FiscalPending,
+				"next_attempt_at": time.Now(),
+			}).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue invoice"})
+				return
+			}
+		}
+		if err := submitFiscalInvoice(c.Request.Context(), invoice); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save fiscal invoice"})
+			return
+		}
+		database.DB.First(invoice, invoice.ID)
+	}
+
+	c.JSON(http.StatusOK, invoices)
+}
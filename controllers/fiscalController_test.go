@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/fiscal"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// fakeDevice is a fiscal device that fails with each of errs in turn, then
+// signs everything
+type fakeDevice struct {
+	mu       sync.Mutex
+	errs     []error
+	invoices []fiscal.Invoice
+}
+
+func (d *fakeDevice) Submit(ctx context.Context, invoice fiscal.Invoice) (fiscal.Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.invoices = append(d.invoices, invoice)
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return fiscal.Result{}, err
+	}
+	return fiscal.Result{
+		ControlNumber: fmt.Sprintf("CU/%d", invoice.Number),
+		Signature:     "SIGNED",
+		SignedAt:      time.Now(),
+	}, nil
+}
+
+// submitted is how many times the device was asked to sign
+func (d *fakeDevice) submitted() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.invoices)
+}
+
+// useFiscalDevice installs device for the rest of the test
+func useFiscalDevice(t *testing.T, device fiscal.Device) {
+	t.Helper()
+	SetFiscalDevice(device)
+	t.Cleanup(func() { SetFiscalDevice(nil) })
+}
+
+func TestFiscalRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		6:  32 * time.Minute,
+		7:  time.Hour,
+		50: time.Hour,
+	} {
+		if got := fiscalRetryDelay(attempts); got != want {
+			t.Errorf("fiscalRetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// testFiscalInvoice records a paid sale and returns the invoice it queued
+func testFiscalInvoice(t *testing.T) models.FiscalInvoice {
+	t.Helper()
+	business, user := testBusiness(t)
+	product := testProduct(t, business, user, 50, 5)
+
+	tx := database.DB.Begin()
+	order := models.SaleOrder{BusinessID: business.ID, UserID: user.ID}
+	if err := createSaleOrder(tx, &order, []saleLineInput{{ProductID: product.ID, Quantity: 2}}, nil, nil); err != nil {
+		tx.Rollback()
+		t.Fatalf("creating sale: %v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var invoice models.FiscalInvoice
+	if err := database.DB.Where("sale_order_id = ? AND type = ?", order.ID, models.FiscalSale).First(&invoice).Error; err != nil {
+		t.Fatalf("sale wasn't queued for fiscalization: %v", err)
+	}
+	return invoice
+}
+
+// reloadFiscalInvoice reads an invoice's saved state
+func reloadFiscalInvoice(t *testing.T, invoice *models.FiscalInvoice) {
+	t.Helper()
+	if err := database.DB.First(invoice, invoice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A failed send stays queued with a back-off, isn't sent again until it is
+// due, and is signed by a later attempt
+func TestSubmitFiscalInvoiceRetries(t *testing.T) {
+	testDB(t)
+	device := &fakeDevice{errs: []error{errors.New("connection refused")}}
+	useFiscalDevice(t, device)
+	invoice := testFiscalInvoice(t)
+
+	before := time.Now()
+	if err := submitFiscalInvoice(context.Background(), &invoice); err != nil {
+		t.Fatal(err)
+	}
+	reloadFiscalInvoice(t, &invoice)
+	if invoice.Status != models.FiscalPending || invoice.Attempts != 1 || invoice.LastError != "connection refused" {
+		t.Fatalf("after a failed send invoice = %+v, want pending after 1 attempt", invoice)
+	}
+	if invoice.NextAttemptAt.Before(before.Add(fiscalRetryDelay(1))) {
+		t.Errorf("next attempt at %v, want a minute after %v", invoice.NextAttemptAt, before)
+	}
+
+	if err := submitFiscalInvoice(context.Background(), &invoice); err != nil {
+		t.Fatal(err)
+	}
+	if n := device.submitted(); n != 1 {
+		t.Errorf("invoice sent %d times before it was due, want once", n)
+	}
+
+	if err := database.DB.Model(&invoice).UpdateColumn("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	reloadFiscalInvoice(t, &invoice)
+	if err := submitFiscalInvoice(context.Background(), &invoice); err != nil {
+		t.Fatal(err)
+	}
+	reloadFiscalInvoice(t, &invoice)
+	if invoice.Status != models.FiscalSigned || invoice.Attempts != 2 || invoice.LastError != "" {
+		t.Errorf("after the retry invoice = %+v, want signed after 2 attempts", invoice)
+	}
+	if want := fmt.Sprintf("CU/%d", invoice.ID); invoice.ControlNumber != want {
+		t.Errorf("control number = %q, want %q", invoice.ControlNumber, want)
+	}
+}
+
+// A rejected invoice is left for someone to fix rather than retried
+func TestSubmitFiscalInvoiceRejected(t *testing.T) {
+	testDB(t)
+	device := &fakeDevice{errs: []error{fiscal.Rejection{Code: "910", Message: "Request parameter error"}}}
+	useFiscalDevice(t, device)
+	invoice := testFiscalInvoice(t)
+
+	if err := submitFiscalInvoice(context.Background(), &invoice); err != nil {
+		t.Fatal(err)
+	}
+	reloadFiscalInvoice(t, &invoice)
+	if invoice.Status != models.FiscalRejected || invoice.Attempts != 1 {
+		t.Fatalf("invoice = %+v, want rejected after 1 attempt", invoice)
+	}
+
+	if err := database.DB.Model(&invoice).UpdateColumn("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := submitFiscalInvoice(context.Background(), &invoice); err != nil {
+		t.Fatal(err)
+	}
+	if n := device.submitted(); n != 1 {
+		t.Errorf("rejected invoice sent %d times, want once", n)
+	}
+}
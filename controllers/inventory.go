@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var (
+	errLotUnavailable     = errors.New("lot not found or sold out")
+	errLotExpired         = errors.New("lot has expired")
+	errLocationNotFound   = errors.New("location not found in your business")
+	errUnitNotFound       = errors.New("unit not found for this product")
+	errFractionalQuantity = errors.New("quantity must be a whole number")
+)
+
+// roundQuantity trims float noise from a quantity computed through a unit
+// conversion. Stock is kept to four decimal places of the base unit.
+func roundQuantity(quantity float64) float64 {
+	return math.Round(quantity*10000) / 10000
+}
+
+func isWhole(quantity float64) bool {
+	return quantity == math.Trunc(quantity)
+}
+
+// resolveUnit returns the product's unit with the given ID, or its base
+// unit when unitID is 0. forSale picks whether the unit must be one the
+// product is sold in or one it is bought in. The returned unit's Price is
+// always filled in.
+func resolveUnit(tx *gorm.DB, product models.Product, unitID uint, forSale bool) (models.ProductUnit, error) {
+	if unitID == 0 {
+		return models.ProductUnit{
+			ProductID:    product.ID,
+			Name:         product.BaseUnit,
+			Factor:       1,
+			Price:        product.Price,
+			AllowDecimal: product.AllowDecimal,
+			ForPurchase:  true,
+			ForSale:      true,
+		}, nil
+	}
+
+	var unit models.ProductUnit
+	err := tx.Where("product_id = ? AND id = ?", product.ID, unitID).First(&unit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return unit, errUnitNotFound
+	} else if err != nil {
+		return unit, err
+	}
+	if (forSale && !unit.ForSale) || (!forSale && !unit.ForPurchase) {
+		return unit, errUnitNotFound
+	}
+	if unit.Price == 0 {
+		unit.Price = unit.Factor * product.Price
+	}
+	return unit, nil
+}
+
+// toBaseQuantity converts a quantity entered in unit to the product's base
+// unit, rejecting fractions that either unit can't be split into.
+func toBaseQuantity(product models.Product, unit models.ProductUnit, quantity float64) (float64, error) {
+	if !unit.AllowDecimal && !isWhole(quantity) {
+		return 0, fmt.Errorf("%w of %s", errFractionalQuantity, unit.Name)
+	}
+	base := roundQuantity(quantity * unit.Factor)
+	if !product.AllowDecimal && !isWhole(base) {
+		return 0, fmt.Errorf("%w of %s", errFractionalQuantity, product.BaseUnit)
+	}
+	return base, nil
+}
+
+// defaultLocation returns the business's default location. The first time
+// a business touches stock it gets a "Main Store", and any stock recorded
+// before locations existed is moved into it.
+func defaultLocation(tx *gorm.DB, businessID uint) (models.Location, error) {
+	var location models.Location
+	err := tx.Where("business_id = ? AND is_default = ?", businessID, true).First(&location).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return location, err
+	}
+
+	// The partial unique index makes concurrent first uses agree on one row
+	now := time.Now()
+	result := tx.Exec(
+		`INSERT INTO locations (created_at, updated_at, business_id, name, is_default)
+		VALUES (?, ?, ?, 'Main Store', true)
+		ON CONFLICT (business_id) WHERE is_default AND deleted_at IS NULL DO NOTHING`,
+		now, now, businessID,
+	)
+	if result.Error != nil {
+		return location, result.Error
+	}
+	if err := tx.Where("business_id = ? AND is_default = ?", businessID, true).First(&location).Error; err != nil {
+		return location, err
+	}
+
+	if result.RowsAffected == 1 {
+		if err := tx.Exec(
+			`INSERT INTO product_stocks (business_id, location_id, product_id, quantity, updated_at)
+			SELECT business_id, ?, id, quantity, ? FROM products
+			WHERE business_id = ? AND deleted_at IS NULL
+			ON CONFLICT (location_id, product_id) DO NOTHING`,
+			location.ID, now, businessID,
+		).Error; err != nil {
+			return location, err
+		}
+		if err := tx.Model(&models.Stock{}).
+			Where("business_id = ? AND (location_id = 0 OR location_id IS NULL)", businessID).
+			UpdateColumn("location_id", location.ID).Error; err != nil {
+			return location, err
+		}
+	}
+
+	return location, nil
+}
+
+// resolveLocation returns the business's location with the given ID, or
+// its default location when locationID is 0.
+func resolveLocation(tx *gorm.DB, businessID, locationID uint) (models.Location, error) {
+	if locationID == 0 {
+		return defaultLocation(tx, businessID)
+	}
+
+	var location models.Location
+	err := tx.Where("business_id = ? AND id = ?", businessID, locationID).First(&location).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return location, errLocationNotFound
+	}
+	return location, err
+}
+
+// moveStock applies m.Quantity (signed) to the product at m.LocationID
+// (the default location when 0) and to the product's total, each in a
+// single conditional UPDATE so concurrent writers can never oversell or
+// lose an update. It then appends m to the ledger with the resulting
+// balances. Decreases that would take stock below zero fail with
+// errInsufficientStock.
+func moveStock(tx *gorm.DB, m *models.StockMovement) error {
+	if m.LocationID == 0 {
+		location, err := defaultLocation(tx, m.BusinessID)
+		if err != nil {
+			return err
+		}
+		m.LocationID = location.ID
+	}
+
+	now := time.Now()
+	if err := tx.Exec(
+		`INSERT INTO product_stocks (business_id, location_id, product_id, quantity, updated_at)
+		VALUES (?, ?, ?, 0, ?)
+		ON CONFLICT (location_id, product_id) DO NOTHING`,
+		m.BusinessID, m.LocationID, m.ProductID, now,
+	).Error; err != nil {
+		return err
+	}
+
+	var locationBalance float64
+	err := tx.Raw(
+		`UPDATE product_stocks SET quantity = quantity + ?, updated_at = ?
+		WHERE location_id = ? AND product_id = ? AND quantity + ? >= 0
+		RETURNING quantity`,
+		m.Quantity, now, m.LocationID, m.ProductID, m.Quantity,
+	).Row().Scan(&locationBalance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInsufficientStock
+	} else if err != nil {
+		return err
+	}
+
+	var balance float64
+	err = tx.Raw(
+		`UPDATE products SET quantity = quantity + ?, updated_at = ?
+		WHERE id = ? AND business_id = ? AND deleted_at IS NULL AND quantity + ? >= 0
+		RETURNING quantity`,
+		m.Quantity, now, m.ProductID, m.BusinessID, m.Quantity,
+	).Row().Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInsufficientStock
+	} else if err != nil {
+		return err
+	}
+
+	m.Balance = balance
+	m.LocationBalance = locationBalance
+	return tx.Create(m).Error
+}
+
+// lockStock locks each product's stock at the location and then its total,
+// taking the products in ascending ID order. moveStock locks the same rows
+// in the same order one product at a time, so a transaction that touches
+// several products in an order of its own can call this first and queue
+// behind others instead of deadlocking with them.
+func lockStock(tx *gorm.DB, businessID, locationID uint, productIDs []uint) error {
+	ids := append([]uint(nil), productIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		if err := tx.Exec(
+			"SELECT 1 FROM product_stocks WHERE location_id = ? AND product_id = ? FOR UPDATE",
+			locationID, id,
+		).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(
+			"SELECT 1 FROM products WHERE id = ? AND business_id = ? FOR UPDATE",
+			id, businessID,
+		).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveStock records an incoming cost layer, updates the product's latest
+// and weighted average cost, and books the receipt to the ledger as a
+// receipt unless m already has a type. The stock's Remaining is set to its
+// full Quantity.
+func receiveStock(tx *gorm.DB, stock *models.Stock, m *models.StockMovement) error {
+	if stock.LocationID == 0 {
+		location, err := defaultLocation(tx, stock.BusinessID)
+		if err != nil {
+			return err
+		}
+		stock.LocationID = location.ID
+	}
+
+	stock.Remaining = stock.Quantity
+	if err := tx.Create(stock).Error; err != nil {
+		return err
+	}
+
+	// Runs before the quantity changes, so quantity here is the old balance
+	if err := tx.Exec(
+		`UPDATE products SET
+			average_cost = CASE WHEN quantity + ? > 0
+				THEN (quantity * COALESCE(average_cost, 0) + ? * ?) / (quantity + ?)
+				ELSE ? END,
+			cost_price = ?
+		WHERE id = ?`,
+		stock.Quantity, stock.Quantity, stock.UnitCost, stock.Quantity, stock.UnitCost,
+		stock.UnitCost, stock.ProductID,
+	).Error; err != nil {
+		return err
+	}
+
+	if m.Type == "" {
+		m.Type = models.MovementReceipt
+	}
+	m.LocationID = stock.LocationID
+	m.Quantity = stock.Quantity
+	m.ReferenceType = "stock"
+	m.ReferenceID = stock.ID
+	return moveStock(tx, m)
+}
+
+// returnStock puts goods a customer brought back on the shelf as a new lot
+// costed at what they were sold for, then books m to the ledger. Unlike
+// receiveStock it leaves the product's latest purchase cost alone.
+func returnStock(tx *gorm.DB, stock *models.Stock, m *models.StockMovement) error {
+	stock.Remaining = stock.Quantity
+	if err := tx.Create(stock).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Exec(
+		`UPDATE products SET average_cost = CASE WHEN quantity + ? > 0
+			THEN (quantity * COALESCE(average_cost, 0) + ? * ?) / (quantity + ?)
+			ELSE average_cost END
+		WHERE id = ?`,
+		stock.Quantity, stock.Quantity, stock.UnitCost, stock.Quantity, stock.ProductID,
+	).Error; err != nil {
+		return err
+	}
+
+	m.Type = models.MovementReturn
+	m.LocationID = stock.LocationID
+	m.Quantity = stock.Quantity
+	return moveStock(tx, m)
+}
+
+// issueStock books a decrease of -m.Quantity units to the ledger and
+// consumes lots at the movement's location first-expiring first (then
+// oldest first), starting with lotID when it is non-zero. It returns the cost of the goods issued under
+// the given costing method. Expired lots are left alone, and asking for one
+// fails with errLotExpired, unless includeExpired is set for write-offs.
+func issueStock(tx *gorm.DB, m *models.StockMovement, costingMethod string, lotID uint, includeExpired bool) (float64, error) {
+	if err := moveStock(tx, m); err != nil {
+		return 0, err
+	}
+
+	var product models.Product
+	if err := tx.Select("id, cost_price, average_cost").First(&product, m.ProductID).Error; err != nil {
+		return 0, err
+	}
+
+	var layers []models.Stock
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("product_id = ? AND location_id = ? AND remaining > 0", m.ProductID, m.LocationID).
+		Order(gorm.Expr("id = ? DESC, expires_at ASC NULLS LAST, added_at, id", lotID)).
+		Find(&layers).Error; err != nil {
+		return 0, err
+	}
+	if lotID != 0 && (len(layers) == 0 || layers[0].ID != lotID) {
+		return 0, errLotUnavailable
+	}
+	if !includeExpired {
+		now := time.Now()
+		var expired float64
+		fresh := layers[:0]
+		for _, layer := range layers {
+			if layer.ExpiresAt == nil || !layer.ExpiresAt.Before(now) {
+				fresh = append(fresh, layer)
+				continue
+			}
+			if layer.ID == lotID {
+				return 0, errLotExpired
+			}
+			expired += layer.Remaining
+		}
+		layers = fresh
+		// Units without a lot are sold at average cost below, but expired
+		// units must stay behind on the shelf to be written off
+		if roundQuantity(m.LocationBalance) < roundQuantity(expired) {
+			return 0, errInsufficientStock
+		}
+	}
+
+	needed := -m.Quantity
+	var fifoCost float64
+	for _, layer := range layers {
+		if needed <= 0 {
+			break
+		}
+		take := layer.Remaining
+		if take > needed {
+			take = needed
+		}
+		if err := tx.Model(&layer).UpdateColumn("remaining", roundQuantity(layer.Remaining-take)).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Create(&models.StockConsumption{
+			MovementID: m.ID,
+			StockID:    layer.ID,
+			Quantity:   take,
+			UnitCost:   layer.UnitCost,
+		}).Error; err != nil {
+			return 0, err
+		}
+		fifoCost += take * layer.UnitCost
+		needed = roundQuantity(needed - take)
+	}
+	// Stock received before cost layers existed is valued at average cost
+	if needed > 0 {
+		fifoCost += needed * product.AverageCost
+	}
+
+	switch costingMethod {
+	case models.CostingAverage:
+		return -m.Quantity * product.AverageCost, nil
+	case models.CostingLatest:
+		return -m.Quantity * product.CostPrice, nil
+	default:
+		return fifoCost, nil
+	}
+}
+
+// restoreStock undoes an earlier decrease: the units go back into the lots
+// they were taken from, and m is booked to the ledger as the opposite of
+// issued at the same location.
+func restoreStock(tx *gorm.DB, issued models.StockMovement, m *models.StockMovement) error {
+	var consumptions []models.StockConsumption
+	if err := tx.Where("movement_id = ?", issued.ID).Find(&consumptions).Error; err != nil {
+		return err
+	}
+	for _, consumption := range consumptions {
+		if err := tx.Model(&models.Stock{}).Where("id = ?", consumption.StockID).
+			UpdateColumn("remaining", gorm.Expr("remaining + ?", consumption.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+
+	m.ProductID = issued.ProductID
+	m.LocationID = issued.LocationID
+	m.Quantity = -issued.Quantity
+	return moveStock(tx, m)
+}
@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// stockedProducts limits a product_stocks query to products whose own
+// quantity is what they have available; kits and variant parents aren't
+const stockedProducts = "product_id IN (SELECT id FROM products WHERE is_kit IS NOT TRUE AND has_variants IS NOT TRUE)"
+
+// kitAvailability returns, per kit, how many more kits the components at
+// locationID (the whole business when 0) can build, limited by the
+// scarcest component. Assembled kit stock is not included.
+func kitAvailability(db *gorm.DB, businessID, locationID uint) (map[uint]float64, error) {
+	rows, err := db.Raw(
+		`SELECT k.kit_id, FLOOR(MIN(
+			CASE WHEN ? = 0 THEN c.quantity ELSE COALESCE(ps.quantity, 0) END / k.quantity))
+		FROM kit_components k
+		JOIN products kit ON kit.id = k.kit_id
+		JOIN products c ON c.id = k.component_id
+		LEFT JOIN product_stocks ps ON ps.product_id = k.component_id AND ps.location_id = ?
+		WHERE kit.business_id = ? AND kit.deleted_at IS NULL AND k.deleted_at IS NULL AND k.quantity > 0
+		GROUP BY k.kit_id`,
+		locationID, locationID, businessID,
+	).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	available := make(map[uint]float64)
+	for rows.Next() {
+		var kitID uint
+		var buildable float64
+		if err := rows.Scan(&kitID, &buildable); err != nil {
+			return nil, err
+		}
+		available[kitID] = buildable
+	}
+	return available, rows.Err()
+}
+
+// lowStockKits returns the kits whose assembled stock plus buildable
+// quantity is at most maxQuantity, with Quantity set to that total
+func lowStockKits(businessID, locationID uint, maxQuantity int) ([]models.Product, error) {
+	available, err := kitAvailability(database.DB, businessID, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var kits []models.Product
+	if err := database.DB.Where("business_id = ? AND is_kit = ?", businessID, true).Find(&kits).Error; err != nil {
+		return nil, err
+	}
+
+	assembled := make(map[uint]float64)
+	if locationID != 0 && len(kits) > 0 {
+		var levels []models.ProductStock
+		if err := database.DB.Where("location_id = ? AND product_id IN (?)", locationID, productIDs(kits)).
+			Find(&levels).Error; err != nil {
+			return nil, err
+		}
+		for _, level := range levels {
+			assembled[level.ProductID] = level.Quantity
+		}
+	} else {
+		for _, kit := range kits {
+			assembled[kit.ID] = kit.Quantity
+		}
+	}
+
+	var low []models.Product
+	for _, kit := range kits {
+		kit.Quantity = assembled[kit.ID] + available[kit.ID]
+		if kit.Quantity <= float64(maxQuantity) {
+			low = append(low, kit)
+		}
+	}
+	return low, nil
+}
+
+func productIDs(products []models.Product) []uint {
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	return ids
+}
+
+// issueKit books a sale of -m.Quantity kits, taking assembled kit stock at
+// the movement's location first and building any shortfall from the
+// components on the spot. It returns the combined cost of goods.
+func issueKit(tx *gorm.DB, kit models.Product, m *models.StockMovement, costingMethod string) (float64, error) {
+	quantity := -m.Quantity
+
+	var assembled float64
+	err := tx.Raw(
+		"SELECT quantity FROM product_stocks WHERE location_id = ? AND product_id = ? FOR UPDATE",
+		m.LocationID, kit.ID,
+	).Row().Scan(&assembled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	var cost float64
+	if fromStock := math.Min(assembled, quantity); fromStock > 0 {
+		movement := *m
+		movement.Quantity = -fromStock
+		kitCost, err := issueStock(tx, &movement, costingMethod, 0, false)
+		if err != nil {
+			return 0, err
+		}
+		cost += kitCost
+		quantity = roundQuantity(quantity - fromStock)
+	}
+	if quantity == 0 {
+		return cost, nil
+	}
+
+	var components []models.KitComponent
+	if err := tx.Preload("Component").Where("kit_id = ?", kit.ID).Order("component_id").Find(&components).Error; err != nil {
+		return 0, err
+	}
+	if len(components) == 0 {
+		return 0, fmt.Errorf("%w for %s", errInsufficientStock, kit.Name)
+	}
+	for _, component := range components {
+		movement := *m
+		movement.ProductID = component.ComponentID
+		movement.Quantity = -roundQuantity(quantity * component.Quantity)
+		movement.Reason = "Sold in " + kit.Name
+		componentCost, err := issueStock(tx, &movement, costingMethod, 0, false)
+		if errors.Is(err, errInsufficientStock) {
+			return 0, fmt.Errorf("%w of %s for %s", errInsufficientStock, component.Component.Name, kit.Name)
+		} else if err != nil {
+			return 0, err
+		}
+		cost += componentCost
+	}
+	return cost, nil
+}
+
+// SetKitComponents - Replaces a product's bill of materials. An empty list
+// turns the kit back into an ordinary product.
+func SetKitComponents(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Components []struct {
+			ProductID uint    `json:"product_id" binding:"required"`
+			Quantity  float64 `json:"quantity" binding:"required,gt=0"` // Base units per kit
+		} `json:"components" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var kit models.Product
+	if err := database.DB.
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&kit).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+	if kit.HasVariants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set components on each variant instead"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Unscoped().Where("kit_id = ?", kit.ID).Delete(&models.KitComponent{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update components"})
+		return
+	}
+
+	seen := make(map[uint]bool)
+	for _, in := range input.Components {
+		var component models.Product
+		if err := tx.Where("business_id = ? AND id = ?", businessID, in.ProductID).First(&component).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Product %d not found in your business", in.ProductID)})
+			return
+		}
+		if component.ID == kit.ID || component.IsKit || component.HasVariants || seen[component.ID] {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": component.Name + " cannot be a component of this kit"})
+			return
+		}
+		if !component.AllowDecimal && !isWhole(in.Quantity) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Quantity of %s must be a whole number of %s", component.Name, component.BaseUnit)})
+			return
+		}
+		seen[component.ID] = true
+
+		line := models.KitComponent{KitID: kit.ID, ComponentID: component.ID, Quantity: in.Quantity}
+		if err := tx.Create(&line).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save components"})
+			return
+		}
+	}
+
+	// A kit can't itself be used as a component
+	var usedIn int64
+	if err := tx.Model(&models.KitComponent{}).Where("component_id = ?", kit.ID).Count(&usedIn).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check components"})
+		return
+	}
+	if usedIn > 0 && len(input.Components) > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "This product is a component of another kit"})
+		return
+	}
+
+	if err := tx.Model(&kit).Update("is_kit", len(input.Components) > 0).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Components saved", "is_kit": len(input.Components) > 0})
+}
+
+// GetKitComponents - Lists a kit's components and how many kits can be
+// supplied from assembled stock plus what the components can still build
+func GetKitComponents(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	locationID, ok := locationFilter(c)
+	if !ok {
+		return
+	}
+
+	var kit models.Product
+	if err := database.DB.Preload("Components").Preload("Components.Component").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&kit).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+
+	available, err := kitAvailability(database.DB, businessID.(uint), locationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to work out availability"})
+		return
+	}
+
+	assembled := kit.Quantity
+	if locationID != 0 {
+		var level models.ProductStock
+		if err := database.DB.Where("location_id = ? AND product_id = ?", locationID, kit.ID).
+			First(&level).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock level"})
+			return
+		}
+		assembled = level.Quantity
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"components": kit.Components,
+		"assembled":  assembled,
+		"buildable":  available[kit.ID],
+		"available":  assembled + available[kit.ID],
+	})
+}
+
+// AssembleKit - Turns components into finished kit stock ahead of time.
+// The kits are costed at what their components cost.
+func AssembleKit(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Quantity   float64 `json:"quantity" binding:"required,gt=0"`
+		LocationID uint    `json:"location_id"` // Defaults to the main store
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var kit models.Product
+	if err := database.DB.Preload("Components", func(db *gorm.DB) *gorm.DB { return db.Order("component_id") }).
+		Preload("Components.Component").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&kit).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+	if !kit.IsKit || len(kit.Components) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product has no components to assemble"})
+		return
+	}
+	if !kit.AllowDecimal && !isWhole(input.Quantity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be a whole number of " + kit.BaseUnit})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	location, err := resolveLocation(tx, businessID.(uint), input.LocationID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var business models.Business
+	if err := tx.Select("id, costing_method").First(&business, businessID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load business"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	var cost float64
+	for _, component := range kit.Components {
+		movement := models.StockMovement{
+			BusinessID:    kit.BusinessID,
+			ProductID:     component.ComponentID,
+			LocationID:    location.ID,
+			Type:          models.MovementAssembly,
+			Quantity:      -roundQuantity(input.Quantity * component.Quantity),
+			Reason:        "Assembled into " + kit.Name,
+			UserID:        userID,
+			ReferenceType: "kit",
+			ReferenceID:   kit.ID,
+		}
+		componentCost, err := issueStock(tx, &movement, business.CostingMethod, 0, false)
+		if errors.Is(err, errInsufficientStock) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock of " + component.Component.Name})
+			return
+		} else if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+			return
+		}
+		cost += componentCost
+	}
+
+	stock := models.Stock{
+		BusinessID: kit.BusinessID,
+		ProductID:  kit.ID,
+		LocationID: location.ID,
+		Quantity:   input.Quantity,
+		UnitCost:   cost / input.Quantity,
+		Assembled:  true,
+		AddedAt:    time.Now(),
+	}
+	movement := models.StockMovement{
+		BusinessID: kit.BusinessID,
+		ProductID:  kit.ID,
+		Type:       models.MovementAssembly,
+		Reason:     "Assembled",
+		UserID:     userID,
+	}
+	if err := receiveStock(tx, &stock, &movement); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   fmt.Sprintf("Assembled %s x %s", formatQuantity(input.Quantity, kit.BaseUnit), kit.Name),
+		"unit_cost": stock.UnitCost,
+		"balance":   movement.Balance,
+	})
+}
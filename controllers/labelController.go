@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// maxLabels keeps a single request from producing an unprintable PDF
+const maxLabels = 2000
+
+// labelLayout is an A4 label sheet, measured in mm
+type labelLayout struct {
+	Columns, Rows int
+	Width, Height float64 // One label
+	Left, Top     float64 // Sheet margins
+}
+
+var labelLayouts = map[string]labelLayout{
+	"3x8":  {Columns: 3, Rows: 8, Width: 70, Height: 37, Left: 0, Top: 0.5},
+	"4x10": {Columns: 4, Rows: 10, Width: 48.5, Height: 25.4, Left: 8, Top: 21.5},
+}
+
+// shelfLabel is what gets printed on one label
+type shelfLabel struct {
+	Name      string
+	Price     float64
+	Code      string
+	Symbology string
+}
+
+// labelFor picks the code to print for a product: its first barcode, else
+// its SKU, else its ID
+func labelFor(product models.Product) shelfLabel {
+	label := shelfLabel{Name: product.Name, Price: product.Price, Symbology: models.BarcodeCode128}
+	switch {
+	case len(product.Barcodes) > 0:
+		label.Code = product.Barcodes[0].Code
+		label.Symbology = product.Barcodes[0].Symbology
+	case product.SKU != "" && validateBarcode(models.BarcodeCode128, product.SKU) == nil:
+		label.Code = product.SKU
+	default:
+		label.Code = fmt.Sprintf("P%d", product.ID)
+	}
+	return label
+}
+
+// GenerateLabels - Renders shelf labels with barcodes onto an A4 label
+// sheet, either for chosen products or for what a purchase order received
+func GenerateLabels(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Layout string `json:"layout" binding:"required"` // "3x8" or "4x10"
+		Skip   int    `json:"skip" binding:"min=0"`      // Labels already used at the start of the first sheet
+		Items  []struct {
+			ProductID uint `json:"product_id" binding:"required"`
+			Copies    int  `json:"copies" binding:"required,gt=0"`
+		} `json:"items" binding:"dive"`
+		PurchaseOrderID uint `json:"purchase_order_id"` // One label per unit received
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	layout, ok := labelLayouts[input.Layout]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Layout must be 3x8 or 4x10"})
+		return
+	}
+	if len(input.Items) == 0 && input.PurchaseOrderID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose products or a purchase order to label"})
+		return
+	}
+
+	var labels []shelfLabel
+	for _, item := range input.Items {
+		var product models.Product
+		if err := database.DB.Preload("Barcodes").
+			Where("business_id = ? AND id = ?", businessID, item.ProductID).
+			First(&product).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Product %d not found in your business", item.ProductID)})
+			return
+		}
+		for i := 0; i < item.Copies && len(labels) <= maxLabels; i++ {
+			labels = append(labels, labelFor(product))
+		}
+	}
+
+	if input.PurchaseOrderID != 0 {
+		var order models.PurchaseOrder
+		if err := database.DB.Preload("Lines").Preload("Lines.Product").Preload("Lines.Product.Barcodes").
+			Where("business_id = ? AND id = ?", businessID, input.PurchaseOrderID).
+			First(&order).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+			return
+		}
+		for _, line := range order.Lines {
+			factor := line.Factor
+			if factor == 0 {
+				factor = 1
+			}
+			// Goods sold by weight or length get one label per line
+			copies := 1
+			if !line.Product.AllowDecimal {
+				copies = int(math.Ceil(line.QuantityReceived * factor))
+			}
+			for i := 0; i < copies && len(labels) <= maxLabels; i++ {
+				labels = append(labels, labelFor(line.Product))
+			}
+		}
+	}
+
+	if len(labels) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to label"})
+		return
+	}
+	if len(labels) > maxLabels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d labels can be printed at once", maxLabels)})
+		return
+	}
+
+	pdf := generateLabelsPDF(labels, layout, input.Skip)
+
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", "attachment; filename=labels.pdf")
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+func generateLabelsPDF(labels []shelfLabel, layout labelLayout, skip int) []byte {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+
+	perSheet := layout.Columns * layout.Rows
+	skip %= perSheet
+	for i, label := range labels {
+		slot := (i + skip) % perSheet
+		if i == 0 || slot == 0 {
+			pdf.AddPage()
+		}
+		x := layout.Left + float64(slot%layout.Columns)*layout.Width
+		y := layout.Top + float64(slot/layout.Columns)*layout.Height
+		drawLabel(pdf, label, x, y, layout.Width, layout.Height)
+	}
+
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// drawLabel prints the product name at the top, the barcode with its
+// human-readable code in the middle and the price at the bottom
+func drawLabel(pdf *gofpdf.Fpdf, label shelfLabel, x, y, width, height float64) {
+	padding := 2.5
+	inner := width - 2*padding
+	fontSize := math.Min(10, height/3.5)
+
+	pdf.SetFont("Arial", "", fontSize)
+	name := label.Name
+	for len(name) > 3 && pdf.GetStringWidth(name) > inner {
+		name = name[:len(name)-4] + "..."
+	}
+	pdf.SetXY(x+padding, y+padding)
+	pdf.CellFormat(inner, fontSize*0.45, name, "", 0, "C", false, 0, "")
+
+	// Bars take what's left between the name and the code/price lines, with
+	// a ten-module quiet zone either side so scanners find the edges
+	modules := encodeBarcode(label.Symbology, label.Code)
+	moduleWidth := inner / float64(len(modules)+20)
+	barsLeft := x + padding + 10*moduleWidth
+	barsTop := y + padding + fontSize*0.5
+	barsHeight := height - 2*padding - fontSize*1.5
+	for i := 0; i < len(modules); {
+		if modules[i] != '1' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(modules) && modules[i] == '1' {
+			i++
+		}
+		pdf.Rect(barsLeft+float64(start)*moduleWidth, barsTop, float64(i-start)*moduleWidth, barsHeight, "F")
+	}
+
+	pdf.SetFont("Arial", "", fontSize*0.7)
+	pdf.SetXY(x+padding, barsTop+barsHeight)
+	pdf.CellFormat(inner, fontSize*0.35, label.Code, "", 0, "C", false, 0, "")
+
+	pdf.SetFont("Arial", "B", fontSize)
+	pdf.SetXY(x+padding, barsTop+barsHeight+fontSize*0.35)
+	pdf.CellFormat(inner, fontSize*0.5, fmt.Sprintf("ksh %.2f", label.Price), "", 0, "C", false, 0, "")
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// locationFilter reads the optional ?location_id= filter. ok is false, and
+// the error response written, when the value is malformed.
+func locationFilter(c *gin.Context) (locationID uint, ok bool) {
+	raw := c.Query("location_id")
+	if raw == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location_id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func CreateLocation(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input struct {
+		Name    string `json:"name" binding:"required,min=2,max=50"`
+		Address string `json:"address"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// Make sure the main store exists first, so a new shop never becomes
+	// the default by accident and takes over existing stock
+	if _, err := defaultLocation(tx, businessID.(uint)); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up default location"})
+		return
+	}
+
+	var existing models.Location
+	if err := tx.Where("business_id = ? AND LOWER(name) = ?", businessID, strings.ToLower(strings.TrimSpace(input.Name))).
+		First(&existing).Error; err == nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Location name already exists in your business"})
+		return
+	}
+
+	location := models.Location{
+		BusinessID: businessID.(uint),
+		Name:       strings.TrimSpace(input.Name),
+		Address:    input.Address,
+	}
+	if err := tx.Create(&location).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create location"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, location)
+}
+
+func GetLocations(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if _, err := defaultLocation(database.DB, businessID.(uint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up default location"})
+		return
+	}
+
+	var locations []models.Location
+	if err := database.DB.Where("business_id = ?", businessID).Order("is_default DESC, name").Find(&locations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, locations)
+}
+
+func UpdateLocation(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Name    string `json:"name" binding:"required,min=2,max=50"`
+		Address string `json:"address"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var location models.Location
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&location).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	location.Name = strings.TrimSpace(input.Name)
+	location.Address = input.Address
+	if err := database.DB.Save(&location).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+		return
+	}
+
+	c.JSON(http.StatusOK, location)
+}
+
+// SetDefaultLocation - Makes a location the one used when requests don't name one
+func SetDefaultLocation(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	var location models.Location
+	if err := tx.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&location).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	if err := tx.Model(&models.Location{}).
+		Where("business_id = ? AND is_default = ?", businessID, true).
+		Update("is_default", false).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update locations"})
+		return
+	}
+	if err := tx.Model(&location).Update("is_default", true).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, location)
+}
+
+// GetLocationStock - Lists what a location holds
+func GetLocationStock(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var location models.Location
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&location).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var levels []models.ProductStock
+	if err := database.DB.Preload("Product").
+		Where("location_id = ?", location.ID).
+		Find(&levels).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"location": location,
+		"stock":    levels,
+	})
+}
@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+	"github.com/ken-eddy/stockApp/payments"
+)
+
+// paymentGateway sends M-Pesa prompts; nil when M-Pesa isn't set up
+var paymentGateway payments.Gateway
+
+// callbackToken is the secret the gateway's callbacks must carry
+var callbackToken string
+
+// SetPaymentGateway - Installs the gateway used for M-Pesa prompts. token
+// is the secret callbacks must send as ?token=; while it is empty every
+// callback is refused.
+func SetPaymentGateway(gateway payments.Gateway, token string) {
+	paymentGateway = gateway
+	callbackToken = token
+}
+
+// promptPendingPayments sends a prompt for each of the order's pending
+// M-Pesa tenders that hasn't had one yet. It runs after the sale is
+// committed, so a slow or failed request never holds locks; a failed
+// prompt marks the tender failed so it can be retried.
+func promptPendingPayments(ctx context.Context, order *models.SaleOrder) {
+	for i := range order.Payments {
+		payment := &order.Payments[i]
+		if payment.Status != models.SalePaymentPending || payment.CheckoutID != "" {
+			continue
+		}
+
+		updates := map[string]interface{}{}
+		initiation, err := paymentGateway.Initiate(ctx, payments.Request{
+			Phone:       payment.Phone,
+			Amount:      payment.Amount,
+			Reference:   receiptNumber(order.ID),
+			Description: "Sale " + receiptNumber(order.ID),
+		})
+		if err != nil {
+			log.Printf("M-Pesa prompt for sale payment %d failed: %v", payment.ID, err)
+			payment.Status, payment.Message = models.SalePaymentFailed, err.Error()
+			updates["status"], updates["message"] = payment.Status, payment.Message
+		} else {
+			payment.CheckoutID, payment.Message = initiation.CheckoutID, initiation.CustomerMessage
+			updates["checkout_id"], updates["message"] = payment.CheckoutID, payment.Message
+		}
+		if err := database.DB.Model(payment).Updates(updates).Error; err != nil {
+			log.Printf("Failed to save M-Pesa prompt for sale payment %d: %v", payment.ID, err)
+		}
+	}
+}
+
+// receiptNumber is how an order is numbered on receipts and prompts
+func receiptNumber(orderID uint) string {
+	return fmt.Sprintf("%06d", orderID)
+}
+
+// applyPaymentResult records a gateway's outcome for a pending payment,
+// which must be locked, and marks the order paid once nothing is left
+// pending, queueing its fiscal invoice. Results for payments that have already settled are ignored, so
+// repeated callbacks and status checks are harmless. Money that arrives
+// for a voided order is flagged for refund rather than settling it.
+func applyPaymentResult(tx *gorm.DB, payment *models.SalePayment, result payments.Result) error {
+	if payment.Status != models.SalePaymentPending || result.Status == payments.StatusPending {
+		return nil
+	}
+
+	var order models.SaleOrder
+	if err := tx.First(&order, payment.SaleOrderID).Error; err != nil {
+		return err
+	}
+
+	payment.Status, payment.Message = models.SalePaymentFailed, result.Message
+	if result.Status == payments.StatusCompleted {
+		if result.Amount > 0 && result.Amount < payment.Amount {
+			payment.Message = fmt.Sprintf("Paid %.2f of %.2f", result.Amount, payment.Amount)
+		} else if order.VoidedAt != nil {
+			payment.Status, payment.Message = models.SalePaymentRefundDue, "Paid after the sale was voided; refund the customer"
+			if result.Receipt != "" {
+				payment.Reference = result.Receipt
+			}
+		} else {
+			payment.Status = models.SalePaymentCompleted
+			if result.Receipt != "" {
+				payment.Reference = result.Receipt
+			}
+		}
+	}
+	if err := tx.Model(payment).Updates(map[string]interface{}{
+		"status":    payment.Status,
+		"message":   payment.Message,
+		"reference": payment.Reference,
+	}).Error; err != nil {
+		return err
+	}
+	if payment.Status != models.SalePaymentCompleted {
+		return nil
+	}
+
+	var outstanding int
+	if err := tx.Model(&models.SalePayment{}).
+		Where("sale_order_id = ? AND status <> ?", payment.SaleOrderID, models.SalePaymentCompleted).
+		Count(&outstanding).Error; err != nil {
+		return err
+	}
+	if outstanding > 0 {
+		return nil
+	}
+
+	order.PaymentStatus = models.OrderPaid
+	if err := tx.Model(&order).UpdateColumn("payment_status", order.PaymentStatus).Error; err != nil {
+		return err
+	}
+	return queueFiscalInvoice(tx, &order)
+}
+
+// MpesaCallback - Receives Daraja's STK push result. It is public, so it
+// trusts nothing but the checkout ID of a prompt we sent; Daraja may post
+// the same result more than once.
+func MpesaCallback(c *gin.Context) {
+	// Daraja retries anything but this reply, so it is sent even for
+	// callbacks that are ignored
+	accepted := gin.H{"ResultCode": 0, "ResultDesc": "Accepted"}
+
+	if paymentGateway == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errGatewayUnavailable.Error()})
+		return
+	}
+	if callbackToken == "" || subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(callbackToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid callback token"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read callback"})
+		return
+	}
+	result, err := paymentGateway.ParseCallback(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	var payment models.SalePayment
+	err = tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("checkout_id = ?", result.CheckoutID).
+		First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		log.Printf("M-Pesa callback for unknown checkout %s", result.CheckoutID)
+		c.JSON(http.StatusOK, accepted)
+		return
+	} else if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
+	if err := applyPaymentResult(tx, &payment, result); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	tx.Commit()
+	fiscalizeOrder(payment.SaleOrderID)
+
+	c.JSON(http.StatusOK, accepted)
+}
+
+// findOrderPayment loads one of a sale order's payments for the business
+func findOrderPayment(db *gorm.DB, c *gin.Context, businessID interface{}) (models.SalePayment, error) {
+	var payment models.SalePayment
+	err := db.Where("business_id = ? AND sale_order_id = ? AND id = ?", businessID, c.Param("id"), c.Param("payment_id")).
+		First(&payment).Error
+	return payment, err
+}
+
+// MobilePaymentStatus - Asks the gateway about a pending M-Pesa prompt and
+// records the answer, for when a callback is slow or never comes
+func MobilePaymentStatus(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	payment, err := findOrderPayment(database.DB, c, businessID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if payment.Status != models.SalePaymentPending || payment.CheckoutID == "" {
+		c.JSON(http.StatusOK, payment)
+		return
+	}
+	if paymentGateway == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errGatewayUnavailable.Error()})
+		return
+	}
+
+	result, err := paymentGateway.Query(c.Request.Context(), payment.CheckoutID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+	// Re-read under lock in case the callback landed meanwhile
+	if payment, err = findOrderPayment(tx.Set("gorm:query_option", "FOR UPDATE"), c, businessID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+	if err := applyPaymentResult(tx, &payment, result); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+	tx.Commit()
+	fiscalizeOrder(payment.SaleOrderID)
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// RetryMobilePayment - Sends a fresh prompt for an M-Pesa tender that
+// failed or was cancelled, optionally to a different phone number
+func RetryMobilePayment(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Phone string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if paymentGateway == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errGatewayUnavailable.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	var order models.SaleOrder
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sale order not found"})
+		return
+	}
+	if order.VoidedAt != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order has been voided"})
+		return
+	}
+
+	payment, err := findOrderPayment(tx.Set("gorm:query_option", "FOR UPDATE"), c, businessID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if payment.Method != models.PaymentMpesa || payment.Status != models.SalePaymentFailed {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only failed M-Pesa payments can be retried"})
+		return
+	}
+
+	phone := payment.Phone
+	if input.Phone != "" {
+		if phone, err = payments.NormalizePhone(input.Phone); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	payment.Phone, payment.Status, payment.CheckoutID, payment.Message = phone, models.SalePaymentPending, "", ""
+	if err := tx.Model(&payment).Updates(map[string]interface{}{
+		"phone":       payment.Phone,
+		"status":      payment.Status,
+		"checkout_id": payment.CheckoutID,
+		"message":     payment.Message,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
+
+	tx.Commit()
+
+	order.Payments = []models.SalePayment{payment}
+	promptPendingPayments(c.Request.Context(), &order)
+
+	c.JSON(http.StatusOK, order.Payments[0])
+}
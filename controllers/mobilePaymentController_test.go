@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/config"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+	"github.com/ken-eddy/stockApp/payments"
+)
+
+// postMpesaCallback sends body to MpesaCallback as Daraja would
+func postMpesaCallback(token, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/payments/mpesa/callback?token="+token, strings.NewReader(body))
+	MpesaCallback(c)
+	return w
+}
+
+// useMpesa installs a Daraja gateway whose callbacks must carry the token
+// "secret", for the rest of the test
+func useMpesa(t *testing.T) {
+	t.Helper()
+	SetPaymentGateway(payments.NewMpesa(config.MpesaConfig{}, nil), "secret")
+	t.Cleanup(func() { SetPaymentGateway(nil, "") })
+}
+
+func TestMpesaCallbackWrongToken(t *testing.T) {
+	useMpesa(t)
+	if w := postMpesaCallback("guess", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", w.Code)
+	}
+}
+
+// Without a token there is nothing to tell Daraja from anyone else
+func TestMpesaCallbackNoToken(t *testing.T) {
+	SetPaymentGateway(payments.NewMpesa(config.MpesaConfig{}, nil), "")
+	t.Cleanup(func() { SetPaymentGateway(nil, "") })
+	if w := postMpesaCallback("", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", w.Code)
+	}
+}
+
+// testMpesaSale creates a 100 shilling sale waiting on an M-Pesa prompt,
+// and the callback Daraja posts once the customer pays it
+func testMpesaSale(t *testing.T) (models.SaleOrder, models.SalePayment, string) {
+	t.Helper()
+	business, user := testBusiness(t)
+	order := models.SaleOrder{
+		BusinessID:    business.ID,
+		UserID:        user.ID,
+		Total:         100,
+		PaymentMethod: models.PaymentMpesa,
+		PaymentStatus: models.OrderPendingPayment,
+		SoldAt:        time.Now(),
+	}
+	if err := database.DB.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	payment := models.SalePayment{
+		BusinessID:  business.ID,
+		SaleOrderID: order.ID,
+		Method:      models.PaymentMpesa,
+		Amount:      100,
+		Status:      models.SalePaymentPending,
+		Phone:       "254708374149",
+		CheckoutID:  fmt.Sprintf("ws_CO_%d", time.Now().UnixNano()),
+		PaidAt:      order.SoldAt,
+	}
+	if err := database.DB.Create(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	callback := fmt.Sprintf(`{"Body":{"stkCallback":{"MerchantRequestID":"29115-34620561-1","CheckoutRequestID":%q,
+		"ResultCode":0,"ResultDesc":"The service request is processed successfully.",
+		"CallbackMetadata":{"Item":[{"Name":"Amount","Value":100},{"Name":"MpesaReceiptNumber","Value":"NLJ7RT61SV"},
+		{"Name":"TransactionDate","Value":20191219102115},{"Name":"PhoneNumber","Value":254708374149}]}}}}`, payment.CheckoutID)
+	return order, payment, callback
+}
+
+// Daraja may post the same result more than once; only the first may
+// settle the payment and queue the order's fiscal invoice
+func TestMpesaCallbackDuplicate(t *testing.T) {
+	testDB(t)
+	useMpesa(t)
+	device := &fakeDevice{}
+	useFiscalDevice(t, device)
+	order, payment, body := testMpesaSale(t)
+
+	for i := 0; i < 2; i++ {
+		if w := postMpesaCallback("secret", body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"ResultCode":0`) {
+			t.Fatalf("callback %d: status %d %s, want it accepted", i+1, w.Code, w.Body)
+		}
+	}
+
+	if err := database.DB.First(&payment, payment.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != models.SalePaymentCompleted || payment.Reference != "NLJ7RT61SV" {
+		t.Errorf("payment = %+v, want completed with the M-Pesa receipt", payment)
+	}
+	if err := database.DB.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.PaymentStatus != models.OrderPaid {
+		t.Errorf("order payment status = %q, want paid", order.PaymentStatus)
+	}
+
+	// The invoice is sent in the background; wait for it before the device
+	// is uninstalled
+	var invoices []models.FiscalInvoice
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if err := database.DB.Where("sale_order_id = ?", order.ID).Find(&invoices).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(invoices) != 1 || invoices[0].Status == models.FiscalSigned || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(invoices) != 1 || invoices[0].Status != models.FiscalSigned {
+		t.Errorf("fiscal invoices = %+v, want one signed", invoices)
+	}
+	if n := device.submitted(); n != 1 {
+		t.Errorf("sale fiscalized %d times, want once", n)
+	}
+}
+
+// A prompt paid after its sale was voided is owed back to the customer; it
+// mustn't mark the order paid or fiscalize it
+func TestMpesaCallbackAfterVoid(t *testing.T) {
+	testDB(t)
+	useMpesa(t)
+	order, payment, body := testMpesaSale(t)
+	if err := database.DB.Model(&order).UpdateColumn("voided_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if w := postMpesaCallback("secret", body); w.Code != http.StatusOK {
+		t.Fatalf("status %d %s, want the callback accepted", w.Code, w.Body)
+	}
+
+	if err := database.DB.First(&payment, payment.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != models.SalePaymentRefundDue || payment.Reference != "NLJ7RT61SV" {
+		t.Errorf("payment = %+v, want a refund due against the M-Pesa receipt", payment)
+	}
+	if err := database.DB.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.PaymentStatus != models.OrderPendingPayment {
+		t.Errorf("order payment status = %q, want it left pending", order.PaymentStatus)
+	}
+	var invoices int
+	if err := database.DB.Model(&models.FiscalInvoice{}).Where("sale_order_id = ?", order.ID).Count(&invoices).Error; err != nil {
+		t.Fatal(err)
+	}
+	if invoices != 0 {
+		t.Errorf("%d fiscal invoices queued for a voided sale", invoices)
+	}
+}
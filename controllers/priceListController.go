@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var errPriceListNotFound = errors.New("price list not found in your business")
+
+// findPriceList returns one of the business's price lists
+func findPriceList(tx *gorm.DB, businessID, id uint) (models.PriceList, error) {
+	var list models.PriceList
+	err := tx.Where("business_id = ? AND id = ?", businessID, id).First(&list).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return list, errPriceListNotFound
+	}
+	return list, err
+}
+
+// listPrice returns what a price list charges per unit for quantity of a
+// product, and false when the list has no price for it. A variant is
+// charged its parent's price unless the list prices the variant itself.
+func listPrice(tx *gorm.DB, priceListID uint, product models.Product, unitID uint, quantity float64) (float64, bool, error) {
+	if priceListID == 0 {
+		return 0, false, nil
+	}
+	productIDs := []uint{product.ID}
+	if product.ParentID != nil {
+		productIDs = append(productIDs, *product.ParentID)
+	}
+
+	var items []models.PriceListItem
+	if err := tx.Where("price_list_id = ? AND product_id IN (?) AND unit_id = ? AND min_quantity <= ?",
+		priceListID, productIDs, unitID, roundQuantity(quantity)).
+		Order("min_quantity DESC").
+		Find(&items).Error; err != nil {
+		return 0, false, err
+	}
+
+	// The biggest quantity break reached, preferring the product's own
+	var best *models.PriceListItem
+	for i, item := range items {
+		if best == nil || (item.ProductID == product.ID && best.ProductID != product.ID) {
+			best = &items[i]
+		}
+	}
+	if best == nil {
+		return 0, false, nil
+	}
+	return best.Price, true, nil
+}
+
+type priceListInput struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// CreatePriceList - Adds a price list customers can be assigned to
+func CreatePriceList(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input priceListInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := models.PriceList{
+		BusinessID:  businessID.(uint),
+		Name:        strings.TrimSpace(input.Name),
+		Description: input.Description,
+	}
+	if err := database.DB.Create(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create price list"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, list)
+}
+
+// GetPriceLists - Lists the business's price lists
+func GetPriceLists(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var lists []models.PriceList
+	if err := database.DB.Where("business_id = ?", businessID).Order("name").Find(&lists).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price lists"})
+		return
+	}
+	c.JSON(http.StatusOK, lists)
+}
+
+// GetPriceList - Returns a price list with its prices
+func GetPriceList(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var list models.PriceList
+	if err := database.DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("product_id, unit_id, min_quantity")
+	}).Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&list).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// UpdatePriceList - Renames a price list
+func UpdatePriceList(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var list models.PriceList
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&list).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+		return
+	}
+
+	var input priceListInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Model(&list).Updates(map[string]interface{}{
+		"name":        strings.TrimSpace(input.Name),
+		"description": input.Description,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update price list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// DeletePriceList - Deletes a price list. Its customers go back to normal
+// prices; sales made from it still record it.
+func DeletePriceList(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var list models.PriceList
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&list).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Model(&models.Customer{}).Where("business_id = ? AND price_list_id = ?", businessID, list.ID).
+		UpdateColumn("price_list_id", 0).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price list"})
+		return
+	}
+	if err := tx.Where("price_list_id = ?", list.ID).Delete(&models.PriceListItem{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price list"})
+		return
+	}
+	if err := tx.Delete(&list).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price list"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price list deleted successfully"})
+}
+
+// SetPriceListItem - Sets a product's price on a list, for a unit and from
+// a quantity up
+func SetPriceListItem(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var list models.PriceList
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&list).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+		return
+	}
+
+	var input struct {
+		ProductID   uint    `json:"product_id" binding:"required"`
+		UnitID      uint    `json:"unit_id"`                      // Optional; defaults to the product's base unit
+		MinQuantity float64 `json:"min_quantity" binding:"gte=0"` // Optional; the price applies from any quantity
+		Price       float64 `json:"price" binding:"required,gt=0"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, input.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if _, err := resolveUnit(database.DB, product, input.UnitID, true); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Setting the same break again replaces its price
+	minQuantity, price := roundQuantity(input.MinQuantity), roundMoney(input.Price)
+	var item models.PriceListItem
+	err := database.DB.Where("price_list_id = ? AND product_id = ? AND unit_id = ? AND min_quantity = ?",
+		list.ID, product.ID, input.UnitID, minQuantity).First(&item).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		item = models.PriceListItem{
+			PriceListID: list.ID,
+			ProductID:   product.ID,
+			UnitID:      input.UnitID,
+			MinQuantity: minQuantity,
+			Price:       price,
+		}
+		err = database.DB.Create(&item).Error
+	case err == nil:
+		err = database.DB.Model(&item).Update("price", price).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// DeletePriceListItem - Takes a price off a list
+func DeletePriceListItem(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var list models.PriceList
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&list).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+		return
+	}
+
+	result := database.DB.Where("price_list_id = ? AND id = ?", list.ID, c.Param("itemId")).Delete(&models.PriceListItem{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price deleted successfully"})
+}
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+type promotionInput struct {
+	Name        string     `json:"name" binding:"required"`
+	Type        string     `json:"type" binding:"required,oneof=buy_x_get_y category happy_hour"`
+	ProductID   uint       `json:"product_id"`
+	CategoryID  uint       `json:"category_id"`
+	BuyQuantity float64    `json:"buy_quantity" binding:"gte=0"`
+	GetQuantity float64    `json:"get_quantity" binding:"gte=0"`
+	Percent     float64    `json:"percent" binding:"gte=0,lte=100"`
+	DailyFrom   string     `json:"daily_from"` // "HH:MM"
+	DailyTo     string     `json:"daily_to"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	Active      *bool      `json:"active"` // Defaults to true
+}
+
+// check returns why a promotion can't be saved, if it can't
+func (in promotionInput) check(businessID uint) error {
+	switch in.Type {
+	case models.PromotionBuyXGetY:
+		if in.ProductID == 0 || in.BuyQuantity <= 0 || in.GetQuantity <= 0 {
+			return errors.New("buy X get Y needs a product, buy_quantity and get_quantity")
+		}
+	case models.PromotionCategory:
+		if in.CategoryID == 0 || in.Percent <= 0 {
+			return errors.New("category promotions need a category and percent")
+		}
+	case models.PromotionHappyHour:
+		if in.Percent <= 0 {
+			return errors.New("happy hour needs a percent")
+		}
+		from, err := time.Parse("15:04", in.DailyFrom)
+		if err != nil {
+			return errors.New("daily_from must be HH:MM")
+		}
+		to, err := time.Parse("15:04", in.DailyTo)
+		if err != nil {
+			return errors.New("daily_to must be HH:MM")
+		}
+		if from.Equal(to) {
+			return errors.New("happy hour must end at a different time than it starts")
+		}
+	}
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+
+	if in.ProductID != 0 {
+		var count int
+		database.DB.Model(&models.Product{}).Where("id = ? AND business_id = ?", in.ProductID, businessID).Count(&count)
+		if count == 0 {
+			return errProductNotFound
+		}
+	}
+	if in.CategoryID != 0 {
+		var count int
+		database.DB.Model(&models.Category{}).Where("id = ? AND business_id = ?", in.CategoryID, businessID).Count(&count)
+		if count == 0 {
+			return errors.New("category not found in your business")
+		}
+	}
+	return nil
+}
+
+// fields returns the promotion's columns as set by the input. Times of day
+// are stored as HH:MM so they compare as strings.
+func (in promotionInput) fields() map[string]interface{} {
+	active := true
+	if in.Active != nil {
+		active = *in.Active
+	}
+	var dailyFrom, dailyTo string
+	if in.Type == models.PromotionHappyHour {
+		from, _ := time.Parse("15:04", in.DailyFrom)
+		to, _ := time.Parse("15:04", in.DailyTo)
+		dailyFrom, dailyTo = from.Format("15:04"), to.Format("15:04")
+	}
+	return map[string]interface{}{
+		"name":         strings.TrimSpace(in.Name),
+		"type":         in.Type,
+		"product_id":   in.ProductID,
+		"category_id":  in.CategoryID,
+		"buy_quantity": in.BuyQuantity,
+		"get_quantity": in.GetQuantity,
+		"percent":      in.Percent,
+		"daily_from":   dailyFrom,
+		"daily_to":     dailyTo,
+		"starts_at":    in.StartsAt,
+		"ends_at":      in.EndsAt,
+		"active":       active,
+	}
+}
+
+// CreatePromotion - Adds a promotion the till applies automatically while
+// it runs
+func CreatePromotion(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input promotionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := input.check(businessID.(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promotion := models.Promotion{BusinessID: businessID.(uint), Name: input.Name, Type: input.Type}
+	tx := database.DB.Begin()
+	if err := tx.Create(&promotion).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+	// Set every field after creating so a false Active isn't lost to its default
+	if err := tx.Model(&promotion).Updates(input.fields()).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, promotion)
+}
+
+// GetPromotions - Lists the business's promotions; ?active=true lists only
+// those running now
+func GetPromotions(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var promotions []models.Promotion
+	var err error
+	if c.Query("active") == "true" {
+		promotions, err = activePromotions(database.DB, businessID.(uint), time.Now())
+	} else {
+		err = database.DB.Where("business_id = ?", businessID).Order("id DESC").Find(&promotions).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+	c.JSON(http.StatusOK, promotions)
+}
+
+// UpdatePromotion - Changes a promotion. Sales already made keep the
+// discount they were given.
+func UpdatePromotion(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var promotion models.Promotion
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&promotion).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	var input promotionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := input.check(businessID.(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Model(&promotion).Updates(input.fields()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, promotion)
+}
+
+// DeletePromotion - Deletes a promotion. Reports still name it against
+// the sales it discounted.
+func DeletePromotion(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var promotion models.Promotion
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&promotion).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	if err := database.DB.Delete(&promotion).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete promotion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion deleted successfully"})
+}
+
+// GetDiscountLimits - Lists the largest discount each role may give by
+// hand, as a percentage of the price
+func GetDiscountLimits(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	limits := make([]models.DiscountLimit, 0, len(defaultDiscountLimits))
+	for _, role := range []string{models.RoleAdmin, models.RoleManager, models.RoleEmployee} {
+		max, err := discountLimit(database.DB, businessID.(uint), role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch discount limits"})
+			return
+		}
+		limits = append(limits, models.DiscountLimit{BusinessID: businessID.(uint), Role: role, MaxPercent: max})
+	}
+	c.JSON(http.StatusOK, limits)
+}
+
+// SetDiscountLimit - Sets the largest discount a role may give by hand
+func SetDiscountLimit(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Role       string  `json:"role" binding:"required,oneof=admin manager employee"`
+		MaxPercent float64 `json:"max_percent" binding:"gte=0,lte=100"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var limit models.DiscountLimit
+	err := database.DB.Where("business_id = ? AND role = ?", businessID, input.Role).First(&limit).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		limit = models.DiscountLimit{BusinessID: businessID.(uint), Role: input.Role, MaxPercent: input.MaxPercent}
+		err = database.DB.Create(&limit).Error
+	case err == nil:
+		err = database.DB.Model(&limit).Update("max_percent", input.MaxPercent).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set discount limit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, limit)
+}
@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// CreatePurchaseOrder - Drafts a purchase order for a supplier
+func CreatePurchaseOrder(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input struct {
+		SupplierID uint       `json:"supplier_id" binding:"required"`
+		LocationID uint       `json:"location_id"` // Delivery location, defaults to the main store
+		Note       string     `json:"note"`
+		ExpectedAt *time.Time `json:"expected_at"`
+		Lines      []struct {
+			ProductID uint    `json:"product_id" binding:"required"`
+			UnitID    uint    `json:"unit_id"` // Purchase unit, e.g. a crate; defaults to the base unit
+			Quantity  float64 `json:"quantity" binding:"required,gt=0"`
+			UnitCost  float64 `json:"unit_cost" binding:"min=0"` // Per purchase unit
+		} `json:"lines" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var supplier models.Supplier
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, input.SupplierID).First(&supplier).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	location, err := resolveLocation(tx, businessID.(uint), input.LocationID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	order := models.PurchaseOrder{
+		BusinessID: businessID.(uint),
+		SupplierID: supplier.ID,
+		LocationID: location.ID,
+		Status:     models.PurchaseOrderDraft,
+		Note:       input.Note,
+		ExpectedAt: input.ExpectedAt,
+		CreatedBy:  c.GetUint("user_id"),
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase order"})
+		return
+	}
+
+	order.Number = fmt.Sprintf("PO-%05d", order.ID)
+	if err := tx.Model(&order).Update("number", order.Number).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to number purchase order"})
+		return
+	}
+
+	for _, in := range input.Lines {
+		var product models.Product
+		if err := tx.Where("business_id = ? AND id = ?", businessID, in.ProductID).First(&product).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Product %d not found in your business", in.ProductID)})
+			return
+		}
+		// Stock is held by the variants, never by their parent
+		if product.HasVariants {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Choose a variant of %s to order", product.Name)})
+			return
+		}
+
+		unit, err := resolveUnit(tx, product, in.UnitID, false)
+		if err == nil {
+			_, err = toBaseQuantity(product, unit, in.Quantity)
+		}
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", product.Name, err)})
+			return
+		}
+
+		line := models.PurchaseOrderLine{
+			PurchaseOrderID: order.ID,
+			ProductID:       product.ID,
+			UnitID:          in.UnitID,
+			UnitName:        unit.Name,
+			Factor:          unit.Factor,
+			QuantityOrdered: in.Quantity,
+			UnitCost:        in.UnitCost,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add purchase order line"})
+			return
+		}
+		line.Product = product
+		order.Lines = append(order.Lines, line)
+	}
+
+	tx.Commit()
+
+	order.Supplier = supplier
+	c.JSON(http.StatusCreated, order)
+}
+
+func GetPurchaseOrders(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := database.DB.Preload("Supplier").Where("business_id = ?", businessID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var orders []models.PurchaseOrder
+	if err := query.Order("created_at DESC").Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+func GetPurchaseOrder(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var order models.PurchaseOrder
+	if err := database.DB.Preload("Supplier").Preload("Lines").Preload("Lines.Product").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// lockPurchaseOrder loads a purchase order FOR UPDATE, writing the error
+// response itself when it can't.
+func lockPurchaseOrder(c *gin.Context, tx *gorm.DB) (*models.PurchaseOrder, bool) {
+	var order models.PurchaseOrder
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", c.GetUint("business_id"), c.Param("id")).
+		First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return nil, false
+	}
+	return &order, true
+}
+
+// SendPurchaseOrder - Marks a draft purchase order as sent to the supplier
+func SendPurchaseOrder(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	order, ok := lockPurchaseOrder(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if order.Status != models.PurchaseOrderDraft {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft purchase orders can be sent"})
+		return
+	}
+
+	now := time.Now()
+	order.Status = models.PurchaseOrderSent
+	order.SentAt = &now
+	if err := tx.Save(order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, order)
+}
+
+// CancelPurchaseOrder - Cancels a purchase order that has not been received yet
+func CancelPurchaseOrder(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	order, ok := lockPurchaseOrder(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if order.Status != models.PurchaseOrderDraft && order.Status != models.PurchaseOrderSent {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Purchase order is already " + order.Status})
+		return
+	}
+
+	order.Status = models.PurchaseOrderCancelled
+	if err := tx.Save(order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, order)
+}
+
+// ReceivePurchaseOrder - Books delivered goods into stock against their PO lines
+func ReceivePurchaseOrder(c *gin.Context) {
+	var input struct {
+		Lines []struct {
+			LineID    uint       `json:"line_id" binding:"required"`
+			Quantity  float64    `json:"quantity" binding:"required,gt=0"` // In the line's purchase unit
+			LotNumber string     `json:"lot_number"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		} `json:"lines" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	order, ok := lockPurchaseOrder(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if order.Status != models.PurchaseOrderSent && order.Status != models.PurchaseOrderPartiallyReceived {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Purchase order is " + order.Status + " and cannot be received"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	for _, in := range input.Lines {
+		var line models.PurchaseOrderLine
+		if err := tx.Preload("Product").Where("purchase_order_id = ? AND id = ?", order.ID, in.LineID).First(&line).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Line %d is not on this purchase order", in.LineID)})
+			return
+		}
+		if line.QuantityReceived+in.Quantity > line.QuantityOrdered {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Line %d would receive more than was ordered", in.LineID)})
+			return
+		}
+
+		// Stock is kept in base units; lines ordered before units existed have no factor
+		factor := line.Factor
+		if factor == 0 {
+			factor = 1
+		}
+		quantity, err := toBaseQuantity(line.Product, models.ProductUnit{
+			Name:         line.UnitName,
+			Factor:       factor,
+			AllowDecimal: true, // Part-deliveries of a pack are fine if the base unit allows it
+		}, in.Quantity)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Line %d: %v", in.LineID, err)})
+			return
+		}
+
+		stock := models.Stock{
+			BusinessID:      order.BusinessID,
+			ProductID:       line.ProductID,
+			SupplierID:      order.SupplierID,
+			PurchaseOrderID: order.ID,
+			LocationID:      order.LocationID,
+			Quantity:        quantity,
+			UnitCost:        line.UnitCost / factor,
+			LotNumber:       in.LotNumber,
+			ExpiresAt:       in.ExpiresAt,
+			AddedAt:         time.Now(),
+		}
+		if err := receiveStock(tx, &stock, &models.StockMovement{
+			BusinessID: order.BusinessID,
+			ProductID:  line.ProductID,
+			Reason:     order.Number,
+			UserID:     userID,
+		}); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+			return
+		}
+
+		if err := tx.Model(&line).Update("quantity_received", line.QuantityReceived+in.Quantity).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order line"})
+			return
+		}
+	}
+
+	var outstanding int64
+	if err := tx.Model(&models.PurchaseOrderLine{}).
+		Where("purchase_order_id = ? AND quantity_received < quantity_ordered", order.ID).
+		Count(&outstanding).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check outstanding lines"})
+		return
+	}
+
+	order.Status = models.PurchaseOrderReceived
+	if outstanding > 0 {
+		order.Status = models.PurchaseOrderPartiallyReceived
+	}
+	if err := tx.Model(order).Update("status", order.Status).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Goods received",
+		"status":  order.Status,
+	})
+}
@@ -0,0 +1,489 @@
+package controllers
+
+import "errors"
+
+var errQRTooLong = errors.New("text is too long for a QR code")
+
+// qrLevel is an error correction level. L, M, Q and H can restore about
+// 7, 15, 25 and 30% of a damaged symbol, at the cost of a bigger one.
+type qrLevel int
+
+const (
+	qrLevelL qrLevel = iota
+	qrLevelM
+	qrLevelQ
+	qrLevelH
+)
+
+// qrLevelBits is how each level is written in the format information
+var qrLevelBits = [...]int{qrLevelL: 1, qrLevelM: 0, qrLevelQ: 3, qrLevelH: 2}
+
+// qrLayout is how one QR code version splits its codewords into blocks at
+// one error correction level
+type qrLayout struct {
+	ecPerBlock int
+	blocks     []int // Data codewords in each block, shortest first
+}
+
+// qrLayouts covers versions 1 to 10, enough for about 200 bytes at level
+// M; index 0 is version 1, and each entry is indexed by level
+var qrLayouts = [][4]qrLayout{
+	{{7, []int{19}}, {10, []int{16}}, {13, []int{13}}, {17, []int{9}}},
+	{{10, []int{34}}, {16, []int{28}}, {22, []int{22}}, {28, []int{16}}},
+	{{15, []int{55}}, {26, []int{44}}, {18, []int{17, 17}}, {22, []int{13, 13}}},
+	{{20, []int{80}}, {18, []int{32, 32}}, {26, []int{24, 24}}, {16, []int{9, 9, 9, 9}}},
+	{{26, []int{108}}, {24, []int{43, 43}}, {18, []int{15, 15, 16, 16}}, {22, []int{11, 11, 12, 12}}},
+	{{18, []int{68, 68}}, {16, []int{27, 27, 27, 27}}, {24, []int{19, 19, 19, 19}}, {28, []int{15, 15, 15, 15}}},
+	{{20, []int{78, 78}}, {18, []int{31, 31, 31, 31}}, {18, []int{14, 14, 15, 15, 15, 15}}, {26, []int{13, 13, 13, 13, 14}}},
+	{{24, []int{97, 97}}, {22, []int{38, 38, 39, 39}}, {22, []int{18, 18, 18, 18, 19, 19}}, {26, []int{14, 14, 14, 14, 15, 15}}},
+	{{30, []int{116, 116}}, {22, []int{36, 36, 36, 37, 37}}, {20, []int{16, 16, 16, 16, 17, 17, 17, 17}}, {24, []int{12, 12, 12, 12, 13, 13, 13, 13}}},
+	{{18, []int{68, 68, 69, 69}}, {26, []int{43, 43, 43, 43, 44}}, {24, []int{19, 19, 19, 19, 19, 19, 20, 20}}, {28, []int{15, 15, 15, 15, 15, 15, 16, 16}}},
+}
+
+// qrAlignment is where each version's alignment patterns are centred along
+// each axis; index 0 is version 1
+var qrAlignment = [][]int{
+	nil,
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+// qrSymbol is a QR code being built: its dark modules, and which modules
+// belong to the fixed patterns rather than the data
+type qrSymbol struct {
+	size     int
+	level    qrLevel
+	dark     [][]bool
+	function [][]bool
+}
+
+func (q *qrSymbol) set(row, col int, dark bool) {
+	q.dark[row][col] = dark
+	q.function[row][col] = true
+}
+
+// encodeQR returns the modules of a QR code holding text in byte mode at
+// the given error correction level, indexed [row][column] with true for
+// dark. The smallest version that fits is used.
+func encodeQR(text string, level qrLevel) ([][]bool, error) {
+	data := []byte(text)
+
+	version := 0
+	for i, layouts := range qrLayouts {
+		layout := layouts[level]
+		capacity := 0
+		for _, n := range layout.blocks {
+			capacity += n
+		}
+		countBits := 8
+		if i+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= capacity*8 {
+			version = i + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+	layout := qrLayouts[version-1][level]
+
+	codewords := qrCodewords(data, version, layout)
+
+	q := &qrSymbol{size: 17 + 4*version, level: level}
+	q.dark = make([][]bool, q.size)
+	q.function = make([][]bool, q.size)
+	for i := range q.dark {
+		q.dark[i] = make([]bool, q.size)
+		q.function[i] = make([]bool, q.size)
+	}
+	q.drawPatterns(version)
+	q.drawCodewords(codewords)
+
+	// Keep whichever mask leaves the fewest patterns that confuse scanners
+	best, bestPenalty := -1, 0
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if penalty := q.penalty(); best < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask) // Masking twice undoes it
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q.dark, nil
+}
+
+// qrCodewords encodes data as a byte mode segment padded to the version's
+// capacity, then adds error correction and interleaves the blocks
+func qrCodewords(data []byte, version int, layout qrLayout) []byte {
+	capacity := 0
+	for _, n := range layout.blocks {
+		capacity += n
+	}
+
+	var bits []bool
+	put := func(value, length int) {
+		for i := length - 1; i >= 0; i-- {
+			bits = append(bits, value>>i&1 == 1)
+		}
+	}
+	put(0x4, 4) // Byte mode
+	if version >= 10 {
+		put(len(data), 16)
+	} else {
+		put(len(data), 8)
+	}
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	terminator := capacity*8 - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	put(0, terminator)
+	put(0, (8-len(bits)%8)%8)
+
+	stream := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		stream = append(stream, b)
+	}
+	for pad := byte(0xEC); len(stream) < capacity; pad ^= 0xEC ^ 0x11 {
+		stream = append(stream, pad)
+	}
+
+	divisor := rsDivisor(layout.ecPerBlock)
+	dataBlocks := make([][]byte, len(layout.blocks))
+	ecBlocks := make([][]byte, len(layout.blocks))
+	for i, n := range layout.blocks {
+		dataBlocks[i], stream = stream[:n], stream[n:]
+		ecBlocks[i] = rsRemainder(dataBlocks[i], divisor)
+	}
+
+	longest := layout.blocks[len(layout.blocks)-1]
+	var codewords []byte
+	for i := 0; i < longest; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				codewords = append(codewords, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			codewords = append(codewords, block[i])
+		}
+	}
+	return codewords
+}
+
+// gfMultiply multiplies in GF(256) with the QR code polynomial 0x11D
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given
+// degree, highest power first with its leading 1 left out
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords for data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// drawPatterns draws the finder, timing and alignment patterns and the
+// version information, and reserves the format areas
+func (q *qrSymbol) drawPatterns(version int) {
+	for _, corner := range [][2]int{{0, 0}, {0, q.size - 7}, {q.size - 7, 0}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				row, col := corner[0]+dy, corner[1]+dx
+				if row < 0 || row >= q.size || col < 0 || col >= q.size {
+					continue
+				}
+				ring := dy == 0 || dy == 6 || dx == 0 || dx == 6
+				centre := dy >= 2 && dy <= 4 && dx >= 2 && dx <= 4
+				inside := dy >= 0 && dy <= 6 && dx >= 0 && dx <= 6
+				q.set(row, col, inside && (ring || centre))
+			}
+		}
+	}
+
+	for i := 8; i < q.size-8; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	align := qrAlignment[version-1]
+	last := len(align) - 1
+	for i, row := range align {
+		for j, col := range align {
+			// Alignment patterns never overlap the finders
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(row+dy, col+dx, max(abs(dy), abs(dx)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas; drawFormat fills them in
+	q.drawFormat(0)
+
+	if version >= 7 {
+		bits := qrVersionBits(version)
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(b, a, dark)
+			q.set(a, b, dark)
+		}
+	}
+}
+
+// qrVersionBits is the 18 bit version information: the version and its
+// BCH error correction
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+// qrFormatBits is the 15 bit format information for a level and mask: the
+// two with their BCH error correction, masked so it is never all light
+func qrFormatBits(level qrLevel, mask int) int {
+	data := qrLevelBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat writes both copies of the format information for the
+// symbol's level and the given mask
+func (q *qrSymbol) drawFormat(mask int) {
+	bits := qrFormatBits(q.level, mask)
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(i, 8, bit(i))
+	}
+	q.set(7, 8, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(8, q.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(q.size-15+i, 8, bit(i))
+	}
+	q.set(q.size-8, 8, true) // Always dark
+}
+
+// drawCodewords places the codewords in the zigzag order QR readers scan,
+// two columns at a time from the bottom right
+func (q *qrSymbol) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if q.function[row][col] {
+					continue
+				}
+				// Modules past the last codeword are remainder bits, left light
+				if i < len(codewords)*8 {
+					q.dark[row][col] = codewords[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules the mask pattern selects
+func (q *qrSymbol) applyMask(mask int) {
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.function[row][col] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (row+col)%2 == 0
+			case 1:
+				invert = row%2 == 0
+			case 2:
+				invert = col%3 == 0
+			case 3:
+				invert = (row+col)%3 == 0
+			case 4:
+				invert = (col/3+row/2)%2 == 0
+			case 5:
+				invert = row*col%2+row*col%3 == 0
+			case 6:
+				invert = (row*col%2+row*col%3)%2 == 0
+			case 7:
+				invert = ((row+col)%2+row*col%3)%2 == 0
+			}
+			if invert {
+				q.dark[row][col] = !q.dark[row][col]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan, by the four rules of the
+// QR code standard
+func (q *qrSymbol) penalty() int {
+	at := func(row, col int, transpose bool) bool {
+		if transpose {
+			return q.dark[col][row]
+		}
+		return q.dark[row][col]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	result := 0
+	for _, transpose := range []bool{false, true} {
+		for row := 0; row < q.size; row++ {
+			// Runs of five or more modules of one colour
+			run := 1
+			for col := 1; col < q.size; col++ {
+				if at(row, col, transpose) == at(row, col-1, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				result += run - 2
+			}
+
+			// Patterns that look like a finder, with four light modules on
+			// either side
+			for col := 0; col+len(finderLike) <= q.size; col++ {
+				matches := true
+				for k, dark := range finderLike {
+					if at(row, col+k, transpose) != dark {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					continue
+				}
+				lightBefore, lightAfter := col >= 4, col+11 <= q.size
+				for k := 1; k <= 4; k++ {
+					if lightBefore && at(row, col-k, transpose) {
+						lightBefore = false
+					}
+					if lightAfter && at(row, col+6+k, transpose) {
+						lightAfter = false
+					}
+				}
+				if lightBefore {
+					result += 40
+				}
+				if lightAfter {
+					result += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of one colour
+	for row := 0; row < q.size-1; row++ {
+		for col := 0; col < q.size-1; col++ {
+			c := q.dark[row][col]
+			if c == q.dark[row][col+1] && c == q.dark[row+1][col] && c == q.dark[row+1][col+1] {
+				result += 3
+			}
+		}
+	}
+
+	// Balance of dark and light, in steps of 5% away from half
+	dark := 0
+	for _, row := range q.dark {
+		for _, d := range row {
+			if d {
+				dark++
+			}
+		}
+	}
+	total := q.size * q.size
+	result += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+	return result
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Error correction codewords worked through in ISO/IEC 18004 Annex I
+// ("01234567" at 1-M) and in Thonky's QR code tutorial ("HELLO WORLD" at
+// 1-M and 1-Q)
+func TestRSRemainderVectors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data, ec []byte
+	}{
+		{
+			"01234567 1-M",
+			[]byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11},
+			[]byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55},
+		},
+		{
+			"HELLO WORLD 1-M",
+			[]byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17},
+			[]byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23},
+		},
+		{
+			"HELLO WORLD 1-Q",
+			[]byte{0x20, 0x5B, 0x0B, 0x78, 0xD1, 0x72, 0xDC, 0x4D, 0x43, 0x40, 0xEC, 0x11, 0xEC},
+			[]byte{0xA8, 0x48, 0x16, 0x52, 0xD9, 0x36, 0x9C, 0x00, 0x2E, 0x0F, 0xB4, 0x7A, 0x10},
+		},
+	} {
+		if got := rsRemainder(tc.data, rsDivisor(len(tc.ec))); !bytes.Equal(got, tc.ec) {
+			t.Errorf("%s: error correction = % X, want % X", tc.name, got, tc.ec)
+		}
+	}
+}
+
+// The format information strings tabulated in ISO/IEC 18004 Annex C
+func TestQRFormatBits(t *testing.T) {
+	published := map[qrLevel][8]string{
+		qrLevelL: {"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+			"110011000101111", "110001100011000", "110110001000001", "110100101110110"},
+		qrLevelM: {"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+			"100010111111001", "100000011001110", "100111110010111", "100101010100000"},
+		qrLevelQ: {"011010101011111", "011000001101000", "011111100110001", "011101000000110",
+			"010010010110100", "010000110000011", "010111011011010", "010101111101101"},
+		qrLevelH: {"001011010001001", "001001110111110", "001110011100111", "001100111010000",
+			"000011101100010", "000001001010101", "000110100001100", "000100000111011"},
+	}
+	for level, masks := range published {
+		for mask, want := range masks {
+			if got := bitString(qrFormatBits(level, mask), 15); got != want {
+				t.Errorf("level %d mask %d: format = %s, want %s", level, mask, got, want)
+			}
+		}
+	}
+}
+
+// The version information strings tabulated in ISO/IEC 18004 Annex D
+func TestQRVersionBits(t *testing.T) {
+	for version, want := range map[int]string{
+		7:  "000111110010010100",
+		8:  "001000010110111100",
+		9:  "001001101010011001",
+		10: "001010010011010011",
+	} {
+		if got := bitString(qrVersionBits(version), 18); got != want {
+			t.Errorf("version %d: version information = %s, want %s", version, got, want)
+		}
+	}
+}
+
+// qrByteCapacity is the published byte mode capacity of versions 1 to 10,
+// by level
+var qrByteCapacity = [][4]int{
+	{17, 14, 11, 7},
+	{32, 26, 20, 14},
+	{53, 42, 32, 24},
+	{78, 62, 46, 34},
+	{106, 84, 60, 44},
+	{134, 106, 74, 58},
+	{154, 122, 86, 64},
+	{192, 152, 108, 84},
+	{230, 180, 130, 98},
+	{271, 213, 151, 119},
+}
+
+// Text that exactly fills a version is encoded at that version and reads
+// back intact; one byte more moves up a version
+func TestEncodeQRCapacity(t *testing.T) {
+	for i, capacities := range qrByteCapacity {
+		version := i + 1
+		for level, capacity := range capacities {
+			name := fmt.Sprintf("%d-%c", version, "LMQH"[level])
+			text := qrTestText(capacity)
+			modules, err := encodeQR(text, qrLevel(level))
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if got := (len(modules) - 17) / 4; got != version {
+				t.Errorf("%s: %d bytes went in version %d", name, capacity, got)
+				continue
+			}
+			if got := readQR(t, modules, qrLevel(level)); got != text {
+				t.Errorf("%s: read back %q, want %q", name, got, text)
+			}
+
+			modules, err = encodeQR(text+"x", qrLevel(level))
+			switch {
+			case version == len(qrByteCapacity):
+				if !errors.Is(err, errQRTooLong) {
+					t.Errorf("%s: one byte over gave error %v, want errQRTooLong", name, err)
+				}
+			case err != nil:
+				t.Errorf("%s: one byte over: %v", name, err)
+			case (len(modules)-17)/4 != version+1:
+				t.Errorf("%s: one byte over went in version %d", name, (len(modules)-17)/4)
+			}
+		}
+	}
+}
+
+// qrTestText is n bytes of something like an eTIMS receipt link
+func qrTestText(n int) string {
+	return strings.Repeat("https://etims.kra.go.ke/receipt?Data=P051234567A00ZWH33TQE6HQ5BIVKC5LOPW2Z5Y", 4)[:n]
+}
+
+// bitString writes the low n bits of v most significant first
+func bitString(v, n int) string {
+	var b strings.Builder
+	for i := n - 1; i >= 0; i-- {
+		b.WriteByte('0' + byte(v>>i&1))
+	}
+	return b.String()
+}
+
+// readQR decodes a byte mode symbol, failing the test unless both copies of
+// its format information are valid for level, its version information
+// matches its size and every block's error correction checks out
+func readQR(t *testing.T, modules [][]bool, level qrLevel) string {
+	t.Helper()
+	size := len(modules)
+	version := (size - 17) / 4
+	bit := func(row, col int) int {
+		if modules[row][col] {
+			return 1
+		}
+		return 0
+	}
+
+	// Format information beside the top left finder, and split between
+	// the other two
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(i, 8) << i
+	}
+	first |= bit(7, 8)<<6 | bit(8, 8)<<7 | bit(8, 7)<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(8, 14-i) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(8, size-1-i) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(size-15+i, 8) << i
+	}
+	mask := (first ^ 0x5412) >> 10 & 7
+	if first != second || first != qrFormatBits(level, mask) {
+		t.Fatalf("format information %s and %s isn't valid for level %d", bitString(first, 15), bitString(second, 15), level)
+	}
+
+	if version >= 7 {
+		var above, left int
+		for i := 0; i < 18; i++ {
+			above |= bit(i/3, size-11+i%3) << i
+			left |= bit(size-11+i%3, i/3) << i
+		}
+		if above != qrVersionBits(version) || left != above {
+			t.Fatalf("version information %s and %s, want %s", bitString(above, 18), bitString(left, 18), bitString(qrVersionBits(version), 18))
+		}
+	}
+
+	// Rebuild the fixed patterns to find the data modules, then unmask
+	q := &qrSymbol{size: size, level: level}
+	q.dark = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := range q.dark {
+		q.dark[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	q.drawPatterns(version)
+	for row := range modules {
+		for col := range modules[row] {
+			if q.function[row][col] && row != 8 && col != 8 && q.dark[row][col] != modules[row][col] {
+				t.Fatalf("function module (%d, %d) is wrong", row, col)
+			}
+			q.dark[row][col] = modules[row][col]
+		}
+	}
+	q.applyMask(mask)
+
+	layout := qrLayouts[version-1][level]
+	total := 0
+	for _, n := range layout.blocks {
+		total += n + layout.ecPerBlock
+	}
+	codewords := make([]byte, 0, total)
+	var current byte
+	read := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < size; vert++ {
+			row := vert
+			if upward {
+				row = size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if q.function[row][col] || len(codewords) == total {
+					continue
+				}
+				current <<= 1
+				if q.dark[row][col] {
+					current |= 1
+				}
+				if read++; read%8 == 0 {
+					codewords = append(codewords, current)
+					current = 0
+				}
+			}
+		}
+	}
+	if len(codewords) != total {
+		t.Fatalf("read %d codewords, want %d", len(codewords), total)
+	}
+
+	// Undo the interleaving and check each block
+	blocks := make([][]byte, len(layout.blocks))
+	longest := layout.blocks[len(layout.blocks)-1]
+	next := 0
+	for i := 0; i < longest; i++ {
+		for b, n := range layout.blocks {
+			if i < n {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	divisor := rsDivisor(layout.ecPerBlock)
+	var stream []byte
+	for b, block := range blocks {
+		ec := make([]byte, layout.ecPerBlock)
+		for i := range ec {
+			ec[i] = codewords[next+i*len(blocks)+b]
+		}
+		if want := rsRemainder(block, divisor); !bytes.Equal(ec, want) {
+			t.Fatalf("block %d error correction = % X, want % X", b, ec, want)
+		}
+		stream = append(stream, block...)
+	}
+
+	// A byte mode segment: mode, count, then the bytes
+	pos := 0
+	take := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(stream[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	if mode := take(4); mode != 0x4 {
+		t.Fatalf("mode %04b, want byte mode", mode)
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	text := make([]byte, take(countBits))
+	for i := range text {
+		text[i] = byte(take(8))
+	}
+	return string(text)
+}
@@ -0,0 +1,476 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// receiptLine is one item as printed on a receipt
+type receiptLine struct {
+	Name      string
+	Quantity  float64
+	Unit      string
+	UnitPrice float64
+	Total     float64
+}
+
+type receiptTax struct {
+	Label  string
+	Amount float64
+}
+
+type receiptTender struct {
+	Label     string
+	Amount    float64
+	Reference string
+}
+
+// receipt holds everything printed on a sale receipt, whatever the format
+type receipt struct {
+	BusinessName  string
+	LocationName  string
+	Cashier       string
+	Customer      string
+	Number        string
+	SoldAt        time.Time
+	Lines         []receiptLine
+	Subtotal      float64
+	Discount      float64 // Promotions and discounts given, taken off Subtotal
+	Taxes         []receiptTax
+	Total         float64
+	PaymentMethod string
+	Tenders       []receiptTender
+	Change        float64
+	ControlNumber string // Tax authority's number for the sale, once fiscalized
+	QRCode        string // Text of the fiscal QR code
+}
+
+var paymentMethodLabels = map[string]string{
+	models.PaymentCash:   "Cash",
+	models.PaymentMpesa:  "M-Pesa",
+	models.PaymentCard:   "Card",
+	models.PaymentCredit: "On account",
+}
+
+// loadReceipt gathers a sale order and the names printed alongside it
+func loadReceipt(businessID uint, orderID string) (receipt, error) {
+	var order models.SaleOrder
+	if err := database.DB.Preload("Items").Preload("Items.Product").Preload("Customer").Preload("Payments").
+		Where("business_id = ? AND id = ?", businessID, orderID).
+		First(&order).Error; err != nil {
+		return receipt{}, err
+	}
+
+	var business models.Business
+	if err := database.DB.Select("id, business_name").First(&business, businessID).Error; err != nil {
+		return receipt{}, err
+	}
+
+	// The cashier or location may since have been removed; print what's left
+	var cashier models.User
+	database.DB.Unscoped().Select("id, first_name, last_name").First(&cashier, order.UserID)
+	var location models.Location
+	database.DB.Unscoped().Select("id, name").First(&location, order.LocationID)
+
+	r := receipt{
+		BusinessName:  business.BusinessName,
+		LocationName:  location.Name,
+		Cashier:       strings.TrimSpace(cashier.FirstName + " " + cashier.LastName),
+		Number:        receiptNumber(order.ID),
+		SoldAt:        order.SoldAt,
+		Total:         order.Total,
+		PaymentMethod: paymentMethodLabels[order.PaymentMethod],
+	}
+	if r.PaymentMethod == "" {
+		r.PaymentMethod = order.PaymentMethod
+	}
+	if order.Customer != nil {
+		r.Customer = order.Customer.Name
+	}
+	for _, payment := range order.Payments {
+		label := paymentMethodLabels[payment.Method]
+		if label == "" {
+			label = payment.Method
+		}
+		if payment.Status != models.SalePaymentCompleted {
+			label += " (" + payment.Status + ")"
+		}
+		r.Tenders = append(r.Tenders, receiptTender{Label: label, Amount: payment.Amount, Reference: payment.Reference})
+		r.Change += payment.Change
+	}
+	// Tax is printed per class; a class may since have been removed
+	var classIDs []uint
+	for _, item := range order.Items {
+		if item.TaxClassID != 0 {
+			classIDs = append(classIDs, item.TaxClassID)
+		}
+	}
+	var classes []models.TaxClass
+	if len(classIDs) > 0 {
+		database.DB.Unscoped().Where("id IN (?)", classIDs).Find(&classes)
+	}
+	classNames := make(map[uint]string)
+	for _, class := range classes {
+		classNames[class.ID] = class.Name
+	}
+
+	taxIndex := make(map[string]int)
+	for _, item := range order.Items {
+		quantity, unit := item.UnitQuantity, item.UnitName
+		if quantity == 0 {
+			quantity, unit = item.Quantity, item.Product.BaseUnit
+		}
+		// Lines are printed at list price, with discounts taken off below
+		// the subtotal: before tax when it was added on top
+		total := roundMoney(item.Total + item.Discount)
+		if !order.TaxInclusive {
+			total = roundMoney(total - item.Tax)
+		}
+		r.Lines = append(r.Lines, receiptLine{
+			Name:      item.Product.Name,
+			Quantity:  quantity,
+			Unit:      unit,
+			UnitPrice: item.UnitPrice,
+			Total:     total,
+		})
+		r.Subtotal += total
+		r.Discount += item.Discount
+
+		if item.TaxClassID == 0 {
+			continue
+		}
+		label := classNames[item.TaxClassID]
+		if label == "" {
+			label = fmt.Sprintf("Tax %g%%", item.TaxRate)
+		}
+		if order.TaxInclusive {
+			label += " (incl.)"
+		}
+		i, ok := taxIndex[label]
+		if !ok {
+			i = len(r.Taxes)
+			taxIndex[label] = i
+			r.Taxes = append(r.Taxes, receiptTax{Label: label})
+		}
+		r.Taxes[i].Amount = roundMoney(r.Taxes[i].Amount + item.Tax)
+	}
+
+	var invoice models.FiscalInvoice
+	if err := database.DB.Where("sale_order_id = ? AND type = ? AND status = ?", order.ID, models.FiscalSale, models.FiscalSigned).
+		First(&invoice).Error; err == nil {
+		r.ControlNumber, r.QRCode = invoice.ControlNumber, invoice.QRCode
+	}
+	return r, nil
+}
+
+// SaleReceipt - Renders a sale order's receipt as an A4 PDF invoice, or with
+// ?format=escpos&width=58|80 as raw ESC/POS bytes for a thermal printer
+func SaleReceipt(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	r, err := loadReceipt(businessID.(uint), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sale order not found"})
+		return
+	}
+
+	switch c.DefaultQuery("format", "pdf") {
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=receipt_%s.pdf", r.Number))
+		c.Data(http.StatusOK, "application/pdf", generateReceiptPDF(r))
+	case "escpos":
+		columns := 48
+		switch c.DefaultQuery("width", "80") {
+		case "80":
+		case "58":
+			columns = 32
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "width must be 58 or 80"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=receipt_%s.bin", r.Number))
+		c.Data(http.StatusOK, "application/octet-stream", generateReceiptESCPOS(r, columns))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or escpos"})
+	}
+}
+
+func generateReceiptPDF(r receipt) []byte {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Arial", "B", 16)
+	pdf.CellFormat(0, 10, r.BusinessName, "", 1, "C", false, 0, "")
+	if r.LocationName != "" {
+		pdf.SetFont("Arial", "", 11)
+		pdf.CellFormat(0, 6, r.LocationName, "", 1, "C", false, 0, "")
+	}
+	pdf.Ln(5)
+
+	pdf.SetFont("Arial", "B", 14)
+	pdf.CellFormat(0, 10, "Receipt #"+r.Number, "", 1, "L", false, 0, "")
+	pdf.SetFont("Arial", "", 11)
+	pdf.CellFormat(0, 6, "Date: "+r.SoldAt.Format("2006-01-02 15:04"), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Served by: "+r.Cashier, "", 1, "L", false, 0, "")
+	if r.Customer != "" {
+		pdf.CellFormat(0, 6, "Customer: "+r.Customer, "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(5)
+
+	columns := []reportColumn{
+		{"Item", 80, "L", nil},
+		{"Quantity", 30, "C", nil},
+		{"Unit Price", 35, "R", nil},
+		{"Total", 35, "R", nil},
+	}
+	pdf.SetFont("Arial", "B", 12)
+	for i, col := range columns {
+		pdf.CellFormat(col.Width, 10, col.Header, "1", lineBreak(i, len(columns)), "C", false, 0, "")
+	}
+	pdf.SetFont("Arial", "", 12)
+	for _, line := range r.Lines {
+		values := []string{
+			line.Name,
+			formatQuantity(line.Quantity, line.Unit),
+			fmt.Sprintf("%.2f", line.UnitPrice),
+			fmt.Sprintf("%.2f", line.Total),
+		}
+		for i, col := range columns {
+			pdf.CellFormat(col.Width, 10, values[i], "1", lineBreak(i, len(columns)), col.Align, false, 0, "")
+		}
+	}
+	pdf.Ln(5)
+
+	// Totals line up under the last two columns
+	total := func(label, value string) {
+		pdf.CellFormat(110, 8, "", "", 0, "L", false, 0, "")
+		pdf.CellFormat(35, 8, label, "", 0, "L", false, 0, "")
+		pdf.CellFormat(35, 8, value, "", 1, "R", false, 0, "")
+	}
+	total("Subtotal", fmt.Sprintf("%.2f", r.Subtotal))
+	if r.Discount > 0 {
+		total("Discount", fmt.Sprintf("-%.2f", r.Discount))
+	}
+	for _, tax := range receiptTaxes(r) {
+		total(tax.Label, fmt.Sprintf("%.2f", tax.Amount))
+	}
+	pdf.SetFont("Arial", "B", 12)
+	total("Total", fmt.Sprintf("ksh %.2f", r.Total))
+	pdf.SetFont("Arial", "", 12)
+	if len(r.Tenders) == 0 {
+		total("Paid by", r.PaymentMethod)
+	}
+	for _, tender := range r.Tenders {
+		total(tender.Label, fmt.Sprintf("%.2f", tender.Amount))
+		if tender.Reference != "" {
+			total("  Ref", tender.Reference)
+		}
+	}
+	if r.Change > 0 {
+		total("Change", fmt.Sprintf("%.2f", r.Change))
+	}
+
+	if r.ControlNumber != "" {
+		pdf.Ln(5)
+		pdf.CellFormat(0, 8, "CU Invoice No: "+r.ControlNumber, "", 1, "C", false, 0, "")
+		if modules, err := encodeQR(r.QRCode, qrLevelM); err == nil {
+			const size = 35.0
+			drawQRCode(pdf, modules, (210-size)/2, pdf.GetY(), size)
+			pdf.SetY(pdf.GetY() + size)
+		}
+	}
+
+	pdf.Ln(10)
+	pdf.CellFormat(0, 8, "Thank you for your business", "", 1, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// drawQRCode draws a QR code's modules in a square of the given size,
+// including the light margin scanners need around it
+func drawQRCode(pdf *gofpdf.Fpdf, modules [][]bool, x, y, size float64) {
+	const quietZone = 4
+	module := size / float64(len(modules)+2*quietZone)
+	for row, cols := range modules {
+		for col, dark := range cols {
+			if dark {
+				pdf.Rect(x+float64(col+quietZone)*module, y+float64(row+quietZone)*module, module, module, "F")
+			}
+		}
+	}
+}
+
+// receiptTaxes lists the tax lines to print; a receipt always shows one
+func receiptTaxes(r receipt) []receiptTax {
+	if len(r.Taxes) == 0 {
+		return []receiptTax{{Label: "Tax", Amount: 0}}
+	}
+	return r.Taxes
+}
+
+// ESC/POS control sequences
+const (
+	escInit       = "\x1b@"
+	escAlignLeft  = "\x1ba\x00"
+	escAlignMid   = "\x1ba\x01"
+	escBoldOn     = "\x1bE\x01"
+	escBoldOff    = "\x1bE\x00"
+	escDoubleOn   = "\x1d!\x11"
+	escDoubleOff  = "\x1d!\x00"
+	escFeedAndCut = "\x1dVB\x03"
+)
+
+// generateReceiptESCPOS lays the receipt out for a thermal printer with
+// the given number of characters per line (32 for 58mm, 48 for 80mm)
+func generateReceiptESCPOS(r receipt, columns int) []byte {
+	// Widths below count bytes, so text is made single-byte first
+	r.BusinessName = asciiOnly(r.BusinessName)
+	r.LocationName = asciiOnly(r.LocationName)
+	r.Cashier = asciiOnly(r.Cashier)
+	r.Customer = asciiOnly(r.Customer)
+	tenders := make([]receiptTender, len(r.Tenders))
+	for i, tender := range r.Tenders {
+		tender.Reference = asciiOnly(tender.Reference)
+		tenders[i] = tender
+	}
+	r.Tenders = tenders
+	taxes := make([]receiptTax, len(r.Taxes))
+	for i, tax := range r.Taxes {
+		tax.Label = asciiOnly(tax.Label)
+		taxes[i] = tax
+	}
+	r.Taxes = taxes
+	lines := make([]receiptLine, len(r.Lines))
+	for i, item := range r.Lines {
+		item.Name = asciiOnly(item.Name)
+		item.Unit = asciiOnly(item.Unit)
+		lines[i] = item
+	}
+	r.Lines = lines
+
+	var b bytes.Buffer
+	line := func(text string) {
+		b.WriteString(text)
+		b.WriteString("\n")
+	}
+	rule := strings.Repeat("-", columns)
+
+	b.WriteString(escInit + escAlignMid + escBoldOn + escDoubleOn)
+	line(truncate(r.BusinessName, columns/2))
+	b.WriteString(escDoubleOff + escBoldOff)
+	if r.LocationName != "" {
+		line(truncate(r.LocationName, columns))
+	}
+	line("Receipt #" + r.Number)
+	b.WriteString(escAlignLeft)
+	line(spread("Date", r.SoldAt.Format("2006-01-02 15:04"), columns))
+	line(spread("Served by", r.Cashier, columns))
+	if r.Customer != "" {
+		line(spread("Customer", r.Customer, columns))
+	}
+	line(rule)
+
+	for _, item := range r.Lines {
+		line(truncate(item.Name, columns))
+		line(spread(
+			fmt.Sprintf("  %s x %.2f", formatQuantity(item.Quantity, item.Unit), item.UnitPrice),
+			fmt.Sprintf("%.2f", item.Total),
+			columns,
+		))
+	}
+	line(rule)
+
+	line(spread("Subtotal", fmt.Sprintf("%.2f", r.Subtotal), columns))
+	if r.Discount > 0 {
+		line(spread("Discount", fmt.Sprintf("-%.2f", r.Discount), columns))
+	}
+	for _, tax := range receiptTaxes(r) {
+		line(spread(tax.Label, fmt.Sprintf("%.2f", tax.Amount), columns))
+	}
+	b.WriteString(escBoldOn)
+	line(spread("TOTAL", fmt.Sprintf("%.2f", r.Total), columns))
+	b.WriteString(escBoldOff)
+	if len(r.Tenders) == 0 {
+		line(spread("Paid by", r.PaymentMethod, columns))
+	}
+	for _, tender := range r.Tenders {
+		line(spread(tender.Label, fmt.Sprintf("%.2f", tender.Amount), columns))
+		if tender.Reference != "" {
+			line(spread("  Ref", tender.Reference, columns))
+		}
+	}
+	if r.Change > 0 {
+		line(spread("Change", fmt.Sprintf("%.2f", r.Change), columns))
+	}
+	line(rule)
+
+	b.WriteString(escAlignMid)
+	if r.ControlNumber != "" {
+		line("CU Invoice No")
+		line(truncate(r.ControlNumber, columns))
+		b.WriteString(escQRCode(r.QRCode))
+		line("")
+	}
+	line("Thank you for your business")
+	b.WriteString(escFeedAndCut)
+	return b.Bytes()
+}
+
+// escQRCode has the printer draw a QR code itself, at error correction
+// level M with 4-dot modules
+func escQRCode(text string) string {
+	size := len(text) + 3
+	return "\x1d(k\x04\x001A2\x00" + // Model 2
+		"\x1d(k\x03\x001C\x04" + // Module size
+		"\x1d(k\x03\x001E1" + // Level M
+		"\x1d(k" + string([]byte{byte(size % 256), byte(size / 256)}) + "1P0" + text + // Store the data
+		"\x1d(k\x03\x001Q0" // Print it
+}
+
+// spread puts left and right at either end of a line of the given width
+func spread(left, right string, width int) string {
+	gap := width - len(left) - len(right)
+	if gap < 1 {
+		left = truncate(left, width-len(right)-1)
+		gap = 1
+	}
+	return left + strings.Repeat(" ", gap) + right
+}
+
+func truncate(text string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	if len(text) <= width {
+		return text
+	}
+	return text[:width]
+}
+
+// asciiOnly replaces characters outside the printer's default code page
+func asciiOnly(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 {
+			return '?'
+		}
+		return r
+	}, text)
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/jung-kurt/gofpdf"
 
 	"github.com/ken-eddy/stockApp/database"
@@ -17,14 +20,196 @@ type GenerateReportRequest struct {
 	ReportType string `json:"reportType" binding:"required"`
 	StartDate  string `json:"startDate" binding:"required"`
 	EndDate    string `json:"endDate" binding:"required"`
+	LocationID uint   `json:"locationId"` // Optional; 0 reports across all locations
 }
 
 type ReportRow struct {
-	Date       string
-	Product    string
-	Quantity   int
-	Price      float64
-	TotalValue float64
+	Date         string
+	Reference    string // e.g. the sale order a line belongs to
+	Product      string
+	Detail       string // e.g. an adjustment reason
+	Quantity     float64
+	Unit         string  // Unit Quantity and Price are in
+	BaseQuantity float64 // Quantity in the product's base unit, for totals
+	Price        float64
+	TotalValue   float64
+	Cost         float64    // Cost of goods, for profit reporting
+	Aged         [4]float64 // Amounts owed by agingBuckets, for aged receivables
+	Tax          float64    // Tax included in TotalValue, for the VAT return
+	Discount     float64    // Taken off Quantity x Price to make TotalValue
+}
+
+// categoryTotalLabel marks subtotal rows in the profit report
+const categoryTotalLabel = "Category total"
+
+// returnLabel marks returned goods in the sales report
+const returnLabel = "Return"
+
+// voidLabel marks lines of voided orders, which the sales report lists but
+// leaves out of its totals
+const voidLabel = "Void"
+
+// reportColumn describes one column of the PDF table
+type reportColumn struct {
+	Header string
+	Width  float64
+	Align  string
+	Value  func(ReportRow) string
+}
+
+var (
+	dateColumn     = reportColumn{"Date", 40, "C", func(r ReportRow) string { return r.Date }}
+	productColumn  = reportColumn{"Product", 50, "L", func(r ReportRow) string { return r.Product }}
+	quantityColumn = reportColumn{"Quantity", 30, "C", func(r ReportRow) string { return formatQuantity(r.Quantity, r.Unit) }}
+	priceColumn    = reportColumn{"Price", 30, "R", func(r ReportRow) string { return fmt.Sprintf("ksh %.2f", r.Price) }}
+	totalColumn    = reportColumn{"Total Value", 30, "R", func(r ReportRow) string { return fmt.Sprintf("ksh %.2f", r.TotalValue) }}
+)
+
+// reportColumns returns the table layout for a report type
+func reportColumns(reportType string) []reportColumn {
+	switch reportType {
+	case "sales":
+		date := dateColumn
+		date.Width = 30
+		product := productColumn
+		product.Width = 40
+		quantity := quantityColumn
+		quantity.Width = 20
+		price := priceColumn
+		price.Width = 25
+		return []reportColumn{
+			date,
+			{"Order", 20, "C", func(r ReportRow) string { return r.Reference }},
+			product,
+			quantity,
+			price,
+			{"Discount", 25, "R", func(r ReportRow) string {
+				if r.Discount == 0 {
+					return ""
+				}
+				return fmt.Sprintf("%.2f", r.Discount)
+			}},
+			totalColumn,
+		}
+	case "adjustments":
+		date := dateColumn
+		date.Width = 30
+		product := productColumn
+		product.Width = 45
+		quantity := quantityColumn
+		quantity.Width = 20
+		price := priceColumn
+		price.Width = 25
+		total := totalColumn
+		total.Width = 25
+		return []reportColumn{
+			date,
+			product,
+			{"Reason", 35, "L", func(r ReportRow) string { return r.Detail }},
+			quantity,
+			price,
+			total,
+		}
+	case "added-stock":
+		date := dateColumn
+		date.Width = 25
+		product := productColumn
+		product.Width = 45
+		quantity := quantityColumn
+		quantity.Width = 20
+		return []reportColumn{
+			date,
+			product,
+			{"Supplier", 35, "L", func(r ReportRow) string { return r.Detail }},
+			{"PO", 25, "C", func(r ReportRow) string { return r.Reference }},
+			quantity,
+			totalColumn,
+		}
+	case "profit":
+		product := productColumn
+		product.Width = 45
+		quantity := quantityColumn
+		quantity.Width = 15
+		return []reportColumn{
+			product,
+			{"Category", 30, "L", func(r ReportRow) string { return r.Detail }},
+			quantity,
+			{"Revenue", 25, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.TotalValue) }},
+			{"COGS", 25, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.Cost) }},
+			{"Profit", 25, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.TotalValue-r.Cost) }},
+			{"Margin", 15, "R", func(r ReportRow) string { return fmt.Sprintf("%.1f%%", marginPercent(r.TotalValue, r.Cost)) }},
+		}
+	case "shift":
+		return []reportColumn{
+			{"Item", 110, "L", func(r ReportRow) string { return r.Product }},
+			{"Count", 30, "C", func(r ReportRow) string {
+				if r.Quantity == 0 {
+					return ""
+				}
+				return formatQuantity(r.Quantity, "")
+			}},
+			{"Amount", 50, "R", func(r ReportRow) string { return fmt.Sprintf("ksh %.2f", r.TotalValue) }},
+		}
+	case "payments":
+		date := dateColumn
+		date.Width = 30
+		return []reportColumn{
+			date,
+			{"Tender", 40, "L", func(r ReportRow) string { return r.Detail }},
+			{"Source", 50, "L", func(r ReportRow) string { return r.Reference }},
+			{"Count", 30, "C", func(r ReportRow) string { return formatQuantity(r.Quantity, "") }},
+			{"Amount", 40, "R", func(r ReportRow) string { return fmt.Sprintf("ksh %.2f", r.TotalValue) }},
+		}
+	case "discounts":
+		return []reportColumn{
+			dateColumn,
+			{"Source", 80, "L", func(r ReportRow) string { return r.Detail }},
+			{"Lines", 30, "C", func(r ReportRow) string { return formatQuantity(r.Quantity, "") }},
+			{"Amount", 40, "R", func(r ReportRow) string { return fmt.Sprintf("ksh %.2f", r.TotalValue) }},
+		}
+	case "vat":
+		return []reportColumn{
+			{"Tax Class", 55, "L", func(r ReportRow) string { return r.Product }},
+			{"Code", 20, "C", func(r ReportRow) string { return r.Reference }},
+			{"Rate", 20, "C", func(r ReportRow) string { return fmt.Sprintf("%g%%", r.Price) }},
+			{"Taxable", 35, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.TotalValue-r.Tax) }},
+			{"Output Tax", 30, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.Tax) }},
+			{"Gross", 30, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.TotalValue) }},
+		}
+	case "aged-receivables":
+		aged := func(bucket int) reportColumn {
+			return reportColumn{agingBuckets[bucket], 23, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.Aged[bucket]) }}
+		}
+		return []reportColumn{
+			{"Customer", 45, "L", func(r ReportRow) string { return r.Product }},
+			{"Phone", 30, "L", func(r ReportRow) string { return r.Detail }},
+			aged(0),
+			aged(1),
+			aged(2),
+			aged(3),
+			{"Total", 23, "R", func(r ReportRow) string { return fmt.Sprintf("%.2f", r.TotalValue) }},
+		}
+	case "expired":
+		date := dateColumn
+		date.Width = 30
+		quantity := quantityColumn
+		quantity.Width = 20
+		price := priceColumn
+		price.Header = "Unit Cost"
+		price.Width = 25
+		total := totalColumn
+		total.Width = 25
+		return []reportColumn{
+			date,
+			productColumn,
+			{"Lot", 30, "C", func(r ReportRow) string { return r.Reference }},
+			quantity,
+			price,
+			total,
+		}
+	default:
+		return []reportColumn{dateColumn, productColumn, quantityColumn, priceColumn, totalColumn}
+	}
 }
 
 func GenerateReport(c *gin.Context) {
@@ -54,12 +239,23 @@ func GenerateReport(c *gin.Context) {
 		return
 	}
 
+	var location models.Location
+	if req.LocationID != 0 {
+		if location, err = resolveLocation(database.DB, businessID.(uint), req.LocationID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+			return
+		}
+	}
+
 	// Fetch business-specific data
-	rows, title, err := fetchReportData(businessID.(uint), req.ReportType, startDate, endDate)
+	rows, title, err := fetchReportData(businessID.(uint), req.LocationID, req.ReportType, startDate, endDate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if location.ID != 0 {
+		title += " - " + location.Name
+	}
 
 	// Generate PDF
 	pdf := generatePDF(rows, title, req.ReportType, startDate, endDate)
@@ -70,70 +266,236 @@ func GenerateReport(c *gin.Context) {
 	c.Data(http.StatusOK, "application/pdf", pdf)
 }
 
-func fetchReportData(businessID uint, reportType string, startDate, endDate time.Time) ([]ReportRow, string, error) {
+// fetchStockLevels returns the business's products with Quantity set to the
+// amount held at locationID, or the business total when locationID is 0.
+// A negative maxQuantity returns every product; otherwise kits are included
+// when assembled stock plus what their components can build is low.
+func fetchStockLevels(businessID, locationID uint, maxQuantity int) ([]models.Product, error) {
+	var products []models.Product
+	if locationID == 0 {
+		query := database.DB.Where("business_id = ?", businessID)
+		if maxQuantity >= 0 {
+			query = query.Where("quantity <= ? AND has_variants IS NOT TRUE AND is_kit IS NOT TRUE", maxQuantity)
+		}
+		if err := query.Find(&products).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		query := database.DB.Preload("Product").Where("business_id = ? AND location_id = ?", businessID, locationID)
+		if maxQuantity >= 0 {
+			query = query.Where("quantity <= ?", maxQuantity).Where(stockedProducts)
+		}
+		var levels []models.ProductStock
+		if err := query.Find(&levels).Error; err != nil {
+			return nil, err
+		}
+		for _, level := range levels {
+			product := level.Product
+			product.Quantity = level.Quantity
+			products = append(products, product)
+		}
+	}
+
+	if maxQuantity < 0 {
+		return products, nil
+	}
+	kits, err := lowStockKits(businessID, locationID, maxQuantity)
+	return append(products, kits...), err
+}
+
+// stockRowsByParent lays out stock levels with each parent product's total
+// followed by an indented line per variant.
+func stockRowsByParent(products []models.Product) ([]ReportRow, error) {
+	variants := make(map[uint][]models.Product)
+	var top []models.Product
+	seen := make(map[uint]bool)
+	var missing []uint
+	for _, product := range products {
+		if product.ParentID == nil {
+			top = append(top, product)
+			seen[product.ID] = true
+			continue
+		}
+		if len(variants[*product.ParentID]) == 0 {
+			missing = append(missing, *product.ParentID)
+		}
+		variants[*product.ParentID] = append(variants[*product.ParentID], product)
+	}
+
+	// A location's stock list may hold variants whose parent it has never stocked
+	var parents []models.Product
+	if len(missing) > 0 {
+		if err := database.DB.Where("id IN (?)", missing).Find(&parents).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, parent := range parents {
+		if !seen[parent.ID] {
+			top = append(top, parent)
+		}
+	}
+
+	var rows []ReportRow
+	for _, product := range top {
+		if !product.HasVariants {
+			rows = append(rows, ReportRow{
+				Product:    product.Name,
+				Quantity:   product.Quantity,
+				Unit:       product.BaseUnit,
+				Price:      product.Price,
+				TotalValue: product.Quantity * product.Price,
+			})
+			continue
+		}
+
+		parent := ReportRow{Product: product.Name, Unit: product.BaseUnit, Price: product.Price}
+		var lines []ReportRow
+		for _, variant := range variants[product.ID] {
+			line := ReportRow{
+				Product:    "  " + variant.VariantName,
+				Quantity:   variant.Quantity,
+				Unit:       variant.BaseUnit,
+				Price:      variant.Price,
+				TotalValue: variant.Quantity * variant.Price,
+			}
+			parent.Quantity += line.Quantity
+			parent.TotalValue += line.TotalValue
+			lines = append(lines, line)
+		}
+		rows = append(rows, parent)
+		rows = append(rows, lines...)
+	}
+	return rows, nil
+}
+
+func fetchReportData(businessID, locationID uint, reportType string, startDate, endDate time.Time) ([]ReportRow, string, error) {
 	var rows []ReportRow
 	var title string
 
+	// byLocation narrows a query to the requested location, if any
+	byLocation := func(query *gorm.DB) *gorm.DB {
+		if locationID == 0 {
+			return query
+		}
+		return query.Where("location_id = ?", locationID)
+	}
+
 	switch reportType {
 	case "sales":
 		var sales []models.Sale
-		if err := database.DB.Preload("Product").
+		if err := byLocation(database.DB.Preload("Product")).
 			Where("business_id = ? AND sold_at BETWEEN ? AND ?", businessID, startDate, endDate).
+			Order("sale_order_id, sold_at").
 			Find(&sales).Error; err != nil {
 			return nil, "", err
 		}
 		for _, sale := range sales {
+			// Older lines were recorded before unit prices were stored
+			price := sale.UnitPrice
+			if price == 0 {
+				price = sale.Product.Price
+			}
+			// and before sale units, when every line was in the base unit
+			quantity, unit := sale.UnitQuantity, sale.UnitName
+			if quantity == 0 {
+				quantity, unit = sale.Quantity, sale.Product.BaseUnit
+			}
+			var reference, detail string
+			if sale.SaleOrderID != 0 {
+				reference = fmt.Sprintf("#%d", sale.SaleOrderID)
+			}
+			if sale.Voided {
+				reference, detail = "V "+reference, voidLabel
+			}
 			rows = append(rows, ReportRow{
-				Date:       sale.SoldAt.Format("2006-01-02"),
-				Product:    sale.Product.Name,
-				Quantity:   sale.Quantity,
-				Price:      sale.Product.Price,
-				TotalValue: sale.Total,
+				Date:         sale.SoldAt.Format("2006-01-02"),
+				Reference:    reference,
+				Product:      sale.Product.Name,
+				Detail:       detail,
+				Quantity:     quantity,
+				Unit:         unit,
+				BaseQuantity: sale.Quantity,
+				Price:        price,
+				TotalValue:   sale.Total,
+				Discount:     sale.Discount,
 			})
 		}
+
+		// Returns made in the period count against it, as negative lines
+		returnRows, err := database.DB.Raw(
+			`SELECT r.returned_at, r.sale_order_id, p.name, l.unit_quantity,
+				COALESCE(NULLIF(s.unit_name, ''), p.base_unit), l.quantity, s.unit_price, l.refund_amount
+			FROM sale_return_lines l
+			JOIN sale_returns r ON r.id = l.sale_return_id
+			JOIN sales s ON s.id = l.sale_id
+			JOIN products p ON p.id = l.product_id
+			WHERE r.business_id = ? AND r.returned_at BETWEEN ? AND ? AND r.deleted_at IS NULL
+				AND (? = 0 OR r.location_id = ?)
+			ORDER BY r.returned_at, l.id`,
+			businessID, startDate, endDate, locationID, locationID,
+		).Rows()
+		if err != nil {
+			return nil, "", err
+		}
+		defer returnRows.Close()
+		for returnRows.Next() {
+			var returnedAt time.Time
+			var orderID uint
+			row := ReportRow{Detail: returnLabel}
+			if err := returnRows.Scan(&returnedAt, &orderID, &row.Product, &row.Quantity, &row.Unit,
+				&row.BaseQuantity, &row.Price, &row.TotalValue); err != nil {
+				return nil, "", err
+			}
+			row.Date = returnedAt.Format("2006-01-02")
+			row.Reference = fmt.Sprintf("R #%d", orderID)
+			row.Quantity, row.BaseQuantity, row.TotalValue = -row.Quantity, -row.BaseQuantity, -row.TotalValue
+			rows = append(rows, row)
+		}
 		title = "Sales Report"
 
 	case "current-stock":
-		var products []models.Product
-		if err := database.DB.
-			Where("business_id = ?", businessID).
-			Find(&products).Error; err != nil {
+		products, err := fetchStockLevels(businessID, locationID, -1)
+		if err != nil {
 			return nil, "", err
 		}
-		for _, product := range products {
-			rows = append(rows, ReportRow{
-				Date:       "", // Not applicable for stock report
-				Product:    product.Name,
-				Quantity:   product.Quantity,
-				Price:      product.Price,
-				TotalValue: float64(product.Quantity) * product.Price,
-			})
+		rows, err = stockRowsByParent(products)
+		if err != nil {
+			return nil, "", err
 		}
 		title = "Current Stock Report"
 
 	case "added-stock":
 		var stockAdditions []models.Stock
-		if err := database.DB.Preload("Product").
-			Where("business_id = ? AND added_at BETWEEN ? AND ?", businessID, startDate, endDate).
+		if err := byLocation(database.DB.Preload("Product").Preload("Supplier").Preload("PurchaseOrder")).
+			Where("business_id = ? AND COALESCE(transfer_id, 0) = 0 AND COALESCE(sale_return_id, 0) = 0 AND COALESCE(adjustment_id, 0) = 0 AND assembled IS NOT TRUE AND added_at BETWEEN ? AND ?",
+				businessID, startDate, endDate).
 			Find(&stockAdditions).Error; err != nil {
 			return nil, "", err
 		}
 		for _, addition := range stockAdditions {
+			var supplier, poNumber string
+			if addition.Supplier != nil {
+				supplier = addition.Supplier.Name
+			}
+			if addition.PurchaseOrder != nil {
+				poNumber = addition.PurchaseOrder.Number
+			}
 			rows = append(rows, ReportRow{
 				Date:       addition.AddedAt.Format("2006-01-02"),
+				Reference:  poNumber,
 				Product:    addition.Product.Name,
+				Detail:     supplier,
 				Quantity:   addition.Quantity,
+				Unit:       addition.Product.BaseUnit,
 				Price:      addition.Product.Price,
-				TotalValue: float64(addition.Quantity) * addition.Product.Price,
+				TotalValue: addition.Quantity * addition.Product.Price,
 			})
 		}
 		title = "Added Stock Report"
 
 	case "low-stock":
-		var products []models.Product
-		if err := database.DB.
-			Where("business_id = ? AND quantity < ?", businessID, 10).
-			Find(&products).Error; err != nil {
+		products, err := fetchStockLevels(businessID, locationID, 9)
+		if err != nil {
 			return nil, "", err
 		}
 		for _, product := range products {
@@ -141,12 +503,247 @@ func fetchReportData(businessID uint, reportType string, startDate, endDate time
 				Date:       "", // Not applicable for low stock report
 				Product:    product.Name,
 				Quantity:   product.Quantity,
+				Unit:       product.BaseUnit,
 				Price:      product.Price,
-				TotalValue: float64(product.Quantity) * product.Price,
+				TotalValue: product.Quantity * product.Price,
 			})
 		}
 		title = "Low Stock Report"
 
+	case "adjustments":
+		var adjustments []models.StockAdjustment
+		if err := byLocation(database.DB.Preload("Product")).
+			Where("business_id = ? AND status = ? AND approved_at BETWEEN ? AND ?",
+				businessID, models.AdjustmentApproved, startDate, endDate).
+			Order("approved_at").
+			Find(&adjustments).Error; err != nil {
+			return nil, "", err
+		}
+		for _, adjustment := range adjustments {
+			rows = append(rows, ReportRow{
+				Date:       adjustment.ApprovedAt.Format("2006-01-02"),
+				Product:    adjustment.Product.Name,
+				Detail:     strings.ReplaceAll(adjustment.Reason, "_", " "),
+				Quantity:   adjustment.Quantity,
+				Unit:       adjustment.Product.BaseUnit,
+				Price:      adjustment.Product.Price,
+				TotalValue: adjustment.Quantity * adjustment.Product.Price,
+			})
+		}
+		title = "Stock Adjustments Report"
+
+	case "profit":
+		// Revenue is net of VAT, which is owed to the tax authority
+		sqlRows, err := database.DB.Raw(
+			`SELECT COALESCE(pp.name, p.name) AS product_name, COALESCE(c.name, ''), MAX(p.base_unit),
+				SUM(s.quantity), SUM(s.total), COALESCE(SUM(s.cost), 0)
+			FROM (
+				SELECT product_id, quantity, total - COALESCE(tax, 0) AS total, cost
+				FROM sales
+				WHERE business_id = ? AND sold_at BETWEEN ? AND ? AND deleted_at IS NULL
+					AND voided IS NOT TRUE AND (? = 0 OR location_id = ?)
+				UNION ALL
+				-- Refunds come off revenue; restocked goods come off cost, written-off goods stay a cost
+				SELECT l.product_id, -l.quantity, -(l.refund_amount - COALESCE(l.tax, 0)), CASE WHEN l.restock THEN -l.cost ELSE 0 END
+				FROM sale_return_lines l
+				JOIN sale_returns r ON r.id = l.sale_return_id
+				WHERE r.business_id = ? AND r.returned_at BETWEEN ? AND ? AND r.deleted_at IS NULL
+					AND (? = 0 OR r.location_id = ?)
+			) s
+			JOIN products p ON p.id = s.product_id
+			LEFT JOIN products pp ON pp.id = p.parent_id
+			LEFT JOIN categories c ON c.id = COALESCE(pp.category_id, p.category_id)
+			GROUP BY COALESCE(p.parent_id, p.id), product_name, c.name
+			ORDER BY c.name, product_name`,
+			businessID, startDate, endDate, locationID, locationID,
+			businessID, startDate, endDate, locationID, locationID,
+		).Rows()
+		if err != nil {
+			return nil, "", err
+		}
+		defer sqlRows.Close()
+
+		// Products are grouped by category, each group followed by its subtotal
+		var subtotal *ReportRow
+		for sqlRows.Next() {
+			var row ReportRow
+			if err := sqlRows.Scan(&row.Product, &row.Detail, &row.Unit, &row.Quantity, &row.TotalValue, &row.Cost); err != nil {
+				return nil, "", err
+			}
+			if subtotal != nil && subtotal.Detail != row.Detail {
+				rows = append(rows, *subtotal)
+				subtotal = nil
+			}
+			if subtotal == nil {
+				subtotal = &ReportRow{Product: categoryTotalLabel, Detail: row.Detail}
+			}
+			subtotal.Quantity += row.Quantity
+			subtotal.TotalValue += row.TotalValue
+			subtotal.Cost += row.Cost
+			rows = append(rows, row)
+		}
+		if subtotal != nil {
+			rows = append(rows, *subtotal)
+		}
+		title = "Profit Report"
+
+	case "expired":
+		// Lots that had expired by the end of the period and still hold stock
+		var lots []models.Stock
+		if err := byLocation(database.DB.Preload("Product")).
+			Where("business_id = ? AND remaining > 0 AND expires_at BETWEEN ? AND ?", businessID, startDate, endDate).
+			Order("expires_at").
+			Find(&lots).Error; err != nil {
+			return nil, "", err
+		}
+		for _, lot := range lots {
+			rows = append(rows, ReportRow{
+				Date:       lot.ExpiresAt.Format("2006-01-02"),
+				Reference:  lot.LotNumber,
+				Product:    lot.Product.Name,
+				Quantity:   lot.Remaining,
+				Unit:       lot.Product.BaseUnit,
+				Price:      lot.UnitCost,
+				TotalValue: lot.Remaining * lot.UnitCost,
+			})
+		}
+		title = "Expired Stock Report"
+
+	case "payments":
+		// Money taken per tender and day: sale tenders net of change, payments
+		// against customer accounts, and refunds going back out
+		sqlRows, err := database.DB.Raw(
+			`SELECT day, method, source, COUNT(*), SUM(amount)
+			FROM (
+				SELECT DATE(p.paid_at) AS day, p.method, 'Sales' AS source, p.amount - COALESCE(p.change, 0) AS amount
+				FROM sale_payments p
+				JOIN sale_orders o ON o.id = p.sale_order_id
+				WHERE p.business_id = ? AND p.paid_at BETWEEN ? AND ? AND p.deleted_at IS NULL
+					AND p.status = 'completed' AND o.voided_at IS NULL AND (? = 0 OR o.location_id = ?)
+				UNION ALL
+				SELECT DATE(paid_at), method, 'Account payments', amount
+				FROM customer_payments
+				WHERE business_id = ? AND paid_at BETWEEN ? AND ? AND deleted_at IS NULL AND ? = 0
+				UNION ALL
+				SELECT DATE(returned_at), refund_method, 'Refunds', -refund_total
+				FROM sale_returns
+				WHERE business_id = ? AND returned_at BETWEEN ? AND ? AND deleted_at IS NULL
+					AND (? = 0 OR location_id = ?)
+			) t
+			GROUP BY day, method, source
+			ORDER BY day, method, source`,
+			businessID, startDate, endDate, locationID, locationID,
+			businessID, startDate, endDate, locationID,
+			businessID, startDate, endDate, locationID, locationID,
+		).Rows()
+		if err != nil {
+			return nil, "", err
+		}
+		defer sqlRows.Close()
+		for sqlRows.Next() {
+			var day time.Time
+			var method string
+			var row ReportRow
+			if err := sqlRows.Scan(&day, &method, &row.Reference, &row.Quantity, &row.TotalValue); err != nil {
+				return nil, "", err
+			}
+			row.Date = day.Format("2006-01-02")
+			row.Detail = paymentMethodLabels[method]
+			if row.Detail == "" {
+				row.Detail = method
+			}
+			rows = append(rows, row)
+		}
+		title = "Payments Report"
+
+	case "discounts":
+		// Discounts given per day, by the promotion that gave them or by hand
+		sqlRows, err := database.DB.Raw(
+			`SELECT day, source, COUNT(*), SUM(amount)
+			FROM (
+				SELECT DATE(s.sold_at) AS day, COALESCE(p.name, 'Promotion') AS source, s.promotion_discount AS amount
+				FROM sales s
+				LEFT JOIN promotions p ON p.id = s.promotion_id
+				WHERE s.business_id = ? AND s.sold_at BETWEEN ? AND ? AND s.deleted_at IS NULL
+					AND s.voided IS NOT TRUE AND s.promotion_discount > 0 AND (? = 0 OR s.location_id = ?)
+				UNION ALL
+				SELECT DATE(sold_at), 'Manual', discount - promotion_discount
+				FROM sales
+				WHERE business_id = ? AND sold_at BETWEEN ? AND ? AND deleted_at IS NULL
+					AND voided IS NOT TRUE AND discount - promotion_discount > 0 AND (? = 0 OR location_id = ?)
+			) d
+			GROUP BY day, source
+			ORDER BY day, source`,
+			businessID, startDate, endDate, locationID, locationID,
+			businessID, startDate, endDate, locationID, locationID,
+		).Rows()
+		if err != nil {
+			return nil, "", err
+		}
+		defer sqlRows.Close()
+		for sqlRows.Next() {
+			var day time.Time
+			var row ReportRow
+			if err := sqlRows.Scan(&day, &row.Detail, &row.Quantity, &row.TotalValue); err != nil {
+				return nil, "", err
+			}
+			row.Date = day.Format("2006-01-02")
+			rows = append(rows, row)
+		}
+		title = "Discounts Report"
+
+	case "vat":
+		// Output tax by class and rate, net of returns in the same period
+		sqlRows, err := database.DB.Raw(
+			`SELECT COALESCE(t.name, 'Not taxed'), COALESCE(t.code, ''), x.rate, SUM(x.gross), SUM(x.tax)
+			FROM (
+				SELECT tax_class_id, tax_rate AS rate, total AS gross, tax
+				FROM sales
+				WHERE business_id = ? AND sold_at BETWEEN ? AND ? AND deleted_at IS NULL
+					AND voided IS NOT TRUE AND (? = 0 OR location_id = ?)
+				UNION ALL
+				SELECT s.tax_class_id, s.tax_rate, -l.refund_amount, -l.tax
+				FROM sale_return_lines l
+				JOIN sale_returns r ON r.id = l.sale_return_id
+				JOIN sales s ON s.id = l.sale_id
+				WHERE r.business_id = ? AND r.returned_at BETWEEN ? AND ?
+					AND r.deleted_at IS NULL AND l.deleted_at IS NULL AND (? = 0 OR r.location_id = ?)
+			) x
+			LEFT JOIN tax_classes t ON t.id = x.tax_class_id
+			GROUP BY t.name, t.code, x.rate
+			ORDER BY x.rate DESC, t.name`,
+			businessID, startDate, endDate, locationID, locationID,
+			businessID, startDate, endDate, locationID, locationID,
+		).Rows()
+		if err != nil {
+			return nil, "", err
+		}
+		defer sqlRows.Close()
+		for sqlRows.Next() {
+			var row ReportRow
+			if err := sqlRows.Scan(&row.Product, &row.Reference, &row.Price, &row.TotalValue, &row.Tax); err != nil {
+				return nil, "", err
+			}
+			rows = append(rows, row)
+		}
+		title = "VAT Report"
+
+	case "aged-receivables":
+		// Always what is owed now; the period doesn't apply
+		receivables, err := agedReceivables(businessID, 0, time.Now())
+		if err != nil {
+			return nil, "", err
+		}
+		for _, r := range receivables {
+			rows = append(rows, ReportRow{
+				Product:    r.Name,
+				Detail:     r.Phone,
+				Aged:       r.Aged,
+				TotalValue: r.Total,
+			})
+		}
+		title = "Aged Receivables Report"
+
 	default:
 		return nil, "", fmt.Errorf("invalid report type")
 	}
@@ -165,41 +762,121 @@ func generatePDF(rows []ReportRow, title, reportType string, startDate, endDate
 
 	// Date Range
 	pdf.SetFont("Arial", "", 12)
-	if reportType != "current-stock" && reportType != "low-stock" {
+	if reportType == "aged-receivables" {
+		var due float64
+		for _, row := range rows {
+			due += row.TotalValue
+		}
+		pdf.CellFormat(0, 10, "As of: "+time.Now().Format("2006-01-02"), "", 1, "L", false, 0, "")
+		pdf.CellFormat(0, 10, fmt.Sprintf("Total Receivable: ksh %.2f", due), "", 1, "L", false, 0, "")
+		pdf.Ln(5)
+	} else if reportType == "shift" {
+		// Shifts run by the hour, so print times as well as dates
+		pdf.CellFormat(0, 10, fmt.Sprintf("From %s to %s", startDate.Format("2006-01-02 15:04"), endDate.Format("2006-01-02 15:04")), "", 1, "L", false, 0, "")
+		pdf.Ln(5)
+	} else if reportType != "current-stock" && reportType != "low-stock" {
 		pdf.CellFormat(0, 10, fmt.Sprintf("Date Range: %s to %s", startDate.Format("2006-01-02"), endDate.Format("2006-01-02")), "", 1, "L", false, 0, "")
 		pdf.Ln(5)
 
 		// Only for Sales Report: Calculate total items and total sales value
 		if reportType == "sales" {
-			var totalItems int
-			var totalValue float64
+			var totalItems, grossSales, returns, voided, discounts float64
 			for _, row := range rows {
-				totalItems += row.Quantity
-				totalValue += row.TotalValue
+				if row.Detail == voidLabel {
+					voided += row.TotalValue
+					continue
+				}
+				totalItems += row.BaseQuantity
+				discounts += row.Discount
+				if row.Detail == returnLabel {
+					returns -= row.TotalValue
+				} else {
+					grossSales += row.TotalValue
+				}
 			}
 
-			pdf.CellFormat(0, 10, fmt.Sprintf("Total Items Sold: %d", totalItems), "", 1, "L", false, 0, "")
-			pdf.CellFormat(0, 10, fmt.Sprintf("Total Sales: ksh %.2f", totalValue), "", 1, "L", false, 0, "")
+			pdf.CellFormat(0, 10, "Net Items Sold: "+formatQuantity(totalItems, ""), "", 1, "L", false, 0, "")
+			pdf.CellFormat(0, 10, fmt.Sprintf("Gross Sales: ksh %.2f", grossSales), "", 1, "L", false, 0, "")
+			if discounts > 0 {
+				pdf.CellFormat(0, 10, fmt.Sprintf("Discounts Given: ksh %.2f", discounts), "", 1, "L", false, 0, "")
+			}
+			pdf.CellFormat(0, 10, fmt.Sprintf("Returns: ksh %.2f", returns), "", 1, "L", false, 0, "")
+			pdf.CellFormat(0, 10, fmt.Sprintf("Net Sales: ksh %.2f", grossSales-returns), "", 1, "L", false, 0, "")
+			if voided > 0 {
+				pdf.CellFormat(0, 10, fmt.Sprintf("Voided (not counted): ksh %.2f", voided), "", 1, "L", false, 0, "")
+			}
+			pdf.Ln(5)
+		}
+
+		if reportType == "payments" {
+			// Totals per tender, in the order tenders first appear
+			var tenders []string
+			totals := make(map[string]float64)
+			for _, row := range rows {
+				if _, ok := totals[row.Detail]; !ok {
+					tenders = append(tenders, row.Detail)
+				}
+				totals[row.Detail] += row.TotalValue
+			}
+			for _, tender := range tenders {
+				pdf.CellFormat(0, 10, fmt.Sprintf("%s: ksh %.2f", tender, totals[tender]), "", 1, "L", false, 0, "")
+			}
+			pdf.Ln(5)
+		}
+
+		if reportType == "discounts" {
+			var given float64
+			for _, row := range rows {
+				given += row.TotalValue
+			}
+
+			pdf.CellFormat(0, 10, fmt.Sprintf("Discounts Given: ksh %.2f", given), "", 1, "L", false, 0, "")
+			pdf.Ln(5)
+		}
+
+		if reportType == "vat" {
+			var gross, tax float64
+			for _, row := range rows {
+				gross += row.TotalValue
+				tax += row.Tax
+			}
+
+			pdf.CellFormat(0, 10, fmt.Sprintf("Taxable Sales: ksh %.2f", gross-tax), "", 1, "L", false, 0, "")
+			pdf.CellFormat(0, 10, fmt.Sprintf("Output Tax: ksh %.2f", tax), "", 1, "L", false, 0, "")
+			pdf.Ln(5)
+		}
+
+		if reportType == "profit" {
+			var revenue, cost float64
+			for _, row := range rows {
+				if row.Product == categoryTotalLabel {
+					continue
+				}
+				revenue += row.TotalValue
+				cost += row.Cost
+			}
+
+			pdf.CellFormat(0, 10, fmt.Sprintf("Revenue: ksh %.2f", revenue), "", 1, "L", false, 0, "")
+			pdf.CellFormat(0, 10, fmt.Sprintf("Cost of Goods Sold: ksh %.2f", cost), "", 1, "L", false, 0, "")
+			pdf.CellFormat(0, 10, fmt.Sprintf("Gross Profit: ksh %.2f (%.1f%%)", revenue-cost, marginPercent(revenue, cost)), "", 1, "L", false, 0, "")
 			pdf.Ln(5)
 		}
 	}
 
+	columns := reportColumns(reportType)
+
 	// Table Header
 	pdf.SetFont("Arial", "B", 12)
-	pdf.CellFormat(40, 10, "Date", "1", 0, "C", false, 0, "")
-	pdf.CellFormat(50, 10, "Product", "1", 0, "C", false, 0, "")
-	pdf.CellFormat(30, 10, "Quantity", "1", 0, "C", false, 0, "")
-	pdf.CellFormat(30, 10, "Price", "1", 0, "C", false, 0, "")
-	pdf.CellFormat(30, 10, "Total Value", "1", 1, "C", false, 0, "")
+	for i, col := range columns {
+		pdf.CellFormat(col.Width, 10, col.Header, "1", lineBreak(i, len(columns)), "C", false, 0, "")
+	}
 
 	// Table Rows
 	pdf.SetFont("Arial", "", 12)
 	for _, row := range rows {
-		pdf.CellFormat(40, 10, row.Date, "1", 0, "C", false, 0, "")
-		pdf.CellFormat(50, 10, row.Product, "1", 0, "L", false, 0, "")
-		pdf.CellFormat(30, 10, fmt.Sprintf("%d", row.Quantity), "1", 0, "C", false, 0, "")
-		pdf.CellFormat(30, 10, fmt.Sprintf("ksh %.2f", row.Price), "1", 0, "R", false, 0, "")
-		pdf.CellFormat(30, 10, fmt.Sprintf("ksh %.2f", row.TotalValue), "1", 1, "R", false, 0, "")
+		for i, col := range columns {
+			pdf.CellFormat(col.Width, 10, col.Value(row), "1", lineBreak(i, len(columns)), col.Align, false, 0, "")
+		}
 	}
 
 	// Write PDF to buffer
@@ -210,3 +887,28 @@ func generatePDF(rows []ReportRow, title, reportType string, startDate, endDate
 	}
 	return buf.Bytes()
 }
+
+// lineBreak tells CellFormat to move to the next line after the last column
+func lineBreak(i, count int) int {
+	if i == count-1 {
+		return 1
+	}
+	return 0
+}
+
+// formatQuantity prints a quantity without trailing zeros, followed by its unit
+func formatQuantity(quantity float64, unit string) string {
+	text := strconv.FormatFloat(quantity, 'f', -1, 64)
+	if unit == "" {
+		return text
+	}
+	return text + " " + unit
+}
+
+// marginPercent is gross profit as a percentage of revenue
+func marginPercent(revenue, cost float64) float64 {
+	if revenue == 0 {
+		return 0
+	}
+	return (revenue - cost) / revenue * 100
+}
@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// CreateSaleReturn - Takes back some or all of a sale order's items. Each
+// line is either restocked at the cost it was sold at or written off, and
+// the refund is recorded against a payment method. Omitting lines returns
+// everything not already returned.
+func CreateSaleReturn(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Reason       string `json:"reason" binding:"required"`
+		Note         string `json:"note"`
+		RefundMethod string `json:"refund_method" binding:"required,oneof=cash mpesa card credit"` // credit reduces what the customer owes
+		Restock      bool   `json:"restock"`                                                       // For lines that don't say otherwise
+		Lines        []struct {
+			SaleID   uint    `json:"sale_id" binding:"required"`
+			Quantity float64 `json:"quantity" binding:"required,gt=0"` // In the unit the item was sold in
+			Restock  *bool   `json:"restock"`
+		} `json:"lines" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// Locking the order keeps a return and a void from both going through
+	order, err := lockSaleOrder(tx, businessID, c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sale order not found"})
+		return
+	} else if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sale order"})
+		return
+	}
+	if order.VoidedAt != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order has been voided"})
+		return
+	}
+	if order.PaymentStatus == models.OrderPendingPayment {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order is still waiting for payment"})
+		return
+	}
+	if input.RefundMethod == models.PaymentCredit && order.AmountDue <= 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing is owed on this order to refund against"})
+		return
+	}
+
+	// Locking the lines stops two returns taking back the same items
+	var sales []models.Sale
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("sale_order_id = ?", order.ID).
+		Order("id").
+		Find(&sales).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sale lines"})
+		return
+	}
+
+	type returnRequest struct {
+		sale     *models.Sale
+		quantity float64
+		restock  bool
+	}
+	var requests []returnRequest
+	if len(input.Lines) == 0 {
+		for i := range sales {
+			if outstanding := roundQuantity(soldQuantity(sales[i]) - sales[i].Returned); outstanding > 0 {
+				requests = append(requests, returnRequest{&sales[i], outstanding, input.Restock})
+			}
+		}
+	}
+	// A sale may be split over several lines, e.g. to restock only some
+	requested := make(map[uint]float64)
+	for _, line := range input.Lines {
+		var sale *models.Sale
+		for i := range sales {
+			if sales[i].ID == line.SaleID {
+				sale = &sales[i]
+			}
+		}
+		if sale == nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Sale %d is not on this order", line.SaleID)})
+			return
+		}
+		requested[sale.ID] = roundQuantity(requested[sale.ID] + line.Quantity)
+		if roundQuantity(sale.Returned+requested[sale.ID]) > soldQuantity(*sale) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Sale %d would return more than was sold", line.SaleID)})
+			return
+		}
+		restock := input.Restock
+		if line.Restock != nil {
+			restock = *line.Restock
+		}
+		requests = append(requests, returnRequest{sale, line.Quantity, restock})
+	}
+	if len(requests) == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing left to return on this order"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	shiftID, err := openShiftID(tx, order.BusinessID, userID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record return"})
+		return
+	}
+
+	saleReturn := models.SaleReturn{
+		BusinessID:   order.BusinessID,
+		SaleOrderID:  order.ID,
+		LocationID:   order.LocationID,
+		UserID:       userID,
+		ShiftID:      shiftID,
+		Reason:       input.Reason,
+		Note:         input.Note,
+		RefundMethod: input.RefundMethod,
+		ReturnedAt:   time.Now(),
+	}
+	if err := tx.Create(&saleReturn).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record return"})
+		return
+	}
+
+	for _, request := range requests {
+		sale := request.sale
+
+		// Refund and cost are the returned share of what the line sold for
+		share := request.quantity / soldQuantity(*sale)
+		quantity := roundQuantity(sale.Quantity * share)
+
+		var product models.Product
+		if err := tx.Select("id, name, base_unit, allow_decimal").First(&product, sale.ProductID).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load product"})
+			return
+		}
+		if !product.AllowDecimal && !isWhole(quantity) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s can only be returned in whole %s", product.Name, product.BaseUnit)})
+			return
+		}
+
+		line := models.SaleReturnLine{
+			SaleReturnID: saleReturn.ID,
+			SaleID:       sale.ID,
+			ProductID:    sale.ProductID,
+			UnitQuantity: request.quantity,
+			Quantity:     quantity,
+			Restock:      request.restock,
+			RefundAmount: roundMoney(sale.Total * share),
+			Tax:          roundMoney(sale.Tax * share),
+			Cost:         sale.Cost * share,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record return line"})
+			return
+		}
+
+		if line.Restock && line.Quantity > 0 {
+			stock := models.Stock{
+				BusinessID:   order.BusinessID,
+				ProductID:    sale.ProductID,
+				LocationID:   order.LocationID,
+				SaleReturnID: saleReturn.ID,
+				Quantity:     line.Quantity,
+				UnitCost:     line.Cost / line.Quantity,
+				AddedAt:      saleReturn.ReturnedAt,
+			}
+			if err := returnStock(tx, &stock, &models.StockMovement{
+				BusinessID:    order.BusinessID,
+				ProductID:     sale.ProductID,
+				Reason:        input.Reason,
+				UserID:        userID,
+				ReferenceType: "sale_return",
+				ReferenceID:   saleReturn.ID,
+			}); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+				return
+			}
+		}
+
+		sale.Returned = roundQuantity(sale.Returned + request.quantity)
+		if err := tx.Model(sale).UpdateColumn("returned", sale.Returned).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update sale"})
+			return
+		}
+		saleReturn.RefundTotal += line.RefundAmount
+		saleReturn.Lines = append(saleReturn.Lines, line)
+	}
+
+	if err := tx.Model(&saleReturn).UpdateColumn("refund_total", saleReturn.RefundTotal).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record refund"})
+		return
+	}
+
+	// Refunds to account come off what is still owed; anything already
+	// paid is refunded in money
+	if input.RefundMethod == models.PaymentCredit {
+		if roundMoney(saleReturn.RefundTotal) > order.AmountDue {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Only %.2f is still owed on this order; refund the rest in cash", order.AmountDue)})
+			return
+		}
+		if err := settleOrder(tx, &order, saleReturn.RefundTotal); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer account"})
+			return
+		}
+	}
+
+	if err := queueFiscalCreditNote(tx, &order, saleReturn.ID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue credit note"})
+		return
+	}
+
+	tx.Commit()
+	fiscalizeOrder(order.ID)
+
+	c.JSON(http.StatusCreated, saleReturn)
+}
+
+// soldQuantity is how much a sale line sold in its own unit
+func soldQuantity(sale models.Sale) float64 {
+	// Lines recorded before sale units only have a base quantity
+	if sale.UnitQuantity == 0 {
+		return sale.Quantity
+	}
+	return sale.UnitQuantity
+}
+
+func GetSaleReturns(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := database.DB.Where("business_id = ?", businessID)
+	if orderID := c.Query("sale_order_id"); orderID != "" {
+		query = query.Where("sale_order_id = ?", orderID)
+	}
+
+	var returns []models.SaleReturn
+	if err := query.Order("returned_at DESC").Find(&returns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, returns)
+}
+
+func GetSaleReturn(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var saleReturn models.SaleReturn
+	if err := database.DB.Preload("Lines").Preload("Lines.Product").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&saleReturn).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, saleReturn)
+}
@@ -1,14 +1,289 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/ken-eddy/stockApp/database"
 	"github.com/ken-eddy/stockApp/models"
+	"github.com/ken-eddy/stockApp/payments"
 )
 
+var (
+	errProductNotFound   = errors.New("product not found in your business")
+	errInsufficientStock = errors.New("insufficient stock")
+	errNotSellable       = errors.New("choose a variant to sell")
+)
+
+// roundMoney rounds an amount to the cent
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// saleLineInput is one basket line submitted by the till.
+type saleLineInput struct {
+	ProductID uint           `json:"product_id" binding:"required"`
+	UnitID    uint           `json:"unit_id"` // Optional; defaults to the product's base unit
+	Quantity  float64        `json:"quantity" binding:"required,gt=0"`
+	LotID     uint           `json:"lot_id"`   // Optional; defaults to the first-expiring lot still in date
+	Discount  *discountInput `json:"discount"` // Optional; given by hand on top of any promotion
+}
+
+// createSaleOrder records an order and one Sale per line inside tx,
+// checking and decrementing stock at the order's location for every line,
+// then records how it was paid. Running promotions are applied to each line
+// before any discount given by hand. The caller owns the transaction and
+// must roll back on error.
+func createSaleOrder(tx *gorm.DB, order *models.SaleOrder, lines []saleLineInput, discount *discountInput, tenders []tenderInput) error {
+	if order.SoldAt.IsZero() {
+		order.SoldAt = time.Now()
+	}
+	if order.PaymentMethod == "" {
+		order.PaymentMethod = models.PaymentCash
+	}
+
+	var customer models.Customer
+	if order.CustomerID != 0 {
+		var err error
+		if customer, err = lockCustomer(tx, order.BusinessID, order.CustomerID); err != nil {
+			return err
+		}
+	}
+
+	// A price list chosen at the till wins over the customer's own
+	if order.PriceListID == 0 {
+		order.PriceListID = customer.PriceListID
+	} else if _, err := findPriceList(tx, order.BusinessID, order.PriceListID); err != nil {
+		return err
+	}
+
+	location, err := resolveLocation(tx, order.BusinessID, order.LocationID)
+	if err != nil {
+		return err
+	}
+	order.LocationID = location.ID
+
+	if order.ShiftID, err = openShiftID(tx, order.BusinessID, order.UserID); err != nil {
+		return err
+	}
+
+	if err := tx.Create(order).Error; err != nil {
+		return err
+	}
+
+	var business models.Business
+	if err := tx.Select("id, costing_method, prices_include_tax").First(&business, order.BusinessID).Error; err != nil {
+		return err
+	}
+	order.TaxInclusive = business.PricesIncludeTax
+	taxClasses := make(map[uint]models.TaxClass)
+
+	promotions, err := activePromotions(tx, order.BusinessID, order.SoldAt)
+	if err != nil {
+		return err
+	}
+	discounts := discountChecker{tx: tx, businessID: order.BusinessID, userID: order.UserID}
+
+	// Lock rows in a consistent order so concurrent baskets can't deadlock.
+	// Kits issue their components part way through their own line, so
+	// everything the basket touches is locked up front.
+	sorted := make([]saleLineInput, len(lines))
+	copy(sorted, lines)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ProductID < sorted[j].ProductID })
+	productIDs := make([]uint, len(sorted))
+	for i, line := range sorted {
+		productIDs[i] = line.ProductID
+	}
+	var componentIDs []uint
+	if err := tx.Model(&models.KitComponent{}).Where("kit_id IN (?)", productIDs).
+		Pluck("component_id", &componentIDs).Error; err != nil {
+		return err
+	}
+	if err := lockStock(tx, order.BusinessID, order.LocationID, append(productIDs, componentIDs...)); err != nil {
+		return err
+	}
+
+	for _, line := range sorted {
+		var product models.Product
+		if err := tx.Where(
+			"id = ? AND business_id = ?",
+			line.ProductID,
+			order.BusinessID,
+		).First(&product).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %d", errProductNotFound, line.ProductID)
+			}
+			return err
+		}
+		if product.HasVariants {
+			return fmt.Errorf("%w of %s", errNotSellable, product.Name)
+		}
+
+		// Stock moves in base units whatever unit the line was sold in
+		unit, err := resolveUnit(tx, product, line.UnitID, true)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, product.Name)
+		}
+		quantity, err := toBaseQuantity(product, unit, line.Quantity)
+		if err != nil {
+			return err
+		}
+
+		// The order's price list replaces the unit's price where it has one
+		var priceListID uint
+		if price, ok, err := listPrice(tx, order.PriceListID, product, line.UnitID, line.Quantity); err != nil {
+			return err
+		} else if ok {
+			unit.Price, priceListID = price, order.PriceListID
+		}
+
+		// Tax is worked out per line at the rate the product is taxed at now
+		var class models.TaxClass
+		if product.TaxClassID != 0 {
+			var ok bool
+			if class, ok = taxClasses[product.TaxClassID]; !ok {
+				if err := tx.Unscoped().First(&class, product.TaxClassID).Error; err != nil {
+					return err
+				}
+				taxClasses[class.ID] = class
+			}
+		}
+
+		// Promotions don't stack; a hand discount comes off what's left
+		gross := roundMoney(line.Quantity * unit.Price)
+		promotion, lineDiscount := bestPromotion(promotions, product, line.Quantity, unit.Price)
+		var promotionID uint
+		if promotion != nil {
+			promotionID = promotion.ID
+		}
+		promotionDiscount := lineDiscount
+		var manual float64
+		if line.Discount != nil {
+			manual = line.Discount.amount(gross - lineDiscount)
+		}
+		if err := discounts.line(manual, gross-lineDiscount, gross); err != nil {
+			return fmt.Errorf("%w for %s", err, product.Name)
+		}
+		lineDiscount += manual
+		total, tax := lineTax(gross-lineDiscount, class.Rate, order.TaxInclusive)
+
+		sale := models.Sale{
+			BusinessID:        order.BusinessID,
+			SaleOrderID:       order.ID,
+			LocationID:        order.LocationID,
+			ProductID:         product.ID,
+			Quantity:          quantity,
+			UnitID:            line.UnitID,
+			UnitName:          unit.Name,
+			UnitQuantity:      line.Quantity,
+			UnitPrice:         unit.Price,
+			PriceListID:       priceListID,
+			Total:             total,
+			Discount:          roundMoney(lineDiscount),
+			PromotionID:       promotionID,
+			PromotionDiscount: promotionDiscount,
+			TaxClassID:        class.ID,
+			TaxRate:           class.Rate,
+			Tax:               tax,
+			SoldAt:            order.SoldAt,
+		}
+		if err := tx.Create(&sale).Error; err != nil {
+			return err
+		}
+
+		// Update stock atomically; the row check replaces a read-then-write
+		movement := models.StockMovement{
+			BusinessID:    order.BusinessID,
+			ProductID:     product.ID,
+			LocationID:    order.LocationID,
+			Type:          models.MovementSale,
+			Quantity:      -quantity,
+			UserID:        order.UserID,
+			ReferenceType: "sale",
+			ReferenceID:   sale.ID,
+		}
+		var cost float64
+		if product.IsKit {
+			if cost, err = issueKit(tx, product, &movement, business.CostingMethod); err != nil {
+				return err
+			}
+		} else {
+			cost, err = issueStock(tx, &movement, business.CostingMethod, line.LotID, false)
+			if errors.Is(err, errInsufficientStock) {
+				return fmt.Errorf("%w for %s", errInsufficientStock, product.Name)
+			} else if err != nil {
+				return err
+			}
+			product.Quantity = movement.Balance
+		}
+		if err := tx.Model(&sale).UpdateColumn("cost", cost).Error; err != nil {
+			return err
+		}
+
+		sale.Product = product
+		order.Items = append(order.Items, sale)
+		order.ItemCount += quantity
+	}
+
+	if discount != nil {
+		if err := applyOrderDiscount(tx, order, *discount, &discounts); err != nil {
+			return err
+		}
+	}
+	for _, sale := range order.Items {
+		order.Total += sale.Total
+		order.TaxTotal += sale.Tax
+		order.Discount += sale.Discount
+	}
+	order.Total = roundMoney(order.Total)
+	order.TaxTotal = roundMoney(order.TaxTotal)
+	order.Discount = roundMoney(order.Discount)
+
+	if err := recordTenders(tx, order, &customer, tenders); err != nil {
+		return err
+	}
+	if err := queueFiscalInvoice(tx, order); err != nil {
+		return err
+	}
+
+	return tx.Model(order).Updates(map[string]interface{}{
+		"item_count":     order.ItemCount,
+		"total":          order.Total,
+		"discount":       order.Discount,
+		"tax_total":      order.TaxTotal,
+		"tax_inclusive":  order.TaxInclusive,
+		"payment_method": order.PaymentMethod,
+		"payment_status": order.PaymentStatus,
+		"amount_due":     order.AmountDue,
+	}).Error
+}
+
+// respondSaleError maps errors from createSaleOrder to HTTP responses.
+func respondSaleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errProductNotFound), errors.Is(err, errLocationNotFound), errors.Is(err, errUnitNotFound),
+		errors.Is(err, errCustomerNotFound), errors.Is(err, errPriceListNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errInsufficientStock), errors.Is(err, errLotUnavailable), errors.Is(err, errLotExpired), errors.Is(err, errNotSellable),
+		errors.Is(err, errFractionalQuantity), errors.Is(err, errCustomerRequired), errors.Is(err, errCreditLimit),
+		errors.Is(err, errTendersShort), errors.Is(err, errOverpaid), errors.Is(err, payments.ErrInvalidPhone),
+		errors.Is(err, errWholeShillings), errors.Is(err, errDiscountTooLarge):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errDiscountLimit):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, errGatewayUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record sale"})
+	}
+}
+
 func CreateSale(c *gin.Context) {
 	// Get business context
 	businessID, exists := c.Get("business_id")
@@ -18,59 +293,126 @@ func CreateSale(c *gin.Context) {
 	}
 
 	var saleInput struct {
-		ProductID uint `json:"product_id" binding:"required"`
-		Quantity  int  `json:"quantity" binding:"required,gt=0"`
+		saleLineInput
+		LocationID    uint          `json:"location_id"`
+		CustomerID    uint          `json:"customer_id"`
+		PriceListID   uint          `json:"price_list_id"` // Optional; defaults to the customer's
+		PaymentMethod string        `json:"payment_method" binding:"omitempty,oneof=cash mpesa card credit"`
+		Tenders       []tenderInput `json:"tenders" binding:"dive"` // Optional; payment_method pays the exact total when empty
 	}
-
 	if err := c.ShouldBindJSON(&saleInput); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Verify product belongs to business
-	var product models.Product
-	if err := database.DB.Where(
-		"id = ? AND business_id = ?",
-		saleInput.ProductID,
-		businessID,
-	).First(&product).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found in your business"})
+	// A single-product sale is recorded as a one-line order
+	order := models.SaleOrder{
+		BusinessID:    businessID.(uint),
+		UserID:        c.GetUint("user_id"),
+		LocationID:    saleInput.LocationID,
+		CustomerID:    saleInput.CustomerID,
+		PriceListID:   saleInput.PriceListID,
+		PaymentMethod: saleInput.PaymentMethod,
+	}
+
+	tx := database.DB.Begin()
+	if err := createSaleOrder(tx, &order, []saleLineInput{saleInput.saleLineInput}, nil, saleInput.Tenders); err != nil {
+		tx.Rollback()
+		respondSaleError(c, err)
+		return
+	}
+	tx.Commit()
+	promptPendingPayments(c.Request.Context(), &order)
+	fiscalizeOrder(order.ID)
+
+	c.JSON(http.StatusCreated, order.Items[0])
+}
+
+// CreateSaleOrder records a whole basket as one order in a single transaction
+func CreateSaleOrder(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
 		return
 	}
 
-	if product.Quantity < saleInput.Quantity {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+	var input struct {
+		LocationID    uint            `json:"location_id"` // Optional; defaults to the main store
+		CustomerID    uint            `json:"customer_id"` // Required when paying on credit
+		PaymentMethod string          `json:"payment_method" binding:"omitempty,oneof=cash mpesa card credit"`
+		PriceListID   uint            `json:"price_list_id"`          // Optional; defaults to the customer's
+		Tenders       []tenderInput   `json:"tenders" binding:"dive"` // Optional; payment_method pays the exact total when empty
+		Items         []saleLineInput `json:"items" binding:"required,min=1,dive"`
+		Discount      *discountInput  `json:"discount"` // Optional; off the whole basket after line discounts
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Create sale with business association
-	sale := models.Sale{
-		BusinessID: businessID.(uint),
-		ProductID:  product.ID,
-		Quantity:   saleInput.Quantity,
-		Total:      float64(saleInput.Quantity) * product.Price,
-		SoldAt:     time.Now(),
+	order := models.SaleOrder{
+		BusinessID:    businessID.(uint),
+		UserID:        c.GetUint("user_id"),
+		LocationID:    input.LocationID,
+		CustomerID:    input.CustomerID,
+		PriceListID:   input.PriceListID,
+		PaymentMethod: input.PaymentMethod,
 	}
 
 	tx := database.DB.Begin()
-
-	if err := tx.Create(&sale).Error; err != nil {
+	if err := createSaleOrder(tx, &order, input.Items, input.Discount, input.Tenders); err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record sale"})
+		respondSaleError(c, err)
 		return
 	}
+	tx.Commit()
+	promptPendingPayments(c.Request.Context(), &order)
+	fiscalizeOrder(order.ID)
 
-	// Update stock
-	product.Quantity -= saleInput.Quantity
-	if err := tx.Save(&product).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+	c.JSON(http.StatusCreated, order)
+}
+
+func GetSaleOrders(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	tx.Commit()
+	query := database.DB.Preload("Items").Preload("Items.Product").Preload("Customer").Preload("Payments").
+		Where("business_id = ? AND archived_at IS NULL", businessID)
+	if customerID := c.Query("customer_id"); customerID != "" {
+		query = query.Where("customer_id = ?", customerID)
+	}
+	if status := c.Query("payment_status"); status != "" {
+		query = query.Where("payment_status = ?", status)
+	}
 
-	c.JSON(http.StatusCreated, sale)
+	var orders []models.SaleOrder
+	if err := query.Order("sold_at DESC").Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sale orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+func GetSaleOrder(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var order models.SaleOrder
+	if err := database.DB.Preload("Items").Preload("Items.Product").Preload("Customer").Preload("Payments").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sale order not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
 }
 
 func GetSales(c *gin.Context) {
@@ -82,7 +424,7 @@ func GetSales(c *gin.Context) {
 
 	var sales []models.Sale
 	if err := database.DB.Preload("Product").
-		Where("business_id = ?", businessID).
+		Where("business_id = ? AND archived_at IS NULL", businessID).
 		Find(&sales).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sales"})
 		return
@@ -99,8 +441,9 @@ func GetProductsForSales(c *gin.Context) {
 	}
 
 	var products []models.Product
-	if err := database.DB.Select("id, name, price, quantity").
-		Where("business_id = ?", businessID).
+	if err := database.DB.Select("id, name, price, quantity, base_unit, allow_decimal").
+		Preload("Units", "for_sale = ?", true).
+		Where("business_id = ? AND has_variants IS NOT TRUE", businessID).
 		Find(&products).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
@@ -118,7 +461,7 @@ func GetLastFiveSales(c *gin.Context) {
 
 	var sales []models.Sale
 	if err := database.DB.Preload("Product").
-		Where("business_id = ?", businessID).
+		Where("business_id = ? AND archived_at IS NULL", businessID).
 		Order("sold_at DESC").
 		Limit(5).
 		Find(&sales).Error; err != nil {
@@ -128,19 +471,3 @@ func GetLastFiveSales(c *gin.Context) {
 
 	c.JSON(http.StatusOK, sales)
 }
-
-func DeleteSaleRecords(c *gin.Context) {
-	businessID, exists := c.Get("business_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	if err := database.DB.Where("business_id = ?", businessID).
-		Delete(&models.Sale{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear sales history"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Sales records cleared successfully"})
-}
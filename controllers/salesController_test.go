@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// Concurrent tills selling the last units must never oversell: exactly as
+// many sales go through as there were units, and the rest are refused.
+func TestCreateSaleOrderConcurrentLastUnits(t *testing.T) {
+	testDB(t)
+	business, user := testBusiness(t)
+	const stocked, tills = 3, 10
+	product := testProduct(t, business, user, 50, stocked)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, tills)
+	for i := 0; i < tills; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := database.DB.Begin()
+			order := models.SaleOrder{BusinessID: business.ID, UserID: user.ID}
+			err := createSaleOrder(tx, &order, []saleLineInput{{ProductID: product.ID, Quantity: 1}}, nil, nil)
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit().Error
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var sold int
+	for err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case !errors.Is(err, errInsufficientStock):
+			t.Errorf("sale failed with %v, want insufficient stock", err)
+		}
+	}
+	if sold != stocked {
+		t.Errorf("%d sales went through, want %d", sold, stocked)
+	}
+
+	if err := database.DB.First(&product, product.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if product.Quantity != 0 {
+		t.Errorf("products.quantity = %v, want 0", product.Quantity)
+	}
+	var stocks []models.ProductStock
+	if err := database.DB.Where("product_id = ?", product.ID).Find(&stocks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stocks) != 1 || stocks[0].Quantity != 0 {
+		t.Errorf("product_stocks = %+v, want one row at 0", stocks)
+	}
+}
+
+// Expired lots stay on the shelf to be written off: the till sells around
+// them, refuses them by name and won't dip into them when fresh stock runs
+// out
+func TestCreateSaleOrderSkipsExpiredLots(t *testing.T) {
+	testDB(t)
+	business, user := testBusiness(t)
+	product := testProduct(t, business, user, 50, 1)
+	yesterday := time.Now().AddDate(0, 0, -1)
+	expired := models.Stock{BusinessID: business.ID, ProductID: product.ID, Quantity: 2, UnitCost: 25, ExpiresAt: &yesterday}
+	if err := receiveStock(database.DB, &expired, &models.StockMovement{BusinessID: business.ID, ProductID: product.ID, UserID: user.ID}); err != nil {
+		t.Fatalf("receiving stock: %v", err)
+	}
+
+	sell := func(line saleLineInput) error {
+		tx := database.DB.Begin()
+		defer tx.Rollback()
+		order := models.SaleOrder{BusinessID: business.ID, UserID: user.ID}
+		if err := createSaleOrder(tx, &order, []saleLineInput{line}, nil, nil); err != nil {
+			return err
+		}
+		return tx.Commit().Error
+	}
+	if err := sell(saleLineInput{ProductID: product.ID, Quantity: 1, LotID: expired.ID}); !errors.Is(err, errLotExpired) {
+		t.Errorf("selling the expired lot: %v, want errLotExpired", err)
+	}
+	if err := sell(saleLineInput{ProductID: product.ID, Quantity: 1}); err != nil {
+		t.Fatalf("selling the fresh unit: %v", err)
+	}
+	if err := sell(saleLineInput{ProductID: product.ID, Quantity: 1}); !errors.Is(err, errInsufficientStock) {
+		t.Errorf("selling with only expired stock left: %v, want insufficient stock", err)
+	}
+
+	if err := database.DB.First(&expired, expired.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expired.Remaining != 2 {
+		t.Errorf("expired lot has %v left, want 2", expired.Remaining)
+	}
+}
+
+// Kits take their components part way through their own line. Baskets that
+// reach the same products through kits in opposite orders must queue for
+// each other rather than deadlock.
+func TestCreateSaleOrderKitsDontDeadlock(t *testing.T) {
+	testDB(t)
+	business, user := testBusiness(t)
+	const rounds = 20
+	newKit := func() models.Product {
+		kit := models.Product{BusinessID: business.ID, Name: "Kit", Price: 80, IsKit: true}
+		if err := database.DB.Create(&kit).Error; err != nil {
+			t.Fatalf("creating kit: %v", err)
+		}
+		return kit
+	}
+	addComponent := func(kit, component models.Product) {
+		if err := database.DB.Create(&models.KitComponent{KitID: kit.ID, ComponentID: component.ID, Quantity: 1}).Error; err != nil {
+			t.Fatalf("creating kit component: %v", err)
+		}
+	}
+	// IDs run first < firstKit < second < secondKit; each basket sells one
+	// product itself and then reaches back or forward for the other
+	first := testProduct(t, business, user, 50, 2*rounds)
+	firstKit := newKit()
+	second := testProduct(t, business, user, 50, 2*rounds)
+	secondKit := newKit()
+	addComponent(firstKit, second)
+	addComponent(secondKit, first)
+	baskets := [][]saleLineInput{
+		{{ProductID: first.ID, Quantity: 1}, {ProductID: firstKit.ID, Quantity: 1}},
+		{{ProductID: second.ID, Quantity: 1}, {ProductID: secondKit.ID, Quantity: 1}},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, rounds*len(baskets))
+	for i := 0; i < rounds; i++ {
+		for _, basket := range baskets {
+			wg.Add(1)
+			go func(basket []saleLineInput) {
+				defer wg.Done()
+				tx := database.DB.Begin()
+				order := models.SaleOrder{BusinessID: business.ID, UserID: user.ID}
+				err := createSaleOrder(tx, &order, basket, nil, nil)
+				if err != nil {
+					tx.Rollback()
+				} else {
+					err = tx.Commit().Error
+				}
+				errs <- err
+			}(basket)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("sale failed: %v", err)
+		}
+	}
+
+	for _, product := range []models.Product{first, second} {
+		if err := database.DB.First(&product, product.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if product.Quantity != 0 {
+			t.Errorf("product %d has %v left, want 0", product.ID, product.Quantity)
+		}
+	}
+}
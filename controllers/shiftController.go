@@ -0,0 +1,479 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// openShiftID returns the ID of the user's open shift, or 0 when they
+// don't have one; selling without a shift is allowed.
+func openShiftID(tx *gorm.DB, businessID, userID uint) (uint, error) {
+	var shift models.Shift
+	err := tx.Select("id").
+		Where("business_id = ? AND user_id = ? AND status = ?", businessID, userID, models.ShiftOpen).
+		First(&shift).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return shift.ID, err
+}
+
+// tenderTotal is the count and amount taken by one payment method
+type tenderTotal struct {
+	Method string
+	Count  int
+	Amount float64
+}
+
+// shiftSummary is what passed through a till during a shift
+type shiftSummary struct {
+	Sales           int
+	SalesTotal      float64
+	Voids           int
+	VoidsTotal      float64
+	Tenders         []tenderTotal // Sale tenders net of change
+	AccountPayments []tenderTotal // Payments against customer accounts
+	Refunds         []tenderTotal
+	CashIn          float64
+	CashOut         float64
+	ExpectedCash    float64
+}
+
+// cashAmount returns the cash line of totals
+func cashAmount(totals []tenderTotal) float64 {
+	for _, total := range totals {
+		if total.Method == models.PaymentCash {
+			return total.Amount
+		}
+	}
+	return 0
+}
+
+// tenderTotals runs a query selecting method, count and amount
+func tenderTotals(db *gorm.DB, query string, args ...interface{}) ([]tenderTotal, error) {
+	rows, err := db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []tenderTotal
+	for rows.Next() {
+		var total tenderTotal
+		if err := rows.Scan(&total.Method, &total.Count, &total.Amount); err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+// summarizeShift totals a shift's sales, payments and cash movements and
+// works out the cash the drawer should hold
+func summarizeShift(db *gorm.DB, shift models.Shift) (shiftSummary, error) {
+	var summary shiftSummary
+
+	if err := db.Raw(
+		`SELECT COUNT(*), COALESCE(SUM(total), 0) FROM sale_orders
+		WHERE shift_id = ? AND voided_at IS NULL AND deleted_at IS NULL`, shift.ID,
+	).Row().Scan(&summary.Sales, &summary.SalesTotal); err != nil {
+		return summary, err
+	}
+	if err := db.Raw(
+		`SELECT COUNT(*), COALESCE(SUM(total), 0) FROM sale_orders
+		WHERE shift_id = ? AND voided_at IS NOT NULL AND deleted_at IS NULL`, shift.ID,
+	).Row().Scan(&summary.Voids, &summary.VoidsTotal); err != nil {
+		return summary, err
+	}
+
+	var err error
+	if summary.Tenders, err = tenderTotals(db,
+		`SELECT p.method, COUNT(*), COALESCE(SUM(p.amount - COALESCE(p.change, 0)), 0)
+		FROM sale_payments p
+		JOIN sale_orders o ON o.id = p.sale_order_id
+		WHERE o.shift_id = ? AND o.voided_at IS NULL AND p.status = ? AND p.deleted_at IS NULL
+		GROUP BY p.method ORDER BY p.method`,
+		shift.ID, models.SalePaymentCompleted,
+	); err != nil {
+		return summary, err
+	}
+	if summary.AccountPayments, err = tenderTotals(db,
+		`SELECT method, COUNT(*), COALESCE(SUM(amount), 0) FROM customer_payments
+		WHERE shift_id = ? AND deleted_at IS NULL
+		GROUP BY method ORDER BY method`,
+		shift.ID,
+	); err != nil {
+		return summary, err
+	}
+	if summary.Refunds, err = tenderTotals(db,
+		`SELECT refund_method, COUNT(*), COALESCE(SUM(refund_total), 0) FROM sale_returns
+		WHERE shift_id = ? AND deleted_at IS NULL
+		GROUP BY refund_method ORDER BY refund_method`,
+		shift.ID,
+	); err != nil {
+		return summary, err
+	}
+
+	if err := db.Raw(
+		`SELECT COALESCE(SUM(CASE WHEN type = ? THEN amount ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN type = ? THEN amount ELSE 0 END), 0)
+		FROM shift_cash_movements WHERE shift_id = ? AND deleted_at IS NULL`,
+		models.CashIn, models.CashOut, shift.ID,
+	).Row().Scan(&summary.CashIn, &summary.CashOut); err != nil {
+		return summary, err
+	}
+
+	summary.ExpectedCash = roundMoney(shift.OpeningFloat +
+		cashAmount(summary.Tenders) + cashAmount(summary.AccountPayments) - cashAmount(summary.Refunds) +
+		summary.CashIn - summary.CashOut)
+	return summary, nil
+}
+
+// findShift loads one of the business's shifts. Cashiers can only see
+// their own; managers and admins see everyone's.
+func findShift(db *gorm.DB, c *gin.Context, businessID interface{}) (models.Shift, error) {
+	query := db.Where("business_id = ? AND id = ?", businessID, c.Param("id"))
+	if role := c.GetString("role"); role != models.RoleAdmin && role != models.RoleManager {
+		query = query.Where("user_id = ?", c.GetUint("user_id"))
+	}
+	var shift models.Shift
+	err := query.First(&shift).Error
+	return shift, err
+}
+
+// OpenShift - Starts a shift for the logged-in user with the float counted
+// into their drawer
+func OpenShift(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		OpeningFloat float64 `json:"opening_float" binding:"gte=0"`
+		LocationID   uint    `json:"location_id"` // Optional; defaults to the main store
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	location, err := resolveLocation(tx, businessID.(uint), input.LocationID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	if id, err := openShiftID(tx, businessID.(uint), userID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for an open shift"})
+		return
+	} else if id != 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have an open shift"})
+		return
+	}
+
+	shift := models.Shift{
+		BusinessID:   businessID.(uint),
+		UserID:       userID,
+		LocationID:   location.ID,
+		Status:       models.ShiftOpen,
+		OpeningFloat: roundMoney(input.OpeningFloat),
+		OpenedAt:     time.Now(),
+	}
+	// The partial unique index catches a second shift opened concurrently
+	if err := tx.Create(&shift).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have an open shift"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, shift)
+}
+
+// GetCurrentShift - Returns the logged-in user's open shift with its
+// running totals
+func GetCurrentShift(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var shift models.Shift
+	if err := database.DB.Preload("CashMovements").
+		Where("business_id = ? AND user_id = ? AND status = ?", businessID, c.GetUint("user_id"), models.ShiftOpen).
+		First(&shift).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open shift"})
+		return
+	}
+
+	summary, err := summarizeShift(database.DB, shift)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to total shift"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shift": shift, "summary": summary})
+}
+
+// GetShifts - Lists shifts, newest first. Cashiers see their own;
+// managers and admins can filter by ?user_id and ?status.
+func GetShifts(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := database.DB.Where("business_id = ?", businessID)
+	if role := c.GetString("role"); role != models.RoleAdmin && role != models.RoleManager {
+		query = query.Where("user_id = ?", c.GetUint("user_id"))
+	} else if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var shifts []models.Shift
+	if err := query.Order("opened_at DESC").Find(&shifts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shifts"})
+		return
+	}
+	c.JSON(http.StatusOK, shifts)
+}
+
+// GetShift - Returns a shift with its cash movements and totals
+func GetShift(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	shift, err := findShift(database.DB.Preload("CashMovements"), c, businessID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+		return
+	}
+	summary, err := summarizeShift(database.DB, shift)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to total shift"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shift": shift, "summary": summary})
+}
+
+// AddShiftCash - Records cash put into or taken out of an open shift's
+// drawer outside a sale
+func AddShiftCash(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Type   string  `json:"type" binding:"required,oneof=in out"`
+		Amount float64 `json:"amount" binding:"required,gt=0"`
+		Reason string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shift, err := findShift(database.DB, c, businessID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+		return
+	}
+	if shift.Status != models.ShiftOpen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shift is closed"})
+		return
+	}
+
+	movement := models.ShiftCashMovement{
+		ShiftID: shift.ID,
+		UserID:  c.GetUint("user_id"),
+		Type:    input.Type,
+		Amount:  roundMoney(input.Amount),
+		Reason:  strings.TrimSpace(input.Reason),
+	}
+	if err := database.DB.Create(&movement).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record cash movement"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, movement)
+}
+
+// CloseShift - Closes a shift against the cash counted in the drawer and
+// returns its Z report
+func CloseShift(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		CountedCash *float64 `json:"counted_cash" binding:"required,gte=0"`
+		Note        string   `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	shift, err := findShift(tx.Set("gorm:query_option", "FOR UPDATE"), c, businessID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+		return
+	}
+	if shift.Status != models.ShiftOpen {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shift is already closed"})
+		return
+	}
+
+	summary, err := summarizeShift(tx, shift)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to total shift"})
+		return
+	}
+
+	now := time.Now()
+	shift.Status = models.ShiftClosed
+	shift.ClosedAt = &now
+	shift.ClosedBy = c.GetUint("user_id")
+	shift.ExpectedCash = summary.ExpectedCash
+	shift.CountedCash = roundMoney(*input.CountedCash)
+	shift.Variance = roundMoney(shift.CountedCash - shift.ExpectedCash)
+	shift.Note = input.Note
+	if err := tx.Model(&shift).Updates(map[string]interface{}{
+		"status":        shift.Status,
+		"closed_at":     shift.ClosedAt,
+		"closed_by":     shift.ClosedBy,
+		"expected_cash": shift.ExpectedCash,
+		"counted_cash":  shift.CountedCash,
+		"variance":      shift.Variance,
+		"note":          shift.Note,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close shift"})
+		return
+	}
+
+	tx.Commit()
+
+	respondShiftReport(c, shift, summary)
+}
+
+// ShiftReport - Renders a shift's report: an X report while it is open,
+// its Z report once closed
+func ShiftReport(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	shift, err := findShift(database.DB, c, businessID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+		return
+	}
+	summary, err := summarizeShift(database.DB, shift)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to total shift"})
+		return
+	}
+
+	respondShiftReport(c, shift, summary)
+}
+
+// respondShiftReport sends a shift's X or Z report as a PDF, laid out by
+// the same code as the other reports
+func respondShiftReport(c *gin.Context, shift models.Shift, summary shiftSummary) {
+	var cashier models.User
+	database.DB.Unscoped().Select("id, first_name, last_name").First(&cashier, shift.UserID)
+
+	kind, end := "X", time.Now()
+	if shift.ClosedAt != nil {
+		kind, end = "Z", *shift.ClosedAt
+	}
+	title := fmt.Sprintf("%s Report - Shift #%d - %s", kind, shift.ID,
+		strings.TrimSpace(cashier.FirstName+" "+cashier.LastName))
+
+	pdf := generatePDF(shiftReportRows(shift, summary), title, "shift", shift.OpenedAt, end)
+
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_report_shift_%d.pdf", strings.ToLower(kind), shift.ID))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// shiftReportRows lays a shift summary out as report rows, ending with the
+// cash reconciliation
+func shiftReportRows(shift models.Shift, summary shiftSummary) []ReportRow {
+	label := func(method string) string {
+		if l, ok := paymentMethodLabels[method]; ok {
+			return l
+		}
+		return method
+	}
+
+	rows := []ReportRow{
+		{Product: "Sales", Quantity: float64(summary.Sales), TotalValue: summary.SalesTotal},
+		{Product: "Voided sales", Quantity: float64(summary.Voids), TotalValue: summary.VoidsTotal},
+	}
+	for _, tender := range summary.Tenders {
+		rows = append(rows, ReportRow{Product: "  " + label(tender.Method), Quantity: float64(tender.Count), TotalValue: tender.Amount})
+	}
+	for _, payment := range summary.AccountPayments {
+		rows = append(rows, ReportRow{Product: "Account payments - " + label(payment.Method), Quantity: float64(payment.Count), TotalValue: payment.Amount})
+	}
+	for _, refund := range summary.Refunds {
+		rows = append(rows, ReportRow{Product: "Refunds - " + label(refund.Method), Quantity: float64(refund.Count), TotalValue: -refund.Amount})
+	}
+
+	expected := summary.ExpectedCash
+	if shift.Status == models.ShiftClosed {
+		expected = shift.ExpectedCash // As worked out at close
+	}
+	rows = append(rows,
+		ReportRow{Product: "Opening float", TotalValue: shift.OpeningFloat},
+		ReportRow{Product: "Cash in", TotalValue: summary.CashIn},
+		ReportRow{Product: "Cash out", TotalValue: -summary.CashOut},
+		ReportRow{Product: "Expected cash", TotalValue: expected},
+	)
+	if shift.Status == models.ShiftClosed {
+		rows = append(rows,
+			ReportRow{Product: "Counted cash", TotalValue: shift.CountedCash},
+			ReportRow{Product: "Variance", TotalValue: shift.Variance},
+		)
+	}
+	return rows
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// agingBuckets label the age groups unpaid credit sales fall into
+var agingBuckets = [4]string{"Current", "31-60 days", "61-90 days", "90+ days"}
+
+// receivable is what one customer owes, split by how old the sales are
+type receivable struct {
+	CustomerID uint
+	Name       string
+	Phone      string
+	Aged       [4]float64
+	Total      float64
+}
+
+// agingBucket returns which of agingBuckets a sale made at soldAt falls in
+func agingBucket(soldAt, asOf time.Time) int {
+	days := int(asOf.Sub(soldAt).Hours() / 24)
+	switch {
+	case days <= 30:
+		return 0
+	case days <= 60:
+		return 1
+	case days <= 90:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// agedReceivables totals unpaid credit sales per customer, aged to asOf.
+// customerID 0 includes every customer of the business.
+func agedReceivables(businessID, customerID uint, asOf time.Time) ([]receivable, error) {
+	query := database.DB.Preload("Customer").
+		Where("business_id = ? AND amount_due > 0 AND voided_at IS NULL", businessID)
+	if customerID != 0 {
+		query = query.Where("customer_id = ?", customerID)
+	}
+	var orders []models.SaleOrder
+	if err := query.Order("sold_at").Find(&orders).Error; err != nil {
+		return nil, err
+	}
+
+	byCustomer := make(map[uint]*receivable)
+	var receivables []*receivable
+	for _, order := range orders {
+		r, ok := byCustomer[order.CustomerID]
+		if !ok {
+			r = &receivable{CustomerID: order.CustomerID}
+			if order.Customer != nil {
+				r.Name, r.Phone = order.Customer.Name, order.Customer.Phone
+			}
+			byCustomer[order.CustomerID] = r
+			receivables = append(receivables, r)
+		}
+		r.Aged[agingBucket(order.SoldAt, asOf)] += order.AmountDue
+		r.Total += order.AmountDue
+	}
+
+	sort.Slice(receivables, func(i, j int) bool { return receivables[i].Name < receivables[j].Name })
+	result := make([]receivable, len(receivables))
+	for i, r := range receivables {
+		result[i] = *r
+	}
+	return result, nil
+}
+
+// statementEntry is one line on a customer statement
+type statementEntry struct {
+	Date        time.Time
+	Description string
+	Debit       float64 // Adds to what the customer owes
+	Credit      float64 // Takes off what the customer owes
+}
+
+// statementEntries lists everything that changed a customer's balance up
+// to end, oldest first: sales put on account, returns refunded to account,
+// and payments
+func statementEntries(customer models.Customer, end time.Time) ([]statementEntry, error) {
+	var entries []statementEntry
+
+	var charges []models.SalePayment
+	if err := database.DB.
+		Joins("JOIN sale_orders o ON o.id = sale_payments.sale_order_id").
+		Where("o.customer_id = ? AND sale_payments.method = ? AND o.voided_at IS NULL AND sale_payments.paid_at <= ?",
+			customer.ID, models.PaymentCredit, end).
+		Find(&charges).Error; err != nil {
+		return nil, err
+	}
+	for _, charge := range charges {
+		entries = append(entries, statementEntry{
+			Date:        charge.PaidAt,
+			Description: fmt.Sprintf("Invoice #%06d", charge.SaleOrderID),
+			Debit:       charge.Amount,
+		})
+	}
+
+	var returns []models.SaleReturn
+	if err := database.DB.
+		Joins("JOIN sale_orders o ON o.id = sale_returns.sale_order_id").
+		Where("o.customer_id = ? AND sale_returns.refund_method = ? AND sale_returns.returned_at <= ?",
+			customer.ID, models.PaymentCredit, end).
+		Find(&returns).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range returns {
+		entries = append(entries, statementEntry{
+			Date:        r.ReturnedAt,
+			Description: fmt.Sprintf("Return on #%06d", r.SaleOrderID),
+			Credit:      r.RefundTotal,
+		})
+	}
+
+	var payments []models.CustomerPayment
+	if err := database.DB.Where("customer_id = ? AND paid_at <= ?", customer.ID, end).
+		Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	for _, payment := range payments {
+		description := "Payment - " + paymentMethodLabels[payment.Method]
+		if payment.Reference != "" {
+			description += " " + payment.Reference
+		}
+		entries = append(entries, statementEntry{
+			Date:        payment.PaidAt,
+			Description: description,
+			Credit:      payment.Amount,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date.Before(entries[j].Date) })
+	return entries, nil
+}
+
+// CustomerStatement - Renders a customer's statement of account as a PDF:
+// the opening balance, each sale, return and payment in the period with a
+// running balance, and what is owed by age. ?start_date and ?end_date
+// (RFC3339) set the period; by default it covers the whole account.
+func CustomerStatement(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var customer models.Customer
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&customer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	startDate, endDate := time.Time{}, time.Now()
+	if raw := c.Query("start_date"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format"})
+			return
+		}
+		startDate = parsed
+	}
+	if raw := c.Query("end_date"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format"})
+			return
+		}
+		endDate = parsed
+	}
+
+	entries, err := statementEntries(customer, endDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch account history"})
+		return
+	}
+	aged, err := agedReceivables(customer.BusinessID, customer.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to age balance"})
+		return
+	}
+
+	var business models.Business
+	if err := database.DB.Select("id, business_name").First(&business, customer.BusinessID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load business"})
+		return
+	}
+
+	pdf := generateStatementPDF(business.BusinessName, customer, entries, aged, startDate, endDate)
+
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statement_%d.pdf", customer.ID))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+func generateStatementPDF(businessName string, customer models.Customer, entries []statementEntry,
+	aged []receivable, startDate, endDate time.Time) []byte {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Arial", "B", 16)
+	pdf.CellFormat(0, 10, businessName, "", 1, "C", false, 0, "")
+	pdf.SetFont("Arial", "B", 14)
+	pdf.CellFormat(0, 10, "Statement of Account", "", 1, "C", false, 0, "")
+	pdf.Ln(5)
+
+	pdf.SetFont("Arial", "", 11)
+	pdf.CellFormat(0, 6, "Customer: "+customer.Name, "", 1, "L", false, 0, "")
+	if customer.Phone != "" {
+		pdf.CellFormat(0, 6, "Phone: "+customer.Phone, "", 1, "L", false, 0, "")
+	}
+	if customer.Address != "" {
+		pdf.CellFormat(0, 6, "Address: "+customer.Address, "", 1, "L", false, 0, "")
+	}
+	period := "Up to " + endDate.Format("2006-01-02")
+	if !startDate.IsZero() {
+		period = fmt.Sprintf("%s to %s", startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+	}
+	pdf.CellFormat(0, 6, "Period: "+period, "", 1, "L", false, 0, "")
+	pdf.Ln(5)
+
+	columns := []reportColumn{
+		{"Date", 30, "C", nil},
+		{"Description", 70, "L", nil},
+		{"Debit", 30, "R", nil},
+		{"Credit", 30, "R", nil},
+		{"Balance", 30, "R", nil},
+	}
+	pdf.SetFont("Arial", "B", 12)
+	for i, col := range columns {
+		pdf.CellFormat(col.Width, 10, col.Header, "1", lineBreak(i, len(columns)), "C", false, 0, "")
+	}
+	row := func(values ...string) {
+		for i, col := range columns {
+			pdf.CellFormat(col.Width, 10, values[i], "1", lineBreak(i, len(columns)), col.Align, false, 0, "")
+		}
+	}
+	amount := func(value float64) string {
+		if value == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.2f", value)
+	}
+
+	// Entries before the period roll up into the opening balance
+	var balance float64
+	i := 0
+	for ; i < len(entries) && entries[i].Date.Before(startDate); i++ {
+		balance += entries[i].Debit - entries[i].Credit
+	}
+	pdf.SetFont("Arial", "", 12)
+	row("", "Opening balance", "", "", fmt.Sprintf("%.2f", balance))
+	for _, entry := range entries[i:] {
+		balance += entry.Debit - entry.Credit
+		row(entry.Date.Format("2006-01-02"), entry.Description, amount(entry.Debit), amount(entry.Credit), fmt.Sprintf("%.2f", balance))
+	}
+	pdf.SetFont("Arial", "B", 12)
+	row("", "Closing balance", "", "", fmt.Sprintf("%.2f", balance))
+	pdf.Ln(8)
+
+	// What is owed today, by age
+	var buckets [4]float64
+	var due float64
+	for _, r := range aged {
+		for b := range buckets {
+			buckets[b] += r.Aged[b]
+		}
+		due += r.Total
+	}
+	pdf.SetFont("Arial", "B", 11)
+	for _, label := range agingBuckets {
+		pdf.CellFormat(38, 8, label, "1", 0, "C", false, 0, "")
+	}
+	pdf.CellFormat(38, 8, "Total Due", "1", 1, "C", false, 0, "")
+	pdf.SetFont("Arial", "", 11)
+	for _, value := range buckets {
+		pdf.CellFormat(38, 8, fmt.Sprintf("%.2f", value), "1", 0, "R", false, 0, "")
+	}
+	pdf.CellFormat(38, 8, fmt.Sprintf("ksh %.2f", due), "1", 1, "R", false, 0, "")
+
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
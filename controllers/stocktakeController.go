@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// StartStocktake - Opens a count session for a category (or all products),
+// snapshotting each product's expected quantity.
+func StartStocktake(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input struct {
+		CategoryID uint   `json:"category_id"` // Omit to count every product
+		LocationID uint   `json:"location_id"` // Omit to count the main store
+		Note       string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	location, err := resolveLocation(tx, businessID.(uint), input.LocationID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	query := tx.Where("business_id = ? AND has_variants IS NOT TRUE", businessID)
+	if input.CategoryID != 0 {
+		query = query.Where("category_id = ?", input.CategoryID)
+	}
+
+	var products []models.Product
+	if err := query.Find(&products).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	if len(products) == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No products to count"})
+		return
+	}
+
+	// Expected quantities are what this location holds
+	var levels []models.ProductStock
+	if err := tx.Where("location_id = ?", location.ID).Find(&levels).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock levels"})
+		return
+	}
+	expected := make(map[uint]float64, len(levels))
+	for _, level := range levels {
+		expected[level.ProductID] = level.Quantity
+	}
+
+	stocktake := models.Stocktake{
+		BusinessID: businessID.(uint),
+		CategoryID: input.CategoryID,
+		LocationID: location.ID,
+		Status:     models.StocktakeOpen,
+		Note:       input.Note,
+		StartedBy:  c.GetUint("user_id"),
+	}
+	if err := tx.Create(&stocktake).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start stocktake"})
+		return
+	}
+
+	for _, product := range products {
+		line := models.StocktakeLine{
+			StocktakeID: stocktake.ID,
+			ProductID:   product.ID,
+			Expected:    expected[product.ID],
+			Price:       product.Price,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to snapshot stock"})
+			return
+		}
+		stocktake.Lines = append(stocktake.Lines, line)
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, stocktake)
+}
+
+func GetStocktakes(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var stocktakes []models.Stocktake
+	if err := database.DB.
+		Where("business_id = ?", businessID).
+		Order("created_at DESC").
+		Find(&stocktakes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocktakes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stocktakes)
+}
+
+func GetStocktake(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var stocktake models.Stocktake
+	if err := database.DB.Preload("Lines").Preload("Lines.Product").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&stocktake).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stocktake not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stocktake)
+}
+
+// RecordStocktakeCounts - Saves counted quantities. Each product is its own
+// row, so several employees can count different shelves at the same time.
+func RecordStocktakeCounts(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Counts []struct {
+			ProductID uint     `json:"product_id" binding:"required"`
+			Counted   *float64 `json:"counted" binding:"required,min=0"`
+		} `json:"counts" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// A shared lock lets counters work in parallel but blocks posting mid-count
+	var stocktake models.Stocktake
+	if err := tx.Set("gorm:query_option", "FOR SHARE").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&stocktake).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stocktake not found"})
+		return
+	}
+	if stocktake.Status != models.StocktakeOpen {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Stocktake is " + stocktake.Status})
+		return
+	}
+
+	// Counts become adjustments when posted, so they follow the same rules
+	productIDs := make([]uint, len(input.Counts))
+	for i, count := range input.Counts {
+		productIDs[i] = count.ProductID
+	}
+	var products []models.Product
+	if err := tx.Select("id, base_unit, allow_decimal").
+		Where("business_id = ? AND id IN (?)", businessID, productIDs).
+		Find(&products).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record counts"})
+		return
+	}
+	wholeOnly := make(map[uint]string)
+	for _, product := range products {
+		if !product.AllowDecimal {
+			wholeOnly[product.ID] = product.BaseUnit
+		}
+	}
+
+	now := time.Now()
+	userID := c.GetUint("user_id")
+	for _, count := range input.Counts {
+		if unit, ok := wholeOnly[count.ProductID]; ok && !isWhole(*count.Counted) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Count for product %d must be a whole number of %s", count.ProductID, unit)})
+			return
+		}
+		result := tx.Model(&models.StocktakeLine{}).
+			Where("stocktake_id = ? AND product_id = ?", stocktake.ID, count.ProductID).
+			Updates(map[string]interface{}{
+				"counted":    *count.Counted,
+				"counted_by": userID,
+				"counted_at": now,
+			})
+		if result.Error != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record counts"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d is not part of this stocktake", count.ProductID)})
+			return
+		}
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Counts recorded", "recorded": len(input.Counts)})
+}
+
+// StocktakeVariance - Compares counted against expected quantities in units and value
+func StocktakeVariance(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var stocktake models.Stocktake
+	if err := database.DB.Preload("Lines").Preload("Lines.Product").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&stocktake).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stocktake not found"})
+		return
+	}
+
+	var lines []gin.H
+	var uncounted []gin.H
+	var unitsVariance, valueVariance float64
+	for _, line := range stocktake.Lines {
+		if line.Counted == nil {
+			uncounted = append(uncounted, gin.H{
+				"product_id": line.ProductID,
+				"product":    line.Product.Name,
+				"expected":   line.Expected,
+			})
+			continue
+		}
+
+		variance := roundQuantity(*line.Counted - line.Expected)
+		value := variance * line.Price
+		unitsVariance += variance
+		valueVariance += value
+		lines = append(lines, gin.H{
+			"product_id":     line.ProductID,
+			"product":        line.Product.Name,
+			"expected":       line.Expected,
+			"counted":        *line.Counted,
+			"variance":       variance,
+			"variance_value": value,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stocktake_id":   stocktake.ID,
+		"status":         stocktake.Status,
+		"lines":          lines,
+		"uncounted":      uncounted,
+		"units_variance": unitsVariance,
+		"value_variance": valueVariance,
+	})
+}
+
+// PostStocktake - Turns every counted variance into an approved count
+// correction adjustment, all in one transaction.
+func PostStocktake(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	var stocktake models.Stocktake
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&stocktake).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stocktake not found"})
+		return
+	}
+	if stocktake.Status != models.StocktakeOpen {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Stocktake is " + stocktake.Status})
+		return
+	}
+
+	var lines []models.StocktakeLine
+	if err := tx.Where("stocktake_id = ? AND counted IS NOT NULL", stocktake.ID).
+		Order("product_id").
+		Find(&lines).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch counts"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	var adjustments []models.StockAdjustment
+	for _, line := range lines {
+		// Movements since the snapshot are kept; only the count difference is booked
+		variance := roundQuantity(*line.Counted - line.Expected)
+		if variance == 0 {
+			continue
+		}
+
+		adjustment := models.StockAdjustment{
+			BusinessID:  stocktake.BusinessID,
+			ProductID:   line.ProductID,
+			LocationID:  stocktake.LocationID,
+			Quantity:    variance,
+			Reason:      models.AdjustmentCountCorrection,
+			Note:        fmt.Sprintf("Stocktake #%d", stocktake.ID),
+			Status:      models.AdjustmentPending,
+			RequestedBy: userID,
+		}
+		if err := tx.Create(&adjustment).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record adjustment"})
+			return
+		}
+		if err := applyAdjustment(tx, &adjustment, userID); errors.Is(err, errInsufficientStock) {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Product %d has sold below its counted quantity; recount it", line.ProductID)})
+			return
+		} else if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply adjustment"})
+			return
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+
+	now := time.Now()
+	stocktake.Status = models.StocktakePosted
+	stocktake.PostedBy = userID
+	stocktake.PostedAt = &now
+	if err := tx.Save(&stocktake).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post stocktake"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Stocktake posted",
+		"stocktake":   stocktake,
+		"adjustments": adjustments,
+	})
+}
+
+// CancelStocktake - Abandons an open stocktake without touching stock
+func CancelStocktake(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := database.DB.Model(&models.Stocktake{}).
+		Where("business_id = ? AND id = ? AND status = ?", businessID, c.Param("id"), models.StocktakeOpen).
+		Update("status", models.StocktakeCancelled)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel stocktake"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open stocktake found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stocktake cancelled"})
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+type supplierInput struct {
+	Name        string `json:"name" binding:"required,min=2"`
+	ContactName string `json:"contact_name"`
+	Phone       string `json:"phone"`
+	Email       string `json:"email" binding:"omitempty,email"`
+	Address     string `json:"address"`
+}
+
+func CreateSupplier(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input supplierInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check for existing supplier in the same business
+	var existing models.Supplier
+	if err := database.DB.Where(
+		"business_id = ? AND LOWER(name) = ?",
+		businessID,
+		strings.ToLower(strings.TrimSpace(input.Name)),
+	).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Supplier name already exists in your business"})
+		return
+	}
+
+	supplier := models.Supplier{
+		BusinessID:  businessID.(uint),
+		Name:        strings.TrimSpace(input.Name),
+		ContactName: input.ContactName,
+		Phone:       input.Phone,
+		Email:       input.Email,
+		Address:     input.Address,
+	}
+	if err := database.DB.Create(&supplier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create supplier"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, supplier)
+}
+
+func GetSuppliers(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var suppliers []models.Supplier
+	if err := database.DB.Where("business_id = ?", businessID).Order("name").Find(&suppliers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, suppliers)
+}
+
+func GetSupplier(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var supplier models.Supplier
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&supplier).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+	c.JSON(http.StatusOK, supplier)
+}
+
+func UpdateSupplier(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var supplier models.Supplier
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&supplier).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+
+	var input supplierInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	supplier.Name = strings.TrimSpace(input.Name)
+	supplier.ContactName = input.ContactName
+	supplier.Phone = input.Phone
+	supplier.Email = input.Email
+	supplier.Address = input.Address
+	if err := database.DB.Save(&supplier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update supplier"})
+		return
+	}
+
+	c.JSON(http.StatusOK, supplier)
+}
+
+// DeleteSupplier - Deletes a supplier if no purchase orders reference it
+func DeleteSupplier(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var supplier models.Supplier
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&supplier).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+
+	var orderCount int64
+	if err := database.DB.Model(&models.PurchaseOrder{}).
+		Where("supplier_id = ?", supplier.ID).
+		Count(&orderCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check purchase orders"})
+		return
+	}
+	if orderCount > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete supplier with purchase orders"})
+		return
+	}
+
+	if err := database.DB.Delete(&supplier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete supplier"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Supplier deleted successfully"})
+}
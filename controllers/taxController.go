@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var errTaxClassNotFound = errors.New("tax class not found in your business")
+
+// defaultTaxClasses are the Kenyan VAT classes a business starts with
+var defaultTaxClasses = []models.TaxClass{
+	{Name: "VAT 16%", Code: models.TaxCodeStandard, Rate: 16},
+	{Name: "VAT 8%", Code: models.TaxCodeReduced, Rate: 8},
+	{Name: "Zero rated", Code: models.TaxCodeZeroRated, Rate: 0},
+	{Name: "Exempt", Code: models.TaxCodeExempt, Rate: 0},
+}
+
+// lineTax splits a line's amount into what the customer pays and the tax
+// in it. With inclusive prices the tax comes out of amount; otherwise it
+// is added on top.
+func lineTax(amount, rate float64, inclusive bool) (total, tax float64) {
+	amount = roundMoney(amount)
+	if inclusive {
+		return amount, roundMoney(amount * rate / (100 + rate))
+	}
+	tax = roundMoney(amount * rate / 100)
+	return roundMoney(amount + tax), tax
+}
+
+// findTaxClass returns one of the business's tax classes
+func findTaxClass(tx *gorm.DB, businessID, id uint) (models.TaxClass, error) {
+	var class models.TaxClass
+	err := tx.Where("business_id = ? AND id = ?", businessID, id).First(&class).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return class, errTaxClassNotFound
+	}
+	return class, err
+}
+
+type taxClassInput struct {
+	Name string  `json:"name" binding:"required"`
+	Code string  `json:"code" binding:"required,oneof=A B C D E"`
+	Rate float64 `json:"rate" binding:"gte=0,lte=100"`
+}
+
+// CreateTaxClass - Adds a tax class products can be assigned to
+func CreateTaxClass(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input taxClassInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	class := models.TaxClass{
+		BusinessID: businessID.(uint),
+		Name:       strings.TrimSpace(input.Name),
+		Code:       input.Code,
+		Rate:       input.Rate,
+	}
+	if err := database.DB.Create(&class).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tax class"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, class)
+}
+
+// GetTaxClasses - Lists the business's tax classes, setting up the Kenyan
+// VAT classes the first time
+func GetTaxClasses(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Classes a business deleted stay deleted, so count those too
+	var count int
+	if err := database.DB.Unscoped().Model(&models.TaxClass{}).Where("business_id = ?", businessID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tax classes"})
+		return
+	}
+	if count == 0 {
+		tx := database.DB.Begin()
+		for _, class := range defaultTaxClasses {
+			class.BusinessID = businessID.(uint)
+			if err := tx.Create(&class).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up tax classes"})
+				return
+			}
+		}
+		tx.Commit()
+	}
+
+	var classes []models.TaxClass
+	if err := database.DB.Where("business_id = ?", businessID).Order("id").Find(&classes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tax classes"})
+		return
+	}
+	c.JSON(http.StatusOK, classes)
+}
+
+// UpdateTaxClass - Changes a tax class. Past sales keep the rate they were
+// charged at.
+func UpdateTaxClass(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var class models.TaxClass
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&class).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax class not found"})
+		return
+	}
+
+	var input taxClassInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Model(&class).Updates(map[string]interface{}{
+		"name": strings.TrimSpace(input.Name),
+		"code": input.Code,
+		"rate": input.Rate,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tax class"})
+		return
+	}
+
+	c.JSON(http.StatusOK, class)
+}
+
+// DeleteTaxClass - Deletes a tax class no product is assigned to
+func DeleteTaxClass(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var class models.TaxClass
+	if err := database.DB.Where("business_id = ? AND id = ?", businessID, c.Param("id")).First(&class).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax class not found"})
+		return
+	}
+
+	var count int
+	if err := database.DB.Model(&models.Product{}).Where("tax_class_id = ?", class.ID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check products"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tax class is still assigned to products"})
+		return
+	}
+
+	if err := database.DB.Delete(&class).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tax class"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tax class deleted successfully"})
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/models"
+	"github.com/ken-eddy/stockApp/payments"
+)
+
+var (
+	errTendersShort       = errors.New("payments don't cover the total")
+	errOverpaid           = errors.New("only cash can be overpaid")
+	errGatewayUnavailable = errors.New("M-Pesa payments are not set up")
+	errWholeShillings     = errors.New("M-Pesa prompts must be for whole shillings")
+)
+
+// tenderInput is one way a customer paid at the till
+type tenderInput struct {
+	Method    string  `json:"method" binding:"required,oneof=cash mpesa card credit"`
+	Amount    float64 `json:"amount" binding:"required,gt=0"`
+	Reference string  `json:"reference"` // e.g. the M-Pesa confirmation code
+	Phone     string  `json:"phone"`     // M-Pesa only: prompt this number to pay instead of giving a reference
+}
+
+// recordTenders checks that tenders pay for the order, works out the change
+// due from cash, and saves one SalePayment per tender. With no tenders the
+// order's PaymentMethod pays the exact total. Credit tenders go on the
+// customer's account, which must already be locked. M-Pesa tenders with a
+// phone number are left pending, as is the order, until the customer
+// approves the prompt. order.PaymentMethod is set to the single method
+// used, or PaymentSplit.
+func recordTenders(tx *gorm.DB, order *models.SaleOrder, customer *models.Customer, tenders []tenderInput) error {
+	total := roundMoney(order.Total)
+	if len(tenders) == 0 {
+		tenders = []tenderInput{{Method: order.PaymentMethod, Amount: total}}
+	}
+
+	order.PaymentMethod = tenders[0].Method
+	var paid, cash, onAccount float64
+	for _, tender := range tenders {
+		paid += tender.Amount
+		switch tender.Method {
+		case models.PaymentCash:
+			cash += tender.Amount
+		case models.PaymentCredit:
+			onAccount += tender.Amount
+		}
+		if tender.Method != tenders[0].Method {
+			order.PaymentMethod = models.PaymentSplit
+		}
+	}
+	paid, onAccount = roundMoney(paid), roundMoney(onAccount)
+	if paid < total {
+		return fmt.Errorf("%w: %.2f paid of %.2f", errTendersShort, paid, total)
+	}
+	change := roundMoney(paid - total)
+	if change > roundMoney(cash) {
+		return fmt.Errorf("%w: %.2f paid of %.2f", errOverpaid, paid, total)
+	}
+
+	// Credit tenders go on the customer's account, up to their limit
+	if onAccount > 0 {
+		if customer.ID == 0 {
+			return errCustomerRequired
+		}
+		if roundMoney(customer.Balance+onAccount) > customer.CreditLimit {
+			return fmt.Errorf("%w: %s may owe at most %.2f and already owes %.2f",
+				errCreditLimit, customer.Name, customer.CreditLimit, customer.Balance)
+		}
+		if err := adjustBalance(tx, customer, onAccount); err != nil {
+			return err
+		}
+		order.AmountDue = onAccount
+	}
+
+	order.PaymentStatus = models.OrderPaid
+	for _, tender := range tenders {
+		payment := models.SalePayment{
+			BusinessID:  order.BusinessID,
+			SaleOrderID: order.ID,
+			Method:      tender.Method,
+			Amount:      tender.Amount,
+			Reference:   tender.Reference,
+			Status:      models.SalePaymentCompleted,
+			PaidAt:      order.SoldAt,
+		}
+		if tender.Method == models.PaymentMpesa && tender.Phone != "" {
+			if paymentGateway == nil {
+				return errGatewayUnavailable
+			}
+			phone, err := payments.NormalizePhone(tender.Phone)
+			if err != nil {
+				return err
+			}
+			if !isWhole(tender.Amount) {
+				return errWholeShillings
+			}
+			payment.Phone = phone
+			payment.Status = models.SalePaymentPending
+			order.PaymentStatus = models.OrderPendingPayment
+		}
+		// Change comes out of the cash tenders in turn
+		if tender.Method == models.PaymentCash && change > 0 {
+			payment.Change = math.Min(change, tender.Amount)
+			change = roundMoney(change - payment.Change)
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		order.Payments = append(order.Payments, payment)
+	}
+	return nil
+}
+
+// onAccountAmount is how much of an order was put on the customer's account
+func onAccountAmount(tx *gorm.DB, orderID uint) (float64, error) {
+	var amount float64
+	err := tx.Raw(
+		"SELECT COALESCE(SUM(amount), 0) FROM sale_payments WHERE sale_order_id = ? AND method = ? AND deleted_at IS NULL",
+		orderID, models.PaymentCredit,
+	).Row().Scan(&amount)
+	return amount, err
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+var (
+	testDBOnce sync.Once
+	testDBErr  error
+)
+
+// testDB points database.DB at the Postgres database in TEST_DATABASE_URL,
+// migrating it on first use. Without one the test is skipped, except in CI
+// where it fails. Tests share the database, so each one works in a
+// business of its own.
+func testDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	testDBOnce.Do(func() {
+		if database.DB, testDBErr = gorm.Open("postgres", url); testDBErr == nil {
+			database.Migrate()
+		}
+	})
+	if testDBErr != nil {
+		t.Fatalf("connecting to the test database: %v", testDBErr)
+	}
+}
+
+// testBusiness creates a business with a cashier to sell as
+func testBusiness(t *testing.T) (models.Business, models.User) {
+	t.Helper()
+	name := fmt.Sprintf("%s %d", t.Name(), time.Now().UnixNano())
+	business := models.Business{BusinessName: name, Password: "x"}
+	if err := database.DB.Create(&business).Error; err != nil {
+		t.Fatalf("creating business: %v", err)
+	}
+	user := models.User{
+		FirstName:  "Test",
+		LastName:   "Cashier",
+		Email:      fmt.Sprintf("%d@example.com", time.Now().UnixNano()),
+		Password:   "x",
+		BusinessID: business.ID,
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return business, user
+}
+
+// testProduct creates a product and receives quantity units of it at the
+// business's default location
+func testProduct(t *testing.T, business models.Business, user models.User, price, quantity float64) models.Product {
+	t.Helper()
+	product := models.Product{BusinessID: business.ID, Name: "Soda", Price: price, CostPrice: price / 2}
+	if err := database.DB.Create(&product).Error; err != nil {
+		t.Fatalf("creating product: %v", err)
+	}
+	stock := models.Stock{BusinessID: business.ID, ProductID: product.ID, Quantity: quantity, UnitCost: price / 2}
+	movement := models.StockMovement{BusinessID: business.ID, ProductID: product.ID, UserID: user.ID}
+	if err := receiveStock(database.DB, &stock, &movement); err != nil {
+		t.Fatalf("receiving stock: %v", err)
+	}
+	return product
+}
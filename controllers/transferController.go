@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// CreateTransfer - Drafts a transfer of stock between two locations
+func CreateTransfer(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - Business context required"})
+		return
+	}
+
+	var input struct {
+		FromLocationID uint   `json:"from_location_id" binding:"required"`
+		ToLocationID   uint   `json:"to_location_id" binding:"required,nefield=FromLocationID"`
+		Note           string `json:"note"`
+		Lines          []struct {
+			ProductID uint    `json:"product_id" binding:"required"`
+			Quantity  float64 `json:"quantity" binding:"required,gt=0"` // In the base unit
+		} `json:"lines" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	for _, id := range []uint{input.FromLocationID, input.ToLocationID} {
+		if _, err := resolveLocation(tx, businessID.(uint), id); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Location %d not found", id)})
+			return
+		}
+	}
+
+	transfer := models.StockTransfer{
+		BusinessID:     businessID.(uint),
+		FromLocationID: input.FromLocationID,
+		ToLocationID:   input.ToLocationID,
+		Status:         models.TransferDraft,
+		Note:           input.Note,
+		CreatedBy:      c.GetUint("user_id"),
+	}
+	if err := tx.Create(&transfer).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transfer"})
+		return
+	}
+
+	for _, in := range input.Lines {
+		var product models.Product
+		if err := tx.Where("business_id = ? AND id = ?", businessID, in.ProductID).First(&product).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Product %d not found in your business", in.ProductID)})
+			return
+		}
+		// Stock is held by the variants, never by their parent
+		if product.HasVariants {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Choose a variant of %s to transfer", product.Name)})
+			return
+		}
+		if !product.AllowDecimal && !isWhole(in.Quantity) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Quantity of %s must be a whole number of %s", product.Name, product.BaseUnit)})
+			return
+		}
+
+		line := models.StockTransferLine{
+			StockTransferID: transfer.ID,
+			ProductID:       product.ID,
+			Quantity:        in.Quantity,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add transfer line"})
+			return
+		}
+		transfer.Lines = append(transfer.Lines, line)
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+func GetTransfers(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := database.DB.Preload("FromLocation").Preload("ToLocation").Where("business_id = ?", businessID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var transfers []models.StockTransfer
+	if err := query.Order("created_at DESC").Find(&transfers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfers)
+}
+
+func GetTransfer(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var transfer models.StockTransfer
+	if err := database.DB.Preload("FromLocation").Preload("ToLocation").Preload("Lines").Preload("Lines.Product").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&transfer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// lockTransfer loads a transfer and its lines FOR UPDATE, writing the error
+// response itself when it can't.
+func lockTransfer(c *gin.Context, tx *gorm.DB) (*models.StockTransfer, bool) {
+	var transfer models.StockTransfer
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", c.GetUint("business_id"), c.Param("id")).
+		First(&transfer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return nil, false
+	}
+	if err := tx.Where("stock_transfer_id = ?", transfer.ID).Order("product_id").Find(&transfer.Lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfer lines"})
+		return nil, false
+	}
+	return &transfer, true
+}
+
+// DispatchTransfer - Takes the goods out of the source location; they stay
+// in transit until received.
+func DispatchTransfer(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	transfer, ok := lockTransfer(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if transfer.Status != models.TransferDraft {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft transfers can be dispatched"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	for _, line := range transfer.Lines {
+		movement := models.StockMovement{
+			BusinessID:    transfer.BusinessID,
+			ProductID:     line.ProductID,
+			LocationID:    transfer.FromLocationID,
+			Type:          models.MovementTransferOut,
+			Quantity:      -line.Quantity,
+			UserID:        userID,
+			ReferenceType: "transfer",
+			ReferenceID:   transfer.ID,
+		}
+		if _, err := issueStock(tx, &movement, models.CostingFIFO, 0, false); errors.Is(err, errInsufficientStock) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Insufficient stock of product %d at the source location", line.ProductID)})
+			return
+		} else if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+			return
+		}
+
+		if err := tx.Model(&line).Update("movement_id", movement.ID).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer line"})
+			return
+		}
+	}
+
+	now := time.Now()
+	if err := tx.Model(transfer).Updates(map[string]interface{}{
+		"status":        models.TransferInTransit,
+		"dispatched_by": userID,
+		"dispatched_at": now,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// landTransfer books in-transit goods into locationID, recreating the lots
+// they were taken from so expiry dates and costs travel with them.
+func landTransfer(tx *gorm.DB, transfer *models.StockTransfer, locationID, userID uint) error {
+	now := time.Now()
+	for _, line := range transfer.Lines {
+		var consumptions []models.StockConsumption
+		if err := tx.Where("movement_id = ?", line.MovementID).Order("id").Find(&consumptions).Error; err != nil {
+			return err
+		}
+
+		remaining := line.Quantity
+		for _, consumption := range consumptions {
+			var source models.Stock
+			if err := tx.First(&source, consumption.StockID).Error; err != nil {
+				return err
+			}
+			lot := models.Stock{
+				BusinessID: transfer.BusinessID,
+				ProductID:  line.ProductID,
+				LocationID: locationID,
+				TransferID: transfer.ID,
+				Quantity:   consumption.Quantity,
+				Remaining:  consumption.Quantity,
+				UnitCost:   consumption.UnitCost,
+				LotNumber:  source.LotNumber,
+				ExpiresAt:  source.ExpiresAt,
+				AddedAt:    source.AddedAt, // Keep the lot's age for FIFO
+			}
+			if err := tx.Create(&lot).Error; err != nil {
+				return err
+			}
+			remaining -= consumption.Quantity
+		}
+
+		// Units that left without a lot arrive as one, valued at average cost
+		if remaining > 0 {
+			var product models.Product
+			if err := tx.Select("id, average_cost").First(&product, line.ProductID).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.Stock{
+				BusinessID: transfer.BusinessID,
+				ProductID:  line.ProductID,
+				LocationID: locationID,
+				TransferID: transfer.ID,
+				Quantity:   remaining,
+				Remaining:  remaining,
+				UnitCost:   product.AverageCost,
+				AddedAt:    now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := moveStock(tx, &models.StockMovement{
+			BusinessID:    transfer.BusinessID,
+			ProductID:     line.ProductID,
+			LocationID:    locationID,
+			Type:          models.MovementTransferIn,
+			Quantity:      line.Quantity,
+			UserID:        userID,
+			ReferenceType: "transfer",
+			ReferenceID:   transfer.ID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReceiveTransfer - Books in-transit goods into the destination location
+func ReceiveTransfer(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	transfer, ok := lockTransfer(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if transfer.Status != models.TransferInTransit {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Only in-transit transfers can be received"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	if err := landTransfer(tx, transfer, transfer.ToLocationID, userID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+		return
+	}
+
+	now := time.Now()
+	if err := tx.Model(transfer).Updates(map[string]interface{}{
+		"status":      models.TransferReceived,
+		"received_by": userID,
+		"received_at": now,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// CancelTransfer - Cancels a draft transfer, or returns in-transit goods to
+// the source location.
+func CancelTransfer(c *gin.Context) {
+	tx := database.DB.Begin()
+
+	transfer, ok := lockTransfer(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	switch transfer.Status {
+	case models.TransferDraft:
+	case models.TransferInTransit:
+		if err := landTransfer(tx, transfer, transfer.FromLocationID, c.GetUint("user_id")); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to return stock"})
+			return
+		}
+	default:
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Transfer is already " + transfer.Status})
+		return
+	}
+
+	if err := tx.Model(transfer).Update("status", models.TransferCancelled).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusOK, transfer)
+}
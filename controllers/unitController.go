@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+type productUnitInput struct {
+	Name         string  `json:"name" binding:"required"`
+	Factor       float64 `json:"factor" binding:"required,gt=0"` // Base units in one of this unit
+	Price        float64 `json:"price" binding:"min=0"`          // 0 prices the unit at factor x the product price
+	AllowDecimal bool    `json:"allow_decimal"`
+	ForPurchase  bool    `json:"for_purchase"`
+	ForSale      bool    `json:"for_sale"`
+}
+
+// findUnitProduct loads the product named in the URL, writing the error
+// response itself when it can't
+func findUnitProduct(c *gin.Context) (models.Product, bool) {
+	var product models.Product
+	if err := database.DB.
+		Where("business_id = ? AND id = ?", c.MustGet("business_id"), c.Param("id")).
+		First(&product).Error; err != nil {
+		handleProductError(c, err)
+		return product, false
+	}
+	return product, true
+}
+
+// CreateProductUnit - Adds a pack or measure the product can be bought or sold in
+func CreateProductUnit(c *gin.Context) {
+	if _, exists := c.Get("business_id"); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input productUnitInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, ok := findUnitProduct(c)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if strings.EqualFold(name, product.BaseUnit) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This is already the product's base unit"})
+		return
+	}
+	var existing models.ProductUnit
+	if err := database.DB.Where("product_id = ? AND LOWER(name) = ?", product.ID, strings.ToLower(name)).
+		First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Unit already exists for this product"})
+		return
+	}
+
+	unit := models.ProductUnit{
+		ProductID:    product.ID,
+		Name:         name,
+		Factor:       input.Factor,
+		Price:        input.Price,
+		AllowDecimal: input.AllowDecimal,
+		ForPurchase:  input.ForPurchase,
+		ForSale:      input.ForSale,
+	}
+	if err := database.DB.Create(&unit).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create unit"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, unit)
+}
+
+func GetProductUnits(c *gin.Context) {
+	if _, exists := c.Get("business_id"); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	product, ok := findUnitProduct(c)
+	if !ok {
+		return
+	}
+
+	var units []models.ProductUnit
+	if err := database.DB.Where("product_id = ?", product.ID).Order("factor").Find(&units).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch units"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"base_unit":     product.BaseUnit,
+		"allow_decimal": product.AllowDecimal,
+		"units":         units,
+	})
+}
+
+// UpdateProductUnit - Changes a unit. Sales and purchase orders already
+// recorded keep the factor they were made with.
+func UpdateProductUnit(c *gin.Context) {
+	if _, exists := c.Get("business_id"); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input productUnitInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, ok := findUnitProduct(c)
+	if !ok {
+		return
+	}
+
+	var unit models.ProductUnit
+	if err := database.DB.Where("product_id = ? AND id = ?", product.ID, c.Param("unit_id")).First(&unit).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unit not found"})
+		return
+	}
+
+	if err := database.DB.Model(&unit).Updates(map[string]interface{}{
+		"name":          strings.TrimSpace(input.Name),
+		"factor":        input.Factor,
+		"price":         input.Price,
+		"allow_decimal": input.AllowDecimal,
+		"for_purchase":  input.ForPurchase,
+		"for_sale":      input.ForSale,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update unit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, unit)
+}
+
+func DeleteProductUnit(c *gin.Context) {
+	if _, exists := c.Get("business_id"); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	product, ok := findUnitProduct(c)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Where("product_id = ? AND id = ?", product.ID, c.Param("unit_id")).Delete(&models.ProductUnit{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete unit"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unit not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unit deleted"})
+}
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// variantSKU builds a default SKU from the parent's SKU (or ID) and the
+// variant's option values, e.g. SHIRT-RED-M.
+func variantSKU(parent models.Product, values []string) string {
+	base := parent.SKU
+	if base == "" {
+		base = fmt.Sprintf("P%d", parent.ID)
+	}
+	parts := []string{base}
+	for _, value := range values {
+		parts = append(parts, strings.ToUpper(strings.Join(strings.Fields(value), "")))
+	}
+	return strings.Join(parts, "-")
+}
+
+// combinations returns every pick of one value per option, in option order
+func combinations(options [][]string) [][]string {
+	result := [][]string{{}}
+	for _, values := range options {
+		var next [][]string
+		for _, prefix := range result {
+			for _, value := range values {
+				combo := append(append([]string{}, prefix...), value)
+				next = append(next, combo)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// rollUpVariants sets each parent's Quantity to the sum of its variants'
+func rollUpVariants(products []models.Product) {
+	for i := range products {
+		if !products[i].HasVariants {
+			continue
+		}
+		products[i].Quantity = 0
+		for _, variant := range products[i].Variants {
+			products[i].Quantity += variant.Quantity
+		}
+	}
+}
+
+// GenerateVariants - Sets a product's options and creates a variant for every
+// combination of option values that doesn't exist yet.
+func GenerateVariants(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Options []struct {
+			Name   string   `json:"name" binding:"required"`
+			Values []string `json:"values" binding:"required,min=1,dive,required"`
+		} `json:"options" binding:"required,min=1,max=3,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// Locking the parent holds off stock moves until it is marked as having
+	// variants, after which they are refused
+	var parent models.Product
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&parent).Error; err != nil {
+		tx.Rollback()
+		handleProductError(c, err)
+		return
+	}
+	if parent.ParentID != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A variant cannot have its own variants"})
+		return
+	}
+	if !parent.HasVariants && parent.Quantity > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Adjust this product's stock to zero before splitting it into variants"})
+		return
+	}
+
+	// Options are replaced wholesale; existing variants are kept
+	if err := tx.Unscoped().Where("product_id = ?", parent.ID).Delete(&models.ProductOption{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update options"})
+		return
+	}
+
+	var values [][]string
+	for i, option := range input.Options {
+		for j := range option.Values {
+			option.Values[j] = strings.TrimSpace(option.Values[j])
+		}
+		productOption := models.ProductOption{
+			ProductID: parent.ID,
+			Name:      strings.TrimSpace(option.Name),
+			Values:    strings.Join(option.Values, ","),
+			Position:  i,
+		}
+		if err := tx.Create(&productOption).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save options"})
+			return
+		}
+		values = append(values, option.Values)
+	}
+
+	var existing []models.Product
+	if err := tx.Where("parent_id = ?", parent.ID).Find(&existing).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variants"})
+		return
+	}
+	have := make(map[string]bool, len(existing))
+	for _, variant := range existing {
+		have[strings.ToLower(variant.VariantName)] = true
+	}
+
+	var created []models.Product
+	for _, combo := range combinations(values) {
+		label := strings.Join(combo, " / ")
+		if have[strings.ToLower(label)] {
+			continue
+		}
+
+		// SKUs are unique per business, so number clashes like SHIRT-RED-M-2
+		sku := variantSKU(parent, combo)
+		for n := 2; ; n++ {
+			taken, err := skuTaken(tx, parent.BusinessID, 0, sku)
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check SKU"})
+				return
+			}
+			if !taken {
+				break
+			}
+			sku = fmt.Sprintf("%s-%d", variantSKU(parent, combo), n)
+		}
+
+		variant := models.Product{
+			BusinessID:   parent.BusinessID,
+			CategoryID:   parent.CategoryID,
+			Name:         parent.Name + " - " + label,
+			Description:  parent.Description,
+			Price:        parent.Price,
+			CostPrice:    parent.CostPrice,
+			AverageCost:  parent.CostPrice,
+			TaxClassID:   parent.TaxClassID,
+			SKU:          sku,
+			BaseUnit:     parent.BaseUnit,
+			AllowDecimal: parent.AllowDecimal,
+			ParentID:     &parent.ID,
+			VariantName:  label,
+		}
+		if err := tx.Create(&variant).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+			return
+		}
+		created = append(created, variant)
+	}
+
+	if err := tx.Model(&parent).Update("has_variants", true).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  fmt.Sprintf("%d variants created", len(created)),
+		"variants": created,
+	})
+}
+
+// GetVariants - Lists a parent product's variants
+func GetVariants(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var parent models.Product
+	if err := database.DB.Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		Preload("Variants").
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&parent).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"options":  parent.Options,
+		"variants": parent.Variants,
+	})
+}
+
+// UpdateVariant - Sets a variant's SKU and price override. A null
+// price_override makes the variant follow the parent's price again.
+func UpdateVariant(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		SKU           string   `json:"sku" binding:"required"`
+		PriceOverride *float64 `json:"price_override" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var parent models.Product
+	if err := database.DB.
+		Where("business_id = ? AND id = ?", businessID, c.Param("id")).
+		First(&parent).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+
+	var variant models.Product
+	if err := database.DB.
+		Where("parent_id = ? AND id = ?", parent.ID, c.Param("variant_id")).
+		First(&variant).Error; err != nil {
+		handleProductError(c, err)
+		return
+	}
+
+	sku := strings.TrimSpace(input.SKU)
+	if taken, err := skuTaken(database.DB, parent.BusinessID, variant.ID, sku); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU is already used by another product"})
+		return
+	}
+
+	price := parent.Price
+	if input.PriceOverride != nil {
+		price = *input.PriceOverride
+	}
+	if err := database.DB.Model(&variant).Updates(map[string]interface{}{
+		"sku":            sku,
+		"price_override": input.PriceOverride,
+		"price":          price,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, variant)
+}
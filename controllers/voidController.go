@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/models"
+)
+
+// VoidSaleOrder - Cancels a sale order rung up in error. Every stock
+// movement the order made is reversed through the ledger, including kit
+// components, and the order and its lines are flagged rather than deleted
+// so they still show in reports. Orders with returns, credit sales the
+// customer has started paying, or M-Pesa prompts still awaiting an answer
+// can't be voided.
+func VoidSaleOrder(c *gin.Context) {
+	businessID, exists := c.Get("business_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// Locking the order stops a void racing a return or a second void
+	order, err := lockSaleOrder(tx, businessID, c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sale order not found"})
+		return
+	} else if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sale order"})
+		return
+	}
+	if order.VoidedAt != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order is already voided"})
+		return
+	}
+
+	var returns int
+	if err := tx.Model(&models.SaleReturn{}).Where("sale_order_id = ?", order.ID).Count(&returns).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check returns"})
+		return
+	}
+	if returns > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order has returns and can't be voided"})
+		return
+	}
+
+	// A prompt the customer may still pay would settle a sale whose stock
+	// is back on the shelf. Retries lock the order too, so none can start
+	// behind this check.
+	var pending int
+	if err := tx.Model(&models.SalePayment{}).
+		Where("sale_order_id = ? AND status = ?", order.ID, models.SalePaymentPending).
+		Count(&pending).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	if pending > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order is waiting on an M-Pesa payment; check its status before voiding"})
+		return
+	}
+
+	onAccount, err := onAccountAmount(tx, order.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	if onAccount > 0 {
+		if order.AmountDue < onAccount {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sale order has payments against it and can't be voided"})
+			return
+		}
+		if err := settleOrder(tx, &order, order.AmountDue); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer account"})
+			return
+		}
+	}
+
+	var saleIDs []uint
+	if err := tx.Model(&models.Sale{}).Where("sale_order_id = ?", order.ID).Pluck("id", &saleIDs).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sale lines"})
+		return
+	}
+
+	var movements []models.StockMovement
+	if len(saleIDs) > 0 {
+		if err := tx.Where("reference_type = ? AND reference_id IN (?)", "sale", saleIDs).
+			Order("id").
+			Find(&movements).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+			return
+		}
+	}
+
+	userID := c.GetUint("user_id")
+	for _, movement := range movements {
+		if err := restoreStock(tx, movement, &models.StockMovement{
+			BusinessID:    order.BusinessID,
+			Type:          models.MovementVoid,
+			Reason:        input.Reason,
+			UserID:        userID,
+			ReferenceType: "sale_void",
+			ReferenceID:   order.ID,
+		}); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to restore stock: %v", err)})
+			return
+		}
+	}
+
+	if err := tx.Model(&models.Sale{}).Where("sale_order_id = ?", order.ID).
+		UpdateColumn("voided", true).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to void sale lines"})
+		return
+	}
+
+	now := time.Now()
+	if err := tx.Model(&order).Updates(map[string]interface{}{
+		"voided_at":   now,
+		"voided_by":   userID,
+		"void_reason": input.Reason,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to void sale order"})
+		return
+	}
+	order.VoidedAt = &now
+	if err := queueFiscalCreditNote(tx, &order, 0); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue credit note"})
+		return
+	}
+
+	tx.Commit()
+	fiscalizeOrder(order.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sale order voided", "order": order})
+}
@@ -27,6 +27,131 @@ func ConnectDatabase() {
 
 	DB = DB.Debug()
 
-	//migrations
-	DB.AutoMigrate(&models.Product{}, &models.Stock{}, &models.Sale{}, &models.User{}, &models.Business{}, &models.Category{})
+	Migrate()
+}
+
+// Migrate brings the schema of the connected database up to date. It is
+// safe to run on every start.
+func Migrate() {
+	DB.AutoMigrate(
+		&models.Product{},
+		&models.ProductOption{},
+		&models.ProductUnit{},
+		&models.KitComponent{},
+		&models.ProductBarcode{},
+		&models.Stock{},
+		&models.StockConsumption{},
+		&models.Sale{},
+		&models.SaleOrder{},
+		&models.SalePayment{},
+		&models.FiscalInvoice{},
+		&models.Promotion{},
+		&models.DiscountLimit{},
+		&models.PriceList{},
+		&models.PriceListItem{},
+		&models.SaleReturn{},
+		&models.SaleReturnLine{},
+		&models.TaxClass{},
+		&models.Customer{},
+		&models.CustomerPayment{},
+		&models.CustomerPaymentAllocation{},
+		&models.StockMovement{},
+		&models.StockAdjustment{},
+		&models.Stocktake{},
+		&models.StocktakeLine{},
+		&models.Supplier{},
+		&models.PurchaseOrder{},
+		&models.PurchaseOrderLine{},
+		&models.Shift{},
+		&models.ShiftCashMovement{},
+		&models.Location{},
+		&models.ProductStock{},
+		&models.StockTransfer{},
+		&models.StockTransferLine{},
+		&models.User{},
+		&models.Business{},
+		&models.Category{},
+	)
+
+	// Quantities were whole numbers before units of measure; AutoMigrate
+	// doesn't change column types, so older databases are converted here
+	convertToNumeric("products", "quantity")
+	convertToNumeric("product_stocks", "quantity")
+	convertToNumeric("stocks", "quantity", "remaining")
+	convertToNumeric("stock_consumptions", "quantity")
+	convertToNumeric("sales", "quantity")
+	convertToNumeric("sale_orders", "item_count")
+	convertToNumeric("stock_movements", "quantity", "balance", "location_balance")
+	convertToNumeric("stock_adjustments", "quantity")
+	convertToNumeric("stocktake_lines", "expected", "counted")
+	convertToNumeric("purchase_order_lines", "quantity_ordered", "quantity_received")
+	convertToNumeric("stock_transfer_lines", "quantity")
+
+	// Orders taken before split tenders were paid in full by their one method
+	if err := DB.Exec(`INSERT INTO sale_payments (created_at, updated_at, business_id, sale_order_id, method, amount, change, paid_at)
+		SELECT o.created_at, o.created_at, o.business_id, o.id, COALESCE(NULLIF(o.payment_method, ''), 'cash'), o.total, 0, o.sold_at
+		FROM sale_orders o
+		WHERE o.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM sale_payments p WHERE p.sale_order_id = o.id)`).Error; err != nil {
+		log.Println("Failed to backfill sale payments:", err)
+	}
+
+	// Stock can never go below zero, whichever code path writes it
+	addConstraint("products", "chk_products_quantity_non_negative", "CHECK (quantity >= 0)")
+	addConstraint("product_stocks", "chk_product_stocks_quantity_non_negative", "CHECK (quantity >= 0)")
+
+	// SKUs and barcodes identify one product within a business
+	if err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_business_sku
+		ON products (business_id, LOWER(sku)) WHERE sku <> '' AND deleted_at IS NULL`).Error; err != nil {
+		log.Println("Failed to add product SKU index:", err)
+	}
+	if err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_product_barcodes_code
+		ON product_barcodes (business_id, code) WHERE deleted_at IS NULL`).Error; err != nil {
+		log.Println("Failed to add barcode index:", err)
+	}
+
+	// An order has one sale invoice, and one credit note per void or return
+	if err := DB.Exec(`DROP INDEX IF EXISTS uix_fiscal_invoices_sale_order_id`).Error; err != nil {
+		log.Println("Failed to drop fiscal invoice index:", err)
+	}
+	if err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_fiscal_invoices_document
+		ON fiscal_invoices (sale_order_id, type, sale_return_id) WHERE deleted_at IS NULL`).Error; err != nil {
+		log.Println("Failed to add fiscal invoice index:", err)
+	}
+
+	// A cashier has at most one open shift
+	if err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_shifts_open_user
+		ON shifts (user_id) WHERE status = 'open' AND deleted_at IS NULL`).Error; err != nil {
+		log.Println("Failed to add open shift index:", err)
+	}
+
+	// A business has at most one default location
+	if err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_locations_default
+		ON locations (business_id) WHERE is_default AND deleted_at IS NULL`).Error; err != nil {
+		log.Println("Failed to add default location index:", err)
+	}
+}
+
+// convertToNumeric changes integer columns to numeric, leaving columns that
+// are already numeric alone
+func convertToNumeric(table string, columns ...string) {
+	for _, column := range columns {
+		if err := DB.Exec(fmt.Sprintf(`DO $$ BEGIN
+			IF (SELECT data_type FROM information_schema.columns
+				WHERE table_name = '%[1]s' AND column_name = '%[2]s') = 'integer' THEN
+				ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE numeric;
+			END IF;
+		END $$`, table, column)).Error; err != nil {
+			log.Printf("Failed to convert %s.%s to numeric: %v", table, column, err)
+		}
+	}
+}
+
+// addConstraint adds a table constraint unless it already exists
+func addConstraint(table, name, definition string) {
+	if err := DB.Exec(fmt.Sprintf(`DO $$ BEGIN
+		ALTER TABLE %s ADD CONSTRAINT %s %s;
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$`, table, name, definition)).Error; err != nil {
+		log.Printf("Failed to add %s constraint: %v", name, err)
+	}
 }
@@ -0,0 +1,209 @@
+package fiscal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ken-eddy/stockApp/config"
+)
+
+// etimsSuccess is the result code of an accepted request
+const etimsSuccess = "000"
+
+// eat is East Africa Time, which eTIMS dates are in
+var eat = time.FixedZone("EAT", 3*60*60)
+
+// etimsTaxTypes are the tax types eTIMS totals invoices by
+var etimsTaxTypes = []string{"A", "B", "C", "D", "E"}
+
+// etimsPaymentTypes maps payment methods to eTIMS payment type codes
+var etimsPaymentTypes = map[string]string{
+	"cash":   "01",
+	"credit": "02",
+	"card":   "05",
+	"mpesa":  "06",
+	"split":  "07",
+}
+
+// Etims is a Device backed by a KRA eTIMS online sales control unit
+type Etims struct {
+	cfg    config.EtimsConfig
+	client *http.Client
+}
+
+// NewEtims returns an eTIMS device. A nil client uses one with a 30 second
+// timeout.
+func NewEtims(cfg config.EtimsConfig, client *http.Client) *Etims {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return &Etims{cfg: cfg, client: client}
+}
+
+// round2 rounds an amount to cents, as eTIMS expects
+func round2(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// salesRequest builds the body of a saveSales request. eTIMS amounts are
+// tax inclusive, with the tax in them totalled per tax type.
+func (e *Etims) salesRequest(invoice Invoice) map[string]interface{} {
+	taxable := make(map[string]float64)
+	tax := make(map[string]float64)
+	rates := make(map[string]float64)
+
+	items := make([]map[string]interface{}, len(invoice.Items))
+	for i, item := range invoice.Items {
+		taxCode := item.TaxCode
+		if taxCode == "" {
+			taxCode = "D" // Not subject to VAT
+		}
+		taxable[taxCode] += item.Total
+		tax[taxCode] += item.Tax
+		rates[taxCode] = item.TaxRate
+
+		var discountRate float64
+		if supply := item.Total + item.Discount; supply > 0 {
+			discountRate = round2(item.Discount / supply * 100)
+		}
+		items[i] = map[string]interface{}{
+			"itemSeq":   i + 1,
+			"itemCd":    item.Code,
+			"itemClsCd": e.cfg.ItemClass,
+			"itemNm":    item.Name,
+			"pkgUnitCd": "NT",
+			"pkg":       1,
+			"qtyUnitCd": "U",
+			"qty":       item.Quantity,
+			"prc":       round2(item.UnitPrice),
+			"splyAmt":   round2(item.Total + item.Discount),
+			"dcRt":      discountRate,
+			"dcAmt":     round2(item.Discount),
+			"taxTyCd":   taxCode,
+			"taxblAmt":  round2(item.Total),
+			"taxAmt":    round2(item.Tax),
+			"totAmt":    round2(item.Total),
+		}
+	}
+
+	paymentType, ok := etimsPaymentTypes[invoice.PaymentMethod]
+	if !ok {
+		paymentType = "07" // Other
+	}
+	// Credit notes are refund receipts against the original invoice
+	receiptType := "S" // Sale
+	if invoice.OriginalNumber != 0 {
+		receiptType = "R"
+	}
+	issued := invoice.IssuedAt.In(eat)
+	body := map[string]interface{}{
+		"tin":          e.cfg.PIN,
+		"bhfId":        e.cfg.BranchID,
+		"invcNo":       invoice.Number,
+		"orgInvcNo":    invoice.OriginalNumber,
+		"trdInvcNo":    invoice.Reference,
+		"custNm":       invoice.BuyerName,
+		"salesTyCd":    "N", // Normal
+		"rcptTyCd":     receiptType,
+		"pmtTyCd":      paymentType,
+		"salesSttsCd":  "02", // Approved
+		"cfmDt":        issued.Format("20060102150405"),
+		"salesDt":      issued.Format("20060102"),
+		"totItemCnt":   len(items),
+		"totTaxblAmt":  round2(invoice.Total),
+		"totTaxAmt":    round2(invoice.TaxTotal),
+		"totAmt":       round2(invoice.Total),
+		"prchrAcptcYn": "N",
+		"regrId":       invoice.Cashier,
+		"regrNm":       invoice.Cashier,
+		"modrId":       invoice.Cashier,
+		"modrNm":       invoice.Cashier,
+		"receipt": map[string]interface{}{
+			"rcptPbctDt":   issued.Format("20060102150405"),
+			"prchrAcptcYn": "N",
+		},
+		"itemList": items,
+	}
+	if receiptType == "R" {
+		body["rfdDt"] = issued.Format("20060102150405")
+		body["rfdRsnCd"] = "06" // Refund
+	}
+	for _, code := range etimsTaxTypes {
+		body["taxblAmt"+code] = round2(taxable[code])
+		body["taxRt"+code] = rates[code]
+		body["taxAmt"+code] = round2(tax[code])
+	}
+	return body
+}
+
+// Submit sends a sale or credit note to eTIMS and returns the control unit's signature.
+// The control number is the CU invoice number, and the QR code links to
+// KRA's copy of the receipt.
+func (e *Etims) Submit(ctx context.Context, invoice Invoice) (Result, error) {
+	if e.cfg.PIN == "" || e.cfg.CommKey == "" {
+		return Result{}, ErrNotConfigured
+	}
+
+	encoded, err := json.Marshal(e.salesRequest(invoice))
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.BaseURL+"/trnsSales/saveSales", bytes.NewReader(encoded))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("tin", e.cfg.PIN)
+	req.Header.Set("bhfId", e.cfg.BranchID)
+	req.Header.Set("cmcKey", e.cfg.CommKey)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("fiscal: eTIMS returned status %d", resp.StatusCode)
+	}
+
+	var reply struct {
+		ResultCd  string `json:"resultCd"`
+		ResultMsg string `json:"resultMsg"`
+		Data      struct {
+			RcptNo           int64  `json:"rcptNo"`
+			IntrlData        string `json:"intrlData"`
+			RcptSign         string `json:"rcptSign"`
+			VsdcRcptPbctDate string `json:"vsdcRcptPbctDate"` // When the control unit signed it
+			SdcID            string `json:"sdcId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return Result{}, fmt.Errorf("fiscal: reading eTIMS reply: %w", err)
+	}
+	if reply.ResultCd != etimsSuccess {
+		return Result{}, Rejection{Code: reply.ResultCd, Message: reply.ResultMsg}
+	}
+
+	signedAt, err := time.ParseInLocation("20060102150405", reply.Data.VsdcRcptPbctDate, eat)
+	if err != nil {
+		signedAt = time.Now()
+	}
+	return Result{
+		ControlNumber: fmt.Sprintf("%s/%d", reply.Data.SdcID, reply.Data.RcptNo),
+		QRCode:        e.cfg.ReceiptURL + e.cfg.PIN + e.cfg.BranchID + reply.Data.RcptSign,
+		Signature:     reply.Data.RcptSign,
+		SignedAt:      signedAt,
+	}, nil
+}
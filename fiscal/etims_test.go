@@ -0,0 +1,196 @@
+package fiscal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ken-eddy/stockApp/config"
+)
+
+// testInvoice is a one line sale of two sodas at 16% VAT
+var testInvoice = Invoice{
+	Number:        42,
+	Reference:     "000042",
+	IssuedAt:      time.Date(2024, 3, 1, 9, 30, 0, 0, eat),
+	Cashier:       "Jane Wanjiku",
+	PaymentMethod: "mpesa",
+	Items: []Item{{
+		Code:      "SODA-500",
+		Name:      "Soda 500ml",
+		Quantity:  2,
+		UnitPrice: 60,
+		Discount:  20,
+		Total:     100,
+		TaxCode:   "B",
+		TaxRate:   16,
+		Tax:       13.79,
+	}},
+	Total:    100,
+	TaxTotal: 13.79,
+}
+
+// etimsServer answers saveSales with reply, handing each request body to
+// check first
+func etimsServer(t *testing.T, status int, reply string, check func(r *http.Request, body map[string]interface{})) *Etims {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/trnsSales/saveSales" {
+			t.Errorf("got %s %s, want POST /trnsSales/saveSales", r.Method, r.URL.Path)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if check != nil {
+			check(r, body)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewEtims(config.EtimsConfig{
+		BaseURL:    server.URL + "/",
+		PIN:        "P051234567A",
+		BranchID:   "00",
+		CommKey:    "cmc-key",
+		ItemClass:  "5020230500",
+		ReceiptURL: "https://etims.example/receipt?Data=",
+	}, server.Client())
+}
+
+func TestEtimsSubmitSigned(t *testing.T) {
+	reply := `{"resultCd":"000","resultMsg":"It is succeeded","resultDt":"20240301093012",
+		"data":{"rcptNo":16,"intrlData":"ZWH33TQE6HQ5BIVKC5LOPW2Z5Y","rcptSign":"2SMQOPE7UWPEJBW5",
+		"totRcptNo":29,"vsdcRcptPbctDate":"20240301093012","sdcId":"KRACU0100000001","mrcNo":"WIS01006230"}}`
+	device := etimsServer(t, http.StatusOK, reply, func(r *http.Request, body map[string]interface{}) {
+		for header, want := range map[string]string{"tin": "P051234567A", "bhfId": "00", "cmcKey": "cmc-key"} {
+			if got := r.Header.Get(header); got != want {
+				t.Errorf("header %s = %q, want %q", header, got, want)
+			}
+		}
+		for field, want := range map[string]interface{}{
+			"invcNo":    42.0,
+			"orgInvcNo": 0.0,
+			"trdInvcNo": "000042",
+			"rcptTyCd":  "S",
+			"pmtTyCd":   "06",
+			"cfmDt":     "20240301093000",
+			"totAmt":    100.0,
+			"totTaxAmt": 13.79,
+			"taxblAmtB": 100.0,
+			"taxAmtB":   13.79,
+			"taxRtB":    16.0,
+		} {
+			if body[field] != want {
+				t.Errorf("%s = %v, want %v", field, body[field], want)
+			}
+		}
+		if _, ok := body["rfdRsnCd"]; ok {
+			t.Error("sale sent with a refund reason")
+		}
+		items, _ := body["itemList"].([]interface{})
+		if len(items) != 1 {
+			t.Errorf("itemList has %d items, want 1", len(items))
+			return
+		}
+		item := items[0].(map[string]interface{})
+		for field, want := range map[string]interface{}{
+			"itemCd": "SODA-500", "qty": 2.0, "prc": 60.0, "splyAmt": 120.0,
+			"dcRt": 16.67, "dcAmt": 20.0, "taxblAmt": 100.0, "totAmt": 100.0,
+		} {
+			if item[field] != want {
+				t.Errorf("item %s = %v, want %v", field, item[field], want)
+			}
+		}
+	})
+
+	result, err := device.Submit(context.Background(), testInvoice)
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if result.ControlNumber != "KRACU0100000001/16" {
+		t.Errorf("ControlNumber = %q", result.ControlNumber)
+	}
+	if result.Signature != "2SMQOPE7UWPEJBW5" {
+		t.Errorf("Signature = %q", result.Signature)
+	}
+	if want := "https://etims.example/receipt?Data=P051234567A002SMQOPE7UWPEJBW5"; result.QRCode != want {
+		t.Errorf("QRCode = %q, want %q", result.QRCode, want)
+	}
+	if want := time.Date(2024, 3, 1, 9, 30, 12, 0, eat); !result.SignedAt.Equal(want) {
+		t.Errorf("SignedAt = %v, want %v", result.SignedAt, want)
+	}
+}
+
+func TestEtimsSubmitCreditNote(t *testing.T) {
+	note := testInvoice
+	note.Number, note.OriginalNumber, note.Reference = 43, 42, "000042-R7"
+	reply := `{"resultCd":"000","resultMsg":"It is succeeded","data":{"rcptNo":17,"rcptSign":"SIGN","vsdcRcptPbctDate":"20240301100000","sdcId":"KRACU0100000001"}}`
+	device := etimsServer(t, http.StatusOK, reply, func(r *http.Request, body map[string]interface{}) {
+		for field, want := range map[string]interface{}{
+			"invcNo":    43.0,
+			"orgInvcNo": 42.0,
+			"trdInvcNo": "000042-R7",
+			"rcptTyCd":  "R",
+			"rfdRsnCd":  "06",
+			"rfdDt":     "20240301093000",
+		} {
+			if body[field] != want {
+				t.Errorf("%s = %v, want %v", field, body[field], want)
+			}
+		}
+	})
+
+	if _, err := device.Submit(context.Background(), note); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+}
+
+func TestEtimsSubmitRejected(t *testing.T) {
+	device := etimsServer(t, http.StatusOK, `{"resultCd":"910","resultMsg":"Request parameter error","data":null}`, nil)
+
+	_, err := device.Submit(context.Background(), testInvoice)
+	var rejection Rejection
+	if !errors.As(err, &rejection) {
+		t.Fatalf("Submit error = %v, want a Rejection", err)
+	}
+	if rejection.Code != "910" || rejection.Message != "Request parameter error" {
+		t.Errorf("rejection = %+v", rejection)
+	}
+}
+
+// Failures other than a rejection are worth retrying, so they mustn't look
+// like one
+func TestEtimsSubmitUnavailable(t *testing.T) {
+	for name, reply := range map[string]struct {
+		status int
+		body   string
+	}{
+		"server error": {http.StatusBadGateway, `<html>Bad Gateway</html>`},
+		"garbled":      {http.StatusOK, `{"resultCd":`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			device := etimsServer(t, reply.status, reply.body, nil)
+			_, err := device.Submit(context.Background(), testInvoice)
+			if err == nil {
+				t.Fatal("Submit succeeded")
+			}
+			if errors.As(err, &Rejection{}) {
+				t.Errorf("Submit error %v is a Rejection", err)
+			}
+		})
+	}
+}
+
+func TestEtimsSubmitNotConfigured(t *testing.T) {
+	device := NewEtims(config.EtimsConfig{BaseURL: "http://127.0.0.1:1"}, nil)
+	if _, err := device.Submit(context.Background(), testInvoice); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Submit error = %v, want ErrNotConfigured", err)
+	}
+}
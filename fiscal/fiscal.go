@@ -0,0 +1,68 @@
+// Package fiscal reports sales to the tax authority's invoicing system,
+// such as KRA eTIMS, which signs each invoice and returns the numbers that
+// must be printed on the receipt.
+package fiscal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotConfigured is returned when a device is used without credentials
+var ErrNotConfigured = errors.New("fiscal device is not configured")
+
+// Rejection is the tax authority refusing an invoice. Sending the same
+// invoice again won't help; other errors are worth retrying.
+type Rejection struct {
+	Code    string
+	Message string
+}
+
+func (r Rejection) Error() string {
+	return fmt.Sprintf("fiscal: invoice rejected: %s (%s)", r.Message, r.Code)
+}
+
+// Item is one line of an invoice. Amounts include tax.
+type Item struct {
+	Code      string // Seller's item code, e.g. the SKU
+	Name      string
+	Quantity  float64
+	UnitPrice float64 // Before Discount
+	Discount  float64
+	Total     float64 // After Discount
+	TaxCode   string  // KRA tax type, e.g. "B" for 16% VAT
+	TaxRate   float64 // Percent
+	Tax       float64
+}
+
+// Invoice is a completed sale as reported to the tax authority, or a
+// credit note reversing all or part of one. Amounts on credit notes are
+// positive.
+type Invoice struct {
+	Number         uint   // Seller's invoice number, unique and increasing
+	OriginalNumber uint   // Number of the invoice a credit note reverses; 0 on a sale
+	Reference      string // Seller's own document number, e.g. the receipt number
+	IssuedAt       time.Time
+	Cashier        string
+	BuyerName      string
+	PaymentMethod  string // cash, mpesa, card, credit or split
+	Items          []Item
+	Total          float64
+	TaxTotal       float64
+}
+
+// Result is the tax authority's signature on an invoice
+type Result struct {
+	ControlNumber string // Printed on the receipt, e.g. the CU invoice number
+	QRCode        string // Text to encode in the receipt's QR code
+	Signature     string
+	SignedAt      time.Time
+}
+
+// Device signs invoices with the tax authority
+type Device interface {
+	// Submit reports a sale or credit note and returns the authority's signature
+	Submit(ctx context.Context, invoice Invoice) (Result, error)
+}
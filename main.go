@@ -36,6 +36,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
@@ -44,7 +45,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"github.com/ken-eddy/stockApp/config"
+	"github.com/ken-eddy/stockApp/controllers"
 	"github.com/ken-eddy/stockApp/database"
+	"github.com/ken-eddy/stockApp/fiscal"
+	"github.com/ken-eddy/stockApp/payments"
 	"github.com/ken-eddy/stockApp/routes"
 )
 
@@ -64,6 +69,23 @@ func main() {
 	database.ConnectDatabase()
 	defer database.DB.Close()
 
+	// M-Pesa is optional; without credentials, till prompts are refused.
+	// Daraja doesn't sign its callbacks, so without a token anyone could
+	// mark a sale paid.
+	if mpesa := config.LoadMpesaConfig(); mpesa.ConsumerKey != "" {
+		if mpesa.CallbackToken == "" {
+			log.Println("MPESA_CALLBACK_TOKEN is not set; M-Pesa prompts are disabled")
+		} else {
+			controllers.SetPaymentGateway(payments.NewMpesa(mpesa, nil), mpesa.CallbackToken)
+		}
+	}
+
+	// eTIMS is optional too; without a PIN, sales aren't fiscalized
+	if etims := config.LoadEtimsConfig(); etims.PIN != "" {
+		controllers.SetFiscalDevice(fiscal.NewEtims(etims, nil))
+		controllers.StartFiscalRetries(context.Background(), time.Minute)
+	}
+
 	// Initialize Gin router
 	router := gin.Default()
 
@@ -6,8 +6,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// RoleMiddleware - Middleware to check user role
-func RoleMiddleware(requiredRole string) gin.HandlerFunc {
+// RoleMiddleware - Middleware to check user role is one of allowedRoles
+func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
 		if !exists {
@@ -17,12 +17,16 @@ func RoleMiddleware(requiredRole string) gin.HandlerFunc {
 		}
 
 		userRole, ok := role.(string)
-		if !ok || userRole != requiredRole {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-			c.Abort()
-			return
+		if ok {
+			for _, allowed := range allowedRoles {
+				if userRole == allowed {
+					c.Next()
+					return
+				}
+			}
 		}
 
-		c.Next()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
 	}
 }
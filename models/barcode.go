@@ -0,0 +1,20 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// Barcode symbologies
+const (
+	BarcodeEAN13   = "ean13"
+	BarcodeUPCA    = "upca"
+	BarcodeCode128 = "code128"
+)
+
+// ProductBarcode is a code printed on a product's packaging. A product can
+// carry several, e.g. the maker's EAN and an in-store Code 128 label.
+type ProductBarcode struct {
+	gorm.Model
+	BusinessID uint   `json:"business_id" gorm:"not null;index"`
+	ProductID  uint   `json:"product_id" gorm:"not null;index"`
+	Code       string `json:"code" gorm:"not null"`
+	Symbology  string `json:"symbology" gorm:"not null"`
+}
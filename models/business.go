@@ -2,12 +2,21 @@ package models
 
 import "github.com/jinzhu/gorm"
 
+// Costing methods used to value cost of goods sold
+const (
+	CostingFIFO    = "fifo"
+	CostingAverage = "average"
+	CostingLatest  = "latest"
+)
+
 type Business struct {
 	gorm.Model
-	BusinessName string     `json:"business_name" gorm:"not null;unique"`
-	Password     string     `json:"password" binding:"required" gorm:"not null"`
-	Users        []*User    `json:"-" gorm:"foreignKey:BusinessID" ` // 🔥 Use pointer slice
-	Products     []*Product `json:"-" gorm:"foreignKey:BusinessID"`
-	Stock        []*Stock   `json:"-" gorm:"foreignKey:BusinessID"`
-	Sales        []*Sale    `json:"-" gorm:"foreignKey:BusinessID"`
+	BusinessName     string     `json:"business_name" gorm:"not null;unique"`
+	Password         string     `json:"password" binding:"required" gorm:"not null"`
+	CostingMethod    string     `json:"costing_method" gorm:"default:'fifo'"`
+	PricesIncludeTax bool       `json:"prices_include_tax" gorm:"default:true"` // false when tax is added on top of prices
+	Users            []*User    `json:"-" gorm:"foreignKey:BusinessID" `        // 🔥 Use pointer slice
+	Products         []*Product `json:"-" gorm:"foreignKey:BusinessID"`
+	Stock            []*Stock   `json:"-" gorm:"foreignKey:BusinessID"`
+	Sales            []*Sale    `json:"-" gorm:"foreignKey:BusinessID"`
 }
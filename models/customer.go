@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Customer is someone the business sells to by name, usually so they can
+// buy on account and settle later
+type Customer struct {
+	gorm.Model
+	BusinessID  uint    `json:"business_id" gorm:"not null;index"`
+	Name        string  `json:"name" gorm:"not null"`
+	Phone       string  `json:"phone" gorm:"index"`
+	Email       string  `json:"email"`
+	Address     string  `json:"address"`
+	CreditLimit float64 `json:"credit_limit"` // Most the customer may owe; 0 allows no credit
+	Balance     float64 `json:"balance"`      // Owed on credit sales, kept in step with their AmountDue
+	Notes       string  `json:"notes"`
+	PriceListID uint    `json:"price_list_id" gorm:"index"`
+}
+
+// CustomerPayment is money received against a customer's account. It is
+// allocated to their oldest unpaid credit sales first.
+type CustomerPayment struct {
+	gorm.Model
+	BusinessID  uint                        `json:"business_id" gorm:"not null;index"`
+	CustomerID  uint                        `json:"customer_id" gorm:"not null;index"`
+	UserID      uint                        `json:"user_id" gorm:"index"` // Who took the payment
+	ShiftID     uint                        `json:"shift_id" gorm:"index"`
+	Amount      float64                     `json:"amount"`
+	Method      string                      `json:"method"`
+	Reference   string                      `json:"reference"` // e.g. an M-Pesa code or cheque number
+	Note        string                      `json:"note"`
+	PaidAt      time.Time                   `json:"paid_at" gorm:"index"`
+	Allocations []CustomerPaymentAllocation `json:"allocations" gorm:"foreignKey:PaymentID"`
+}
+
+// CustomerPaymentAllocation is the part of a payment applied to one sale order
+type CustomerPaymentAllocation struct {
+	ID          uint    `json:"id" gorm:"primary_key"`
+	PaymentID   uint    `json:"payment_id" gorm:"not null;index"`
+	SaleOrderID uint    `json:"sale_order_id" gorm:"not null;index"`
+	Amount      float64 `json:"amount"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Fiscal invoice states
+const (
+	FiscalPending   = "pending"   // Waiting to be sent, or to be retried
+	FiscalSigned    = "signed"    // Accepted by the tax authority
+	FiscalRejected  = "rejected"  // Refused; needs fixing and a manual retry
+	FiscalCancelled = "cancelled" // Order voided before it was sent, or a credit note for a sale never signed
+)
+
+// Fiscal invoice types
+const (
+	FiscalSale       = "sale"
+	FiscalCreditNote = "credit_note" // Reverses a signed sale, in full for a void or in part for a return
+)
+
+// FiscalInvoice tracks reporting a sale order, or a void or return of one,
+// to the tax authority. Invoices are queued with the change that needs
+// them and sent straight after; ones that fail to send are retried in the
+// background. Invoices are numbered by ID for the tax authority.
+type FiscalInvoice struct {
+	gorm.Model
+	BusinessID    uint       `json:"business_id" gorm:"not null;index"`
+	SaleOrderID   uint       `json:"sale_order_id" gorm:"not null;index"`
+	Type          string     `json:"type" gorm:"default:'sale'"`
+	SaleReturnID  uint       `json:"sale_return_id"` // Return a credit note is for; 0 for a void
+	OriginalID    uint       `json:"original_id"`    // Sale invoice a credit note reverses
+	Status        string     `json:"status" gorm:"default:'pending';index"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	ControlNumber string     `json:"control_number"` // Printed on the receipt
+	QRCode        string     `json:"qr_code"`        // Text of the receipt's QR code
+	Signature     string     `json:"signature"`
+	SignedAt      *time.Time `json:"signed_at"`
+}
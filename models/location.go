@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Location is a shop or warehouse holding its own stock. Each business has
+// one default location, used whenever a request doesn't name one.
+type Location struct {
+	gorm.Model
+	BusinessID uint   `json:"business_id" gorm:"not null;index"`
+	Name       string `json:"name" gorm:"not null"`
+	Address    string `json:"address"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+// ProductStock is the quantity of a product held at one location.
+// Product.Quantity is the sum over all locations.
+type ProductStock struct {
+	ID         uint      `json:"id" gorm:"primary_key"`
+	BusinessID uint      `json:"business_id" gorm:"not null;index"`
+	LocationID uint      `json:"location_id" gorm:"not null;unique_index:idx_location_product"`
+	ProductID  uint      `json:"product_id" gorm:"not null;unique_index:idx_location_product"`
+	Product    Product   `json:"product" gorm:"foreignKey:ProductID"`
+	Quantity   float64   `json:"quantity"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// PriceList is a set of prices some customers pay instead of the
+// products' own, such as wholesale
+type PriceList struct {
+	gorm.Model
+	BusinessID  uint            `json:"business_id" gorm:"not null;index"`
+	Name        string          `json:"name" gorm:"not null"`
+	Description string          `json:"description"`
+	Items       []PriceListItem `json:"items,omitempty" gorm:"foreignKey:PriceListID"`
+}
+
+// PriceListItem is a product's price on a list for MinQuantity and up. A
+// product has one item per quantity break.
+type PriceListItem struct {
+	ID          uint    `json:"id" gorm:"primary_key"`
+	PriceListID uint    `json:"price_list_id" gorm:"not null;unique_index:idx_price_list_item"`
+	ProductID   uint    `json:"product_id" gorm:"not null;unique_index:idx_price_list_item"` // Covers its variants unless they have their own
+	UnitID      uint    `json:"unit_id" gorm:"unique_index:idx_price_list_item"`             // 0 for the base unit
+	MinQuantity float64 `json:"min_quantity" gorm:"unique_index:idx_price_list_item"`        // In UnitID
+	Price       float64 `json:"price"`                                                       // Per UnitID
+}
@@ -6,13 +6,60 @@ import (
 
 type Product struct {
 	gorm.Model
-	BusinessID  uint     `json:"business_id" gorm:"not null;index"`
-	CategoryID  uint     `json:"category_id" gorm:"not null;index"`
-	Category    Category `gorm:"foreignKey:CategoryID;references:ID"`
-	Name        string   `json:"name" gorm:"not null"`
-	Description string   `json:"description"`
-	Quantity    int      `json:"quantity" binding:"required"`
-	Price       float64  `json:"price" binding:"required"`
-	Stocks      []Stock  `gorm:"foreignKey:ProductID"`
-	Sales       []Sale   `gorm:"foreignKey:ProductID"`
+	BusinessID    uint             `json:"business_id" gorm:"not null;index"`
+	CategoryID    uint             `json:"category_id" gorm:"not null;index"`
+	Category      Category         `gorm:"foreignKey:CategoryID;references:ID"`
+	Name          string           `json:"name" gorm:"not null"`
+	Description   string           `json:"description"`
+	Quantity      float64          `json:"quantity" binding:"required"`    // In the base unit
+	BaseUnit      string           `json:"base_unit" gorm:"default:'pcs'"` // Unit stock is kept in, e.g. "bottle" or "kg"
+	AllowDecimal  bool             `json:"allow_decimal"`                  // Whether the base unit can be split, e.g. kg
+	Price         float64          `json:"price" binding:"required"`
+	CostPrice     float64          `json:"cost_price"`             // Unit cost of the most recent receipt
+	TaxClassID    uint             `json:"tax_class_id"`           // 0 when sales aren't taxed
+	AverageCost   float64          `json:"average_cost"`           // Weighted average unit cost of stock on hand
+	SKU           string           `json:"sku" gorm:"index"`       // Unique within the business when set
+	ParentID      *uint            `json:"parent_id" gorm:"index"` // Set on variants, pointing at their parent product
+	HasVariants   bool             `json:"has_variants"`           // Parents are never stocked or sold themselves
+	VariantName   string           `json:"variant_name"`           // e.g. "Red / M"
+	PriceOverride *float64         `json:"price_override"`         // Variant price when it differs from the parent's
+	IsKit         bool             `json:"is_kit"`                 // Sold by taking stock from its components
+	Components    []KitComponent   `json:"components,omitempty" gorm:"foreignKey:KitID"`
+	Options       []ProductOption  `json:"options,omitempty" gorm:"foreignKey:ProductID"`
+	Units         []ProductUnit    `json:"units,omitempty" gorm:"foreignKey:ProductID"`
+	Barcodes      []ProductBarcode `json:"barcodes,omitempty" gorm:"foreignKey:ProductID"`
+	Variants      []Product        `json:"variants,omitempty" gorm:"foreignKey:ParentID"`
+	Stocks        []Stock          `gorm:"foreignKey:ProductID"`
+	Sales         []Sale           `gorm:"foreignKey:ProductID"`
+}
+
+// ProductOption is an attribute a parent product varies by, such as size
+type ProductOption struct {
+	gorm.Model
+	ProductID uint   `json:"product_id" gorm:"not null;index"`
+	Name      string `json:"name" gorm:"not null"`
+	Values    string `json:"values"` // Comma separated, e.g. "S,M,L"
+	Position  int    `json:"position"`
+}
+
+// ProductUnit is a pack or measure a product is bought or sold in, such as
+// a crate of 24 bottles or half a kilo
+type ProductUnit struct {
+	gorm.Model
+	ProductID    uint    `json:"product_id" gorm:"not null;index"`
+	Name         string  `json:"name" gorm:"not null"`
+	Factor       float64 `json:"factor" gorm:"not null"` // Base units in one of this unit
+	Price        float64 `json:"price"`                  // Selling price per unit; 0 means Factor x the product price
+	AllowDecimal bool    `json:"allow_decimal"`          // Whether fractions of this unit can be sold or received
+	ForPurchase  bool    `json:"for_purchase"`
+	ForSale      bool    `json:"for_sale"`
+}
+
+// KitComponent is one line of a kit's bill of materials
+type KitComponent struct {
+	gorm.Model
+	KitID       uint    `json:"kit_id" gorm:"not null;index"`
+	ComponentID uint    `json:"component_id" gorm:"not null;index"`
+	Component   Product `json:"component" gorm:"foreignKey:ComponentID"`
+	Quantity    float64 `json:"quantity"` // Base units of the component in one kit
 }
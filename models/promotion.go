@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Promotion types
+const (
+	PromotionBuyXGetY  = "buy_x_get_y" // Every BuyQuantity + GetQuantity of a product, GetQuantity are free
+	PromotionCategory  = "category"    // Percent off everything in a category
+	PromotionHappyHour = "happy_hour"  // Percent off between DailyFrom and DailyTo each day
+)
+
+// Promotion is a discount the till applies by itself while it runs. When
+// several apply to a line, the customer gets the biggest.
+type Promotion struct {
+	gorm.Model
+	BusinessID  uint       `json:"business_id" gorm:"not null;index"`
+	Name        string     `json:"name" gorm:"not null"`
+	Type        string     `json:"type" gorm:"not null"`
+	ProductID   uint       `json:"product_id" gorm:"index"`  // Buy X get Y, or happy hour on one product; covers its variants
+	CategoryID  uint       `json:"category_id" gorm:"index"` // Category promotions, or happy hour on one category
+	BuyQuantity float64    `json:"buy_quantity"`             // In the unit the product is sold in
+	GetQuantity float64    `json:"get_quantity"`
+	Percent     float64    `json:"percent"`
+	DailyFrom   string     `json:"daily_from"` // Happy hour start, "HH:MM" East Africa Time
+	DailyTo     string     `json:"daily_to"`   // Happy hour end; earlier than DailyFrom when it runs past midnight
+	StartsAt    *time.Time `json:"starts_at"`  // Open-ended when nil
+	EndsAt      *time.Time `json:"ends_at"`
+	Active      bool       `json:"active" gorm:"default:true"`
+}
+
+// DiscountLimit is the largest discount, as a percentage of the price,
+// users with a role may give by hand
+type DiscountLimit struct {
+	gorm.Model
+	BusinessID uint    `json:"business_id" gorm:"not null;unique_index:idx_discount_limit_role"`
+	Role       string  `json:"role" gorm:"not null;unique_index:idx_discount_limit_role"`
+	MaxPercent float64 `json:"max_percent"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Purchase order statuses
+const (
+	PurchaseOrderDraft             = "draft"
+	PurchaseOrderSent              = "sent"
+	PurchaseOrderPartiallyReceived = "partially_received"
+	PurchaseOrderReceived          = "received"
+	PurchaseOrderCancelled         = "cancelled"
+)
+
+type PurchaseOrder struct {
+	gorm.Model
+	BusinessID uint                `json:"business_id" gorm:"not null;index"`
+	SupplierID uint                `json:"supplier_id" gorm:"not null;index"`
+	LocationID uint                `json:"location_id" gorm:"index"` // Where the goods are delivered
+	Supplier   Supplier            `json:"supplier" gorm:"foreignKey:SupplierID"`
+	Number     string              `json:"number" gorm:"index"` // e.g. PO-00012, assigned on creation
+	Status     string              `json:"status" gorm:"not null;index"`
+	Note       string              `json:"note"`
+	ExpectedAt *time.Time          `json:"expected_at"`
+	SentAt     *time.Time          `json:"sent_at"`
+	CreatedBy  uint                `json:"created_by"`
+	Lines      []PurchaseOrderLine `json:"lines" gorm:"foreignKey:PurchaseOrderID"`
+}
+
+type PurchaseOrderLine struct {
+	gorm.Model
+	PurchaseOrderID  uint    `json:"purchase_order_id" gorm:"not null;index"`
+	ProductID        uint    `json:"product_id" gorm:"not null;index"`
+	Product          Product `json:"product" gorm:"foreignKey:ProductID"`
+	UnitID           uint    `json:"unit_id"` // Purchase unit; 0 for the base unit
+	UnitName         string  `json:"unit_name"`
+	Factor           float64 `json:"factor"` // Base units per purchase unit
+	QuantityOrdered  float64 `json:"quantity_ordered"`
+	QuantityReceived float64 `json:"quantity_received"`
+	UnitCost         float64 `json:"unit_cost"` // Per purchase unit
+}
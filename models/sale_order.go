@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Payment methods
+const (
+	PaymentCash   = "cash"
+	PaymentMpesa  = "mpesa"
+	PaymentCard   = "card"
+	PaymentCredit = "credit" // On the customer's account, paid later
+	PaymentSplit  = "split"  // Order paid with more than one method; see its Payments
+)
+
+// Order payment states
+const (
+	OrderPaid           = "paid"
+	OrderPendingPayment = "pending_payment" // Waiting on an M-Pesa prompt to be confirmed
+)
+
+// SaleOrder is the header for a checkout; each line item is a Sale.
+type SaleOrder struct {
+	gorm.Model
+	BusinessID    uint          `json:"business_id" gorm:"not null;index"`
+	UserID        uint          `json:"user_id" gorm:"index"` // Cashier who rang up the order
+	LocationID    uint          `json:"location_id" gorm:"index"`
+	ShiftID       uint          `json:"shift_id" gorm:"index"`    // Cashier's open shift, if any
+	CustomerID    uint          `json:"customer_id" gorm:"index"` // Optional, except on credit sales
+	Customer      *Customer     `json:"customer,omitempty" gorm:"foreignKey:CustomerID"`
+	PriceListID   uint          `json:"price_list_id" gorm:"index"`
+	ItemCount     float64       `json:"item_count"` // Sum of line quantities in base units
+	Total         float64       `json:"total"`
+	Discount      float64       `json:"discount"` // Sum of the lines' discounts
+	TaxTotal      float64       `json:"tax_total"`
+	TaxInclusive  bool          `json:"tax_inclusive"` // Whether line prices included tax when sold
+	PaymentMethod string        `json:"payment_method" gorm:"default:'cash'"`
+	PaymentStatus string        `json:"payment_status" gorm:"default:'paid';index"`
+	AmountDue     float64       `json:"amount_due"` // Unpaid part of what was put on the customer's account
+	VoidedAt      *time.Time    `json:"voided_at" gorm:"index"`
+	VoidedBy      uint          `json:"voided_by"`
+	VoidReason    string        `json:"void_reason"`
+	ArchivedAt    *time.Time    `json:"archived_at" gorm:"index"` // Hidden from day-to-day listings once set
+	SoldAt        time.Time     `json:"sold_at" gorm:"index"`
+	Items         []Sale        `json:"items" gorm:"foreignKey:SaleOrderID"`
+	Payments      []SalePayment `json:"payments" gorm:"foreignKey:SaleOrderID"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Sale payment states. Tenders taken at the till are completed straight
+// away; M-Pesa prompts wait for the provider to confirm them. A prompt
+// paid after its sale was voided is owed back to the customer.
+const (
+	SalePaymentPending   = "pending"
+	SalePaymentCompleted = "completed"
+	SalePaymentFailed    = "failed"
+	SalePaymentRefundDue = "refund_due"
+)
+
+// SalePayment is one tender used to pay for a sale order; an order paid
+// partly in cash and partly by M-Pesa has two
+type SalePayment struct {
+	gorm.Model
+	BusinessID  uint      `json:"business_id" gorm:"not null;index"`
+	SaleOrderID uint      `json:"sale_order_id" gorm:"not null;index"`
+	Method      string    `json:"method" gorm:"not null"` // One of the payment methods
+	Amount      float64   `json:"amount"`                 // Tendered, including any change given back
+	Change      float64   `json:"change"`                 // Only cash is ever overpaid
+	Reference   string    `json:"reference"`              // e.g. an M-Pesa code or card approval number
+	Status      string    `json:"status" gorm:"default:'completed';index"`
+	Phone       string    `json:"phone"`                    // Number prompted to pay, for M-Pesa prompts
+	CheckoutID  string    `json:"checkout_id" gorm:"index"` // Gateway's ID for the prompt
+	Message     string    `json:"message"`                  // Gateway's description of the outcome
+	PaidAt      time.Time `json:"paid_at" gorm:"index"`
+}
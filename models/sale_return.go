@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SaleReturn records goods brought back against a sale order and the
+// money refunded for them
+type SaleReturn struct {
+	gorm.Model
+	BusinessID   uint             `json:"business_id" gorm:"not null;index"`
+	SaleOrderID  uint             `json:"sale_order_id" gorm:"not null;index"`
+	LocationID   uint             `json:"location_id" gorm:"index"` // Where restocked items go back on the shelf
+	UserID       uint             `json:"user_id" gorm:"index"`
+	ShiftID      uint             `json:"shift_id" gorm:"index"` // Shift the refund was paid out of
+	Reason       string           `json:"reason" gorm:"not null"`
+	Note         string           `json:"note"`
+	RefundMethod string           `json:"refund_method"`
+	RefundTotal  float64          `json:"refund_total"`
+	ReturnedAt   time.Time        `json:"returned_at" gorm:"index"`
+	Lines        []SaleReturnLine `json:"lines" gorm:"foreignKey:SaleReturnID"`
+}
+
+type SaleReturnLine struct {
+	gorm.Model
+	SaleReturnID uint    `json:"sale_return_id" gorm:"not null;index"`
+	SaleID       uint    `json:"sale_id" gorm:"not null;index"`
+	ProductID    uint    `json:"product_id" gorm:"not null;index"`
+	Product      Product `json:"product" gorm:"foreignKey:ProductID"`
+	UnitQuantity float64 `json:"unit_quantity"` // In the unit the item was sold in
+	Quantity     float64 `json:"quantity"`      // In the product's base unit
+	Restock      bool    `json:"restock"`       // false when the goods are written off
+	RefundAmount float64 `json:"refund_amount"`
+	Tax          float64 `json:"tax"`  // Tax included in RefundAmount
+	Cost         float64 `json:"cost"` // Cost of goods originally sold for the returned quantity
+}
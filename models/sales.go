@@ -8,10 +8,27 @@ import (
 
 type Sale struct {
 	gorm.Model
-	BusinessID uint      `json:"business_id" gorm:"not null;index"` // Now linked to a business
-	ProductID  uint      `json:"product_id" gorm:"not null;index"`
-	Product    Product   `gorm:"foreignKey:ProductID;references:ID"`
-	Quantity   int       `json:"quantity" binding:"required"`
-	Total      float64   `json:"total" binding:"required"`
-	SoldAt     time.Time `json:"sold_at" gorm:"autoCreateTime"`
+	BusinessID        uint       `json:"business_id" gorm:"not null;index"` // Now linked to a business
+	SaleOrderID       uint       `json:"sale_order_id" gorm:"index"`        // Order this line belongs to
+	LocationID        uint       `json:"location_id" gorm:"index"`
+	ProductID         uint       `json:"product_id" gorm:"not null;index"`
+	Product           Product    `gorm:"foreignKey:ProductID;references:ID"`
+	Quantity          float64    `json:"quantity" binding:"required"` // In the product's base unit
+	UnitID            uint       `json:"unit_id"`                     // Unit sold in; 0 for the base unit
+	UnitName          string     `json:"unit_name"`
+	UnitQuantity      float64    `json:"unit_quantity"` // Quantity in UnitName
+	UnitPrice         float64    `json:"unit_price"`    // Price per UnitName
+	PriceListID       uint       `json:"price_list_id"` // List UnitPrice came from; 0 for the product's own price
+	Total             float64    `json:"total" binding:"required"`
+	Discount          float64    `json:"discount"` // Taken off UnitQuantity x UnitPrice, including PromotionDiscount
+	PromotionID       uint       `json:"promotion_id"`
+	PromotionDiscount float64    `json:"promotion_discount"`
+	TaxClassID        uint       `json:"tax_class_id"`
+	TaxRate           float64    `json:"tax_rate"`            // Percent, as charged at the time
+	Tax               float64    `json:"tax"`                 // Included in Total, which is what the customer paid
+	Cost              float64    `json:"cost"`                // Cost of goods sold for this line
+	Returned          float64    `json:"returned"`            // Quantity returned so far, in UnitName
+	Voided            bool       `json:"voided" gorm:"index"` // Set with the order's void; kept for the audit trail
+	ArchivedAt        *time.Time `json:"archived_at" gorm:"index"`
+	SoldAt            time.Time  `json:"sold_at" gorm:"autoCreateTime"`
 }
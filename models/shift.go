@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Shift statuses
+const (
+	ShiftOpen   = "open"
+	ShiftClosed = "closed"
+)
+
+// Cash movement types
+const (
+	CashIn  = "in"
+	CashOut = "out"
+)
+
+// Shift is one cashier's session on a till, from counting in the opening
+// float to counting the drawer at close. Sales, refunds and account
+// payments taken while it is open are tied to it.
+type Shift struct {
+	gorm.Model
+	BusinessID    uint                `json:"business_id" gorm:"not null;index"`
+	UserID        uint                `json:"user_id" gorm:"not null;index"`
+	LocationID    uint                `json:"location_id" gorm:"index"`
+	Status        string              `json:"status" gorm:"default:'open';index"`
+	OpeningFloat  float64             `json:"opening_float"`
+	OpenedAt      time.Time           `json:"opened_at" gorm:"index"`
+	ClosedAt      *time.Time          `json:"closed_at"`
+	ClosedBy      uint                `json:"closed_by"`
+	ExpectedCash  float64             `json:"expected_cash"` // What the drawer should hold, worked out at close
+	CountedCash   float64             `json:"counted_cash"`
+	Variance      float64             `json:"variance"` // Counted less expected; negative when cash is short
+	Note          string              `json:"note"`
+	CashMovements []ShiftCashMovement `json:"cash_movements,omitempty" gorm:"foreignKey:ShiftID"`
+}
+
+// ShiftCashMovement is cash put into or taken out of the drawer other than
+// through a sale, such as a change top-up or paying a delivery
+type ShiftCashMovement struct {
+	gorm.Model
+	ShiftID uint    `json:"shift_id" gorm:"not null;index"`
+	UserID  uint    `json:"user_id" gorm:"index"`
+	Type    string  `json:"type"`   // CashIn or CashOut
+	Amount  float64 `json:"amount"` // Always positive; Type gives the direction
+	Reason  string  `json:"reason"`
+}
@@ -8,9 +8,32 @@ import (
 
 type Stock struct {
 	gorm.Model
-	BusinessID uint      `json:"business_id" gorm:"not null;index:idx_business_stock"`
-	ProductID  uint      `json:"product_id" gorm:"not null;index:idx_product_stock"`
-	Product    Product   `gorm:"foreignKey:ProductID;references:ID"`
-	Quantity   int       `json:"quantity" binding:"required"`
-	AddedAt    time.Time `json:"added_at" gorm:"index;autoCreateTime"`
+	BusinessID      uint           `json:"business_id" gorm:"not null;index:idx_business_stock"`
+	ProductID       uint           `json:"product_id" gorm:"not null;index:idx_product_stock"`
+	Product         Product        `gorm:"foreignKey:ProductID;references:ID"`
+	SupplierID      uint           `json:"supplier_id" gorm:"index"` // Set when received against a purchase order
+	Supplier        *Supplier      `json:"supplier,omitempty" gorm:"foreignKey:SupplierID"`
+	PurchaseOrderID uint           `json:"purchase_order_id" gorm:"index"`
+	PurchaseOrder   *PurchaseOrder `json:"purchase_order,omitempty" gorm:"foreignKey:PurchaseOrderID"`
+	LocationID      uint           `json:"location_id" gorm:"index"`
+	TransferID      uint           `json:"transfer_id" gorm:"index"`    // Set when the lot arrived by transfer rather than from a supplier
+	SaleReturnID    uint           `json:"sale_return_id" gorm:"index"` // Set when the lot is goods a customer brought back
+	AdjustmentID    uint           `json:"adjustment_id" gorm:"index"`  // Set when the lot was found by an adjustment or count
+	Assembled       bool           `json:"assembled"`                   // Set when the lot is kits built from components
+	Quantity        float64        `json:"quantity" binding:"required"`
+	UnitCost        float64        `json:"unit_cost"`
+	Remaining       float64        `json:"remaining"` // Units of this receipt not yet sold, for FIFO costing
+	LotNumber       string         `json:"lot_number" gorm:"index"`
+	ExpiresAt       *time.Time     `json:"expires_at" gorm:"index"`
+	AddedAt         time.Time      `json:"added_at" gorm:"index;autoCreateTime"`
+}
+
+// StockConsumption records which lot a stock decrease was taken from
+type StockConsumption struct {
+	ID         uint      `json:"id" gorm:"primary_key"`
+	MovementID uint      `json:"movement_id" gorm:"not null;index"`
+	StockID    uint      `json:"stock_id" gorm:"not null;index"`
+	Quantity   float64   `json:"quantity"`
+	UnitCost   float64   `json:"unit_cost"`
+	CreatedAt  time.Time `json:"created_at"`
 }
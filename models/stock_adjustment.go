@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Adjustment reason codes
+const (
+	AdjustmentDamage          = "damage"
+	AdjustmentTheft           = "theft"
+	AdjustmentExpiry          = "expiry"
+	AdjustmentCountCorrection = "count_correction"
+	AdjustmentInternalUse     = "internal_use"
+)
+
+// AdjustmentReasons lists the accepted reason codes
+var AdjustmentReasons = []string{
+	AdjustmentDamage,
+	AdjustmentTheft,
+	AdjustmentExpiry,
+	AdjustmentCountCorrection,
+	AdjustmentInternalUse,
+}
+
+// Adjustment statuses
+const (
+	AdjustmentPending  = "pending"
+	AdjustmentApproved = "approved"
+	AdjustmentRejected = "rejected"
+)
+
+// StockAdjustment corrects a product's quantity outside of sales and
+// receipts. Large adjustments wait in "pending" until an admin approves them.
+type StockAdjustment struct {
+	gorm.Model
+	BusinessID  uint       `json:"business_id" gorm:"not null;index"`
+	ProductID   uint       `json:"product_id" gorm:"not null;index"`
+	LocationID  uint       `json:"location_id" gorm:"index"`
+	Product     Product    `json:"product" gorm:"foreignKey:ProductID"`
+	Quantity    float64    `json:"quantity"` // Signed change to apply
+	Reason      string     `json:"reason" gorm:"not null"`
+	Note        string     `json:"note"`
+	LotID       uint       `json:"lot_id"` // Stock lot to take from first, 0 for first-expiring
+	Status      string     `json:"status" gorm:"not null;index"`
+	RequestedBy uint       `json:"requested_by"`
+	ApprovedBy  uint       `json:"approved_by"`
+	ApprovedAt  *time.Time `json:"approved_at" gorm:"index"`
+}
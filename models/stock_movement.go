@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Stock movement types
+const (
+	MovementReceipt     = "receipt"
+	MovementSale        = "sale"
+	MovementAdjustment  = "adjustment"
+	MovementReturn      = "return"
+	MovementTransferIn  = "transfer_in"
+	MovementTransferOut = "transfer_out"
+	MovementAssembly    = "assembly" // Components used up building kits, and the kits built
+	MovementVoid        = "void"     // Stock put back when a sale is voided
+)
+
+// StockMovement is an append-only ledger entry; every change to
+// Product.Quantity writes one, so the ledger explains the current count.
+type StockMovement struct {
+	ID              uint      `json:"id" gorm:"primary_key"`
+	BusinessID      uint      `json:"business_id" gorm:"not null;index"`
+	ProductID       uint      `json:"product_id" gorm:"not null;index"`
+	LocationID      uint      `json:"location_id" gorm:"index"`
+	Type            string    `json:"type" gorm:"not null"`
+	Quantity        float64   `json:"quantity"`         // Signed: positive adds stock, negative removes it
+	Balance         float64   `json:"balance"`          // Product quantity after this movement
+	LocationBalance float64   `json:"location_balance"` // Quantity at LocationID after this movement
+	Reason          string    `json:"reason"`
+	UserID          uint      `json:"user_id" gorm:"index"`
+	ReferenceType   string    `json:"reference_type"` // e.g. "sale", "stock"
+	ReferenceID     uint      `json:"reference_id"`
+	CreatedAt       time.Time `json:"created_at" gorm:"index"`
+}
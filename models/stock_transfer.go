@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Stock transfer statuses
+const (
+	TransferDraft     = "draft"
+	TransferInTransit = "in_transit"
+	TransferReceived  = "received"
+	TransferCancelled = "cancelled"
+)
+
+// StockTransfer moves stock between two locations. Stock leaves the source
+// when dispatched and is held in transit until the destination receives it.
+type StockTransfer struct {
+	gorm.Model
+	BusinessID     uint                `json:"business_id" gorm:"not null;index"`
+	FromLocationID uint                `json:"from_location_id" gorm:"not null;index"`
+	FromLocation   Location            `json:"from_location" gorm:"foreignKey:FromLocationID"`
+	ToLocationID   uint                `json:"to_location_id" gorm:"not null;index"`
+	ToLocation     Location            `json:"to_location" gorm:"foreignKey:ToLocationID"`
+	Status         string              `json:"status" gorm:"not null;index"`
+	Note           string              `json:"note"`
+	CreatedBy      uint                `json:"created_by"`
+	DispatchedBy   uint                `json:"dispatched_by"`
+	DispatchedAt   *time.Time          `json:"dispatched_at"`
+	ReceivedBy     uint                `json:"received_by"`
+	ReceivedAt     *time.Time          `json:"received_at"`
+	Lines          []StockTransferLine `json:"lines" gorm:"foreignKey:StockTransferID"`
+}
+
+type StockTransferLine struct {
+	gorm.Model
+	StockTransferID uint    `json:"stock_transfer_id" gorm:"not null;index"`
+	ProductID       uint    `json:"product_id" gorm:"not null;index"`
+	Product         Product `json:"product" gorm:"foreignKey:ProductID"`
+	Quantity        float64 `json:"quantity"`
+	MovementID      uint    `json:"movement_id"` // Dispatch movement, whose lot consumptions travel with the goods
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Stocktake statuses
+const (
+	StocktakeOpen      = "open"
+	StocktakePosted    = "posted"
+	StocktakeCancelled = "cancelled"
+)
+
+// Stocktake is a physical count session. Expected quantities are
+// snapshotted when it starts; posting turns variances into adjustments.
+type Stocktake struct {
+	gorm.Model
+	BusinessID uint            `json:"business_id" gorm:"not null;index"`
+	CategoryID uint            `json:"category_id"` // 0 counts every product
+	LocationID uint            `json:"location_id" gorm:"index"`
+	Status     string          `json:"status" gorm:"not null;index"`
+	Note       string          `json:"note"`
+	StartedBy  uint            `json:"started_by"`
+	PostedBy   uint            `json:"posted_by"`
+	PostedAt   *time.Time      `json:"posted_at"`
+	Lines      []StocktakeLine `json:"lines,omitempty" gorm:"foreignKey:StocktakeID"`
+}
+
+// StocktakeLine holds the expected and counted quantity for one product
+type StocktakeLine struct {
+	gorm.Model
+	StocktakeID uint       `json:"stocktake_id" gorm:"not null;index"`
+	ProductID   uint       `json:"product_id" gorm:"not null;index"`
+	Product     Product    `json:"product" gorm:"foreignKey:ProductID"`
+	Expected    float64    `json:"expected"`
+	Price       float64    `json:"price"`   // Price at snapshot time, used to value variances
+	Counted     *float64   `json:"counted"` // nil until someone counts the product
+	CountedBy   uint       `json:"counted_by"`
+	CountedAt   *time.Time `json:"counted_at"`
+}
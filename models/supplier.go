@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type Supplier struct {
+	gorm.Model
+	BusinessID  uint   `json:"business_id" gorm:"not null;index"`
+	Name        string `json:"name" gorm:"not null"`
+	ContactName string `json:"contact_name"`
+	Phone       string `json:"phone"`
+	Email       string `json:"email"`
+	Address     string `json:"address"`
+}
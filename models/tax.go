@@ -0,0 +1,22 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// KRA tax type codes, as printed on fiscal receipts
+const (
+	TaxCodeExempt    = "A"
+	TaxCodeStandard  = "B" // 16% VAT
+	TaxCodeZeroRated = "C"
+	TaxCodeNonVAT    = "D"
+	TaxCodeReduced   = "E" // 8% VAT
+)
+
+// TaxClass is a rate products are taxed at. Zero-rated and exempt goods
+// both carry no tax but are reported separately on the VAT return.
+type TaxClass struct {
+	gorm.Model
+	BusinessID uint    `json:"business_id" gorm:"not null;index"`
+	Name       string  `json:"name" gorm:"not null"`
+	Code       string  `json:"code"` // One of the TaxCode constants
+	Rate       float64 `json:"rate"` // Percent, e.g. 16
+}
@@ -4,6 +4,14 @@ import (
 	"github.com/jinzhu/gorm"
 )
 
+// User roles. Managers can do what employees can plus sensitive till
+// operations such as voiding sales.
+const (
+	RoleAdmin    = "admin"
+	RoleManager  = "manager"
+	RoleEmployee = "employee"
+)
+
 type User struct {
 	gorm.Model
 	FirstName  string    `json:"first_name" binding:"required"`
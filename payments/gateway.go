@@ -0,0 +1,56 @@
+// Package payments collects money through outside providers, such as
+// M-Pesa, where the customer approves a payment on their own phone and the
+// provider reports the outcome later.
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// Payment states reported by a gateway
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// ErrNotConfigured is returned when a gateway is used without credentials
+var ErrNotConfigured = errors.New("payment gateway is not configured")
+
+// Request asks a customer to pay an amount
+type Request struct {
+	Phone       string
+	Amount      float64
+	Reference   string // Shown to the customer, e.g. the sale order number
+	Description string
+}
+
+// Initiation is the provider's acknowledgement of a payment request. The
+// customer hasn't paid yet.
+type Initiation struct {
+	CheckoutID      string // Identifies the payment in callbacks and status queries
+	CustomerMessage string
+}
+
+// Result is the outcome of a payment as reported by the provider
+type Result struct {
+	CheckoutID string
+	Status     string  // One of the Status constants
+	Receipt    string  // Provider's transaction code when completed
+	Amount     float64 // Amount actually paid, when the provider reports it
+	Phone      string
+	Message    string
+}
+
+// Gateway is a provider that takes payments from customers' phones
+type Gateway interface {
+	// Initiate prompts the customer to approve a payment. The outcome
+	// arrives later, in a callback or from Query.
+	Initiate(ctx context.Context, req Request) (Initiation, error)
+	// ParseCallback reads the body the provider posts when a payment
+	// completes or fails.
+	ParseCallback(body []byte) (Result, error)
+	// Query asks the provider for a payment's current status.
+	Query(ctx context.Context, checkoutID string) (Result, error)
+}
@@ -0,0 +1,297 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ken-eddy/stockApp/config"
+)
+
+// Daraja result codes with a meaning of their own
+const (
+	mpesaResultSuccess   = 0
+	mpesaErrorProcessing = "500.001.1001" // Status queried before the customer has responded
+)
+
+// ErrInvalidPhone is returned for numbers that aren't Kenyan mobile numbers
+var ErrInvalidPhone = errors.New("phone number must be a Kenyan mobile number")
+
+// eat is East Africa Time, which Daraja timestamps are in
+var eat = time.FixedZone("EAT", 3*60*60)
+
+// Mpesa is a Gateway backed by Safaricom's Daraja API
+type Mpesa struct {
+	cfg    config.MpesaConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewMpesa returns a Daraja gateway. A nil client uses one with a 30
+// second timeout.
+func NewMpesa(cfg config.MpesaConfig, client *http.Client) *Mpesa {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return &Mpesa{cfg: cfg, client: client}
+}
+
+// NormalizePhone turns the ways people write a Kenyan mobile number, such
+// as 0712 345678 or +254712345678, into the 2547XXXXXXXX form Daraja wants
+func NormalizePhone(phone string) (string, error) {
+	digits := strings.NewReplacer(" ", "", "-", "", "+", "").Replace(phone)
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidPhone
+		}
+	}
+	switch {
+	case len(digits) == 12 && strings.HasPrefix(digits, "254"):
+	case len(digits) == 10 && strings.HasPrefix(digits, "0"):
+		digits = "254" + digits[1:]
+	case len(digits) == 9:
+		digits = "254" + digits
+	default:
+		return "", ErrInvalidPhone
+	}
+	if digits[3] != '7' && digits[3] != '1' {
+		return "", ErrInvalidPhone
+	}
+	return digits, nil
+}
+
+// accessToken returns a cached OAuth token, fetching a new one shortly
+// before the old one expires
+func (m *Mpesa) accessToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && time.Now().Before(m.tokenExpiry) {
+		return m.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		m.cfg.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(m.cfg.ConsumerKey, m.cfg.ConsumerSecret)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mpesa: token request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"` // Seconds, sent as a string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("mpesa: reading token: %w", err)
+	}
+	seconds, err := strconv.Atoi(body.ExpiresIn)
+	if err != nil || seconds <= 0 {
+		seconds = 3599
+	}
+	m.token = body.AccessToken
+	m.tokenExpiry = time.Now().Add(time.Duration(seconds)*time.Second - time.Minute)
+	return m.token, nil
+}
+
+// password is the STK password for a request timestamp
+func (m *Mpesa) password(timestamp string) string {
+	return base64.StdEncoding.EncodeToString([]byte(m.cfg.ShortCode + m.cfg.Passkey + timestamp))
+}
+
+// mpesaError is the body Daraja sends when it rejects a request
+type mpesaError struct {
+	RequestID    string `json:"requestId"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (e mpesaError) Error() string {
+	return fmt.Sprintf("mpesa: %s (%s)", e.ErrorMessage, e.ErrorCode)
+}
+
+// post sends an authorised JSON request and decodes a successful reply into
+// out. Rejections come back as mpesaError.
+func (m *Mpesa) post(ctx context.Context, path string, payload, out interface{}) error {
+	if m.cfg.ConsumerKey == "" || m.cfg.ShortCode == "" {
+		return ErrNotConfigured
+	}
+	token, err := m.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.BaseURL+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var rejection mpesaError
+		if json.Unmarshal(body, &rejection) == nil && rejection.ErrorCode != "" {
+			return rejection
+		}
+		return fmt.Errorf("mpesa: %s returned status %d", path, resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// Initiate sends an STK push, which shows the customer a prompt to enter
+// their M-Pesa PIN. M-Pesa only takes whole shillings, so the amount is
+// rounded up.
+func (m *Mpesa) Initiate(ctx context.Context, req Request) (Initiation, error) {
+	phone, err := NormalizePhone(req.Phone)
+	if err != nil {
+		return Initiation{}, err
+	}
+
+	timestamp := time.Now().In(eat).Format("20060102150405")
+	payload := map[string]interface{}{
+		"BusinessShortCode": m.cfg.ShortCode,
+		"Password":          m.password(timestamp),
+		"Timestamp":         timestamp,
+		"TransactionType":   m.cfg.TransactionType,
+		"Amount":            int64(math.Ceil(req.Amount)),
+		"PartyA":            phone,
+		"PartyB":            m.cfg.PartyB,
+		"PhoneNumber":       phone,
+		"CallBackURL":       m.cfg.CallbackURL,
+		"AccountReference":  truncate(req.Reference, 12),
+		"TransactionDesc":   truncate(req.Description, 13),
+	}
+	var resp struct {
+		MerchantRequestID   string `json:"MerchantRequestID"`
+		CheckoutRequestID   string `json:"CheckoutRequestID"`
+		ResponseCode        string `json:"ResponseCode"`
+		ResponseDescription string `json:"ResponseDescription"`
+		CustomerMessage     string `json:"CustomerMessage"`
+	}
+	if err := m.post(ctx, "/mpesa/stkpush/v1/processrequest", payload, &resp); err != nil {
+		return Initiation{}, err
+	}
+	if resp.ResponseCode != "0" {
+		return Initiation{}, fmt.Errorf("mpesa: %s", resp.ResponseDescription)
+	}
+	return Initiation{CheckoutID: resp.CheckoutRequestID, CustomerMessage: resp.CustomerMessage}, nil
+}
+
+// ParseCallback reads the result Daraja posts to CallBackURL
+func (m *Mpesa) ParseCallback(body []byte) (Result, error) {
+	var callback struct {
+		Body struct {
+			StkCallback struct {
+				CheckoutRequestID string `json:"CheckoutRequestID"`
+				ResultCode        int    `json:"ResultCode"`
+				ResultDesc        string `json:"ResultDesc"`
+				CallbackMetadata  struct {
+					Item []struct {
+						Name  string      `json:"Name"`
+						Value interface{} `json:"Value"` // Numbers or strings, depending on the item
+					} `json:"Item"`
+				} `json:"CallbackMetadata"`
+			} `json:"stkCallback"`
+		} `json:"Body"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&callback); err != nil {
+		return Result{}, fmt.Errorf("mpesa: reading callback: %w", err)
+	}
+	stk := callback.Body.StkCallback
+	if stk.CheckoutRequestID == "" {
+		return Result{}, errors.New("mpesa: callback has no CheckoutRequestID")
+	}
+
+	result := Result{CheckoutID: stk.CheckoutRequestID, Message: stk.ResultDesc, Status: StatusFailed}
+	if stk.ResultCode != mpesaResultSuccess {
+		return result, nil
+	}
+	result.Status = StatusCompleted
+	for _, item := range stk.CallbackMetadata.Item {
+		// UseNumber keeps phone numbers from turning into floats
+		value := fmt.Sprint(item.Value)
+		switch item.Name {
+		case "Amount":
+			result.Amount, _ = strconv.ParseFloat(value, 64)
+		case "MpesaReceiptNumber":
+			result.Receipt = value
+		case "PhoneNumber":
+			result.Phone = value
+		}
+	}
+	return result, nil
+}
+
+// Query asks Daraja whether an STK push has been paid. A push the customer
+// hasn't answered yet is reported as pending. Daraja's query reply has no
+// receipt number, so completed results leave Receipt empty.
+func (m *Mpesa) Query(ctx context.Context, checkoutID string) (Result, error) {
+	timestamp := time.Now().In(eat).Format("20060102150405")
+	payload := map[string]interface{}{
+		"BusinessShortCode": m.cfg.ShortCode,
+		"Password":          m.password(timestamp),
+		"Timestamp":         timestamp,
+		"CheckoutRequestID": checkoutID,
+	}
+	var resp struct {
+		ResponseCode string `json:"ResponseCode"`
+		ResultCode   string `json:"ResultCode"`
+		ResultDesc   string `json:"ResultDesc"`
+	}
+	err := m.post(ctx, "/mpesa/stkpushquery/v1/query", payload, &resp)
+	var rejection mpesaError
+	if errors.As(err, &rejection) && rejection.ErrorCode == mpesaErrorProcessing {
+		return Result{CheckoutID: checkoutID, Status: StatusPending, Message: rejection.ErrorMessage}, nil
+	} else if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{CheckoutID: checkoutID, Status: StatusFailed, Message: resp.ResultDesc}
+	if resp.ResultCode == strconv.Itoa(mpesaResultSuccess) {
+		result.Status = StatusCompleted
+	}
+	return result, nil
+}
+
+// truncate cuts s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,223 @@
+package payments
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ken-eddy/stockApp/config"
+)
+
+// darajaServer fakes Daraja's token endpoint and answers the API path with
+// status and reply, handing each request body to check first. tokens
+// counts the tokens issued.
+func darajaServer(t *testing.T, path string, status int, reply string, check func(body map[string]interface{})) (*Mpesa, *int32) {
+	t.Helper()
+	var tokens int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/v1/generate" {
+			if key, secret, ok := r.BasicAuth(); !ok || key != "key" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			atomic.AddInt32(&tokens, 1)
+			w.Write([]byte(`{"access_token":"token","expires_in":"3599"}`))
+			return
+		}
+		if r.URL.Path != path {
+			t.Errorf("got %s, want %s", r.URL.Path, path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want the issued token", got)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if check != nil {
+			check(body)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewMpesa(config.MpesaConfig{
+		BaseURL:         server.URL,
+		ConsumerKey:     "key",
+		ConsumerSecret:  "secret",
+		ShortCode:       "174379",
+		Passkey:         "passkey",
+		TransactionType: "CustomerPayBillOnline",
+		PartyB:          "174379",
+		CallbackURL:     "https://shop.example/payments/mpesa/callback?token=secret",
+	}, server.Client()), &tokens
+}
+
+func TestMpesaInitiate(t *testing.T) {
+	reply := `{"MerchantRequestID":"29115-34620561-1","CheckoutRequestID":"ws_CO_191220191020363925",
+		"ResponseCode":"0","ResponseDescription":"Success. Request accepted for processing",
+		"CustomerMessage":"Success. Request accepted for processing"}`
+	gateway, tokens := darajaServer(t, "/mpesa/stkpush/v1/processrequest", http.StatusOK, reply, func(body map[string]interface{}) {
+		for field, want := range map[string]interface{}{
+			"BusinessShortCode": "174379",
+			"TransactionType":   "CustomerPayBillOnline",
+			"Amount":            101.0,
+			"PartyA":            "254712345678",
+			"PartyB":            "174379",
+			"PhoneNumber":       "254712345678",
+			"AccountReference":  "000123-LONG-",
+			"TransactionDesc":   "Sale 000123 a",
+		} {
+			if body[field] != want {
+				t.Errorf("%s = %v, want %v", field, body[field], want)
+			}
+		}
+		timestamp, _ := body["Timestamp"].(string)
+		if want := base64.StdEncoding.EncodeToString([]byte("174379passkey" + timestamp)); body["Password"] != want {
+			t.Errorf("Password = %v, want %s", body["Password"], want)
+		}
+	})
+
+	request := Request{Phone: "0712 345678", Amount: 100.4, Reference: "000123-LONG-REFERENCE", Description: "Sale 000123 at the till"}
+	for i := 0; i < 2; i++ {
+		initiation, err := gateway.Initiate(context.Background(), request)
+		if err != nil {
+			t.Fatalf("Initiate: %v", err)
+		}
+		if initiation.CheckoutID != "ws_CO_191220191020363925" {
+			t.Errorf("CheckoutID = %q", initiation.CheckoutID)
+		}
+		if initiation.CustomerMessage != "Success. Request accepted for processing" {
+			t.Errorf("CustomerMessage = %q", initiation.CustomerMessage)
+		}
+	}
+	if n := atomic.LoadInt32(tokens); n != 1 {
+		t.Errorf("fetched %d tokens for two prompts, want 1", n)
+	}
+}
+
+func TestMpesaInitiateRejected(t *testing.T) {
+	reply := `{"requestId":"4788-81090592-1","errorCode":"400.002.02","errorMessage":"Bad Request - Invalid PhoneNumber"}`
+	gateway, _ := darajaServer(t, "/mpesa/stkpush/v1/processrequest", http.StatusBadRequest, reply, nil)
+
+	_, err := gateway.Initiate(context.Background(), Request{Phone: "0712345678", Amount: 100})
+	var rejection mpesaError
+	if !errors.As(err, &rejection) || rejection.ErrorCode != "400.002.02" {
+		t.Errorf("Initiate error = %v, want Daraja's 400.002.02", err)
+	}
+}
+
+func TestMpesaInitiateInvalid(t *testing.T) {
+	gateway, tokens := darajaServer(t, "/mpesa/stkpush/v1/processrequest", http.StatusOK, `{}`, nil)
+	if _, err := gateway.Initiate(context.Background(), Request{Phone: "0201234567", Amount: 100}); !errors.Is(err, ErrInvalidPhone) {
+		t.Errorf("Initiate error = %v, want ErrInvalidPhone", err)
+	}
+	if n := atomic.LoadInt32(tokens); n != 0 {
+		t.Errorf("called Daraja for an invalid number")
+	}
+
+	unconfigured := NewMpesa(config.MpesaConfig{}, nil)
+	if _, err := unconfigured.Initiate(context.Background(), Request{Phone: "0712345678", Amount: 100}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Initiate error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestMpesaParseCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		want Result
+	}{
+		{
+			"paid",
+			`{"Body":{"stkCallback":{"MerchantRequestID":"29115-34620561-1","CheckoutRequestID":"ws_CO_191220191020363925",
+				"ResultCode":0,"ResultDesc":"The service request is processed successfully.",
+				"CallbackMetadata":{"Item":[{"Name":"Amount","Value":1.00},{"Name":"MpesaReceiptNumber","Value":"NLJ7RT61SV"},
+				{"Name":"TransactionDate","Value":20191219102115},{"Name":"PhoneNumber","Value":254708374149}]}}}}`,
+			Result{
+				CheckoutID: "ws_CO_191220191020363925",
+				Status:     StatusCompleted,
+				Receipt:    "NLJ7RT61SV",
+				Amount:     1,
+				Phone:      "254708374149",
+				Message:    "The service request is processed successfully.",
+			},
+		},
+		{
+			"cancelled",
+			`{"Body":{"stkCallback":{"MerchantRequestID":"29115-34620561-1","CheckoutRequestID":"ws_CO_191220191020363925",
+				"ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`,
+			Result{CheckoutID: "ws_CO_191220191020363925", Status: StatusFailed, Message: "Request cancelled by user"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := NewMpesa(config.MpesaConfig{}, nil).ParseCallback([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("ParseCallback: %v", err)
+			}
+			if result != tc.want {
+				t.Errorf("ParseCallback = %+v, want %+v", result, tc.want)
+			}
+		})
+	}
+
+	for name, body := range map[string]string{
+		"garbled":     `{"Body":`,
+		"no checkout": `{"Body":{"stkCallback":{"ResultCode":0}}}`,
+	} {
+		if _, err := NewMpesa(config.MpesaConfig{}, nil).ParseCallback([]byte(body)); err == nil {
+			t.Errorf("%s: ParseCallback succeeded", name)
+		}
+	}
+}
+
+func TestMpesaQuery(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		reply  string
+		want   string // Status, or "" for an error
+	}{
+		{"paid", http.StatusOK,
+			`{"ResponseCode":"0","ResponseDescription":"The service request has been accepted successsfully",
+			"MerchantRequestID":"22205-34066-1","CheckoutRequestID":"ws_CO_13012021093521236557",
+			"ResultCode":"0","ResultDesc":"The service request is processed successfully."}`,
+			StatusCompleted},
+		{"cancelled", http.StatusOK,
+			`{"ResponseCode":"0","ResultCode":"1032","ResultDesc":"Request cancelled by user"}`,
+			StatusFailed},
+		{"unanswered", http.StatusInternalServerError,
+			`{"requestId":"11111-2222-3","errorCode":"500.001.1001","errorMessage":"The transaction is being processed"}`,
+			StatusPending},
+		{"unknown", http.StatusBadRequest,
+			`{"requestId":"11111-2222-3","errorCode":"400.002.02","errorMessage":"Bad Request - Invalid CheckoutRequestID"}`,
+			""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gateway, _ := darajaServer(t, "/mpesa/stkpushquery/v1/query", tc.status, tc.reply, func(body map[string]interface{}) {
+				if body["CheckoutRequestID"] != "ws_CO_13012021093521236557" {
+					t.Errorf("CheckoutRequestID = %v", body["CheckoutRequestID"])
+				}
+			})
+			result, err := gateway.Query(context.Background(), "ws_CO_13012021093521236557")
+			if tc.want == "" {
+				if err == nil {
+					t.Errorf("Query = %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if result.Status != tc.want || result.CheckoutID != "ws_CO_13012021093521236557" {
+				t.Errorf("Query = %+v, want %s", result, tc.want)
+			}
+		})
+	}
+}
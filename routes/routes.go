@@ -91,6 +91,9 @@ func SetupRoutes(router *gin.Engine) {
 		auth.POST("/login", controllers.Login)
 	}
 
+	// Payment provider callbacks carry no user session
+	api.POST("/payments/mpesa/callback", controllers.MpesaCallback)
+
 	// Protected routes
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware()) // Apply auth middleware to all routes in this group
@@ -101,6 +104,40 @@ func SetupRoutes(router *gin.Engine) {
 		protected.GET("/businesses", controllers.GetBusinesses)
 		protected.POST("/businesses/assign", middleware.RoleMiddleware("admin"), controllers.AssignUserToBusiness)
 		protected.POST("/business/changePassword", controllers.ChangeBusinessPassword)
+		protected.PUT("/business/costing-method", middleware.RoleMiddleware("admin"), controllers.UpdateCostingMethod)
+		protected.PUT("/business/tax-pricing", middleware.RoleMiddleware("admin"), controllers.UpdateTaxPricing)
+
+		// Tax class routes
+		taxClasses := protected.Group("/tax-classes")
+		{
+			taxClasses.GET("", controllers.GetTaxClasses)
+			taxClasses.POST("", middleware.RoleMiddleware("admin"), controllers.CreateTaxClass)
+			taxClasses.PUT("/:id", middleware.RoleMiddleware("admin"), controllers.UpdateTaxClass)
+			taxClasses.DELETE("/:id", middleware.RoleMiddleware("admin"), controllers.DeleteTaxClass)
+		}
+
+		// Promotions and how much each role may discount by hand
+		promotions := protected.Group("/promotions")
+		{
+			promotions.GET("", controllers.GetPromotions)
+			promotions.POST("", middleware.RoleMiddleware("admin", "manager"), controllers.CreatePromotion)
+			promotions.PUT("/:id", middleware.RoleMiddleware("admin", "manager"), controllers.UpdatePromotion)
+			promotions.DELETE("/:id", middleware.RoleMiddleware("admin", "manager"), controllers.DeletePromotion)
+		}
+		protected.GET("/discount-limits", controllers.GetDiscountLimits)
+		protected.PUT("/discount-limits", middleware.RoleMiddleware("admin"), controllers.SetDiscountLimit)
+
+		// Price list routes
+		priceLists := protected.Group("/price-lists")
+		{
+			priceLists.GET("", controllers.GetPriceLists)
+			priceLists.GET("/:id", controllers.GetPriceList)
+			priceLists.POST("", middleware.RoleMiddleware("admin", "manager"), controllers.CreatePriceList)
+			priceLists.PUT("/:id", middleware.RoleMiddleware("admin", "manager"), controllers.UpdatePriceList)
+			priceLists.DELETE("/:id", middleware.RoleMiddleware("admin", "manager"), controllers.DeletePriceList)
+			priceLists.PUT("/:id/items", middleware.RoleMiddleware("admin", "manager"), controllers.SetPriceListItem)
+			priceLists.DELETE("/:id/items/:itemId", middleware.RoleMiddleware("admin", "manager"), controllers.DeletePriceListItem)
+		}
 
 		// Product routes
 		products := protected.Group("/products")
@@ -110,13 +147,116 @@ func SetupRoutes(router *gin.Engine) {
 			products.POST("/", controllers.CreateProduct)
 			products.PUT("/:id", controllers.UpdateProduct)
 			products.DELETE("/:id", controllers.DeleteProduct)
+			products.GET("/:id/movements", controllers.ProductMovements)
+			products.POST("/:id/variants", controllers.GenerateVariants)
+			products.GET("/:id/variants", controllers.GetVariants)
+			products.PUT("/:id/variants/:variant_id", controllers.UpdateVariant)
+			products.POST("/:id/units", controllers.CreateProductUnit)
+			products.GET("/:id/units", controllers.GetProductUnits)
+			products.PUT("/:id/units/:unit_id", controllers.UpdateProductUnit)
+			products.DELETE("/:id/units/:unit_id", controllers.DeleteProductUnit)
+			products.PUT("/:id/components", controllers.SetKitComponents)
+			products.GET("/:id/components", controllers.GetKitComponents)
+			products.POST("/:id/assemble", controllers.AssembleKit)
 			products.GET("/total", controllers.NumberOfProducts)
 			products.GET("/low-stock", controllers.LowStock)
 			products.GET("/total-value", controllers.TotalValue)
 			products.GET("/low-stock-items", controllers.LowStockItems)
+			products.GET("/near-expiry", controllers.NearExpiry)
+			products.GET("/lookup", controllers.LookupProduct)
+			products.POST("/:id/barcodes", controllers.AddBarcode)
+			products.DELETE("/:id/barcodes/:barcode_id", controllers.DeleteBarcode)
 			products.DELETE("", controllers.DeleteAll)
 		}
 
+		// Stock adjustment routes
+		adjustments := protected.Group("/adjustments")
+		{
+			adjustments.POST("", controllers.CreateAdjustment)
+			adjustments.GET("", controllers.GetAdjustments)
+			adjustments.POST("/:id/approve", middleware.RoleMiddleware("admin"), controllers.ApproveAdjustment)
+			adjustments.POST("/:id/reject", middleware.RoleMiddleware("admin"), controllers.RejectAdjustment)
+		}
+
+		// Stocktake routes
+		stocktakes := protected.Group("/stocktakes")
+		{
+			stocktakes.POST("", controllers.StartStocktake)
+			stocktakes.GET("", controllers.GetStocktakes)
+			stocktakes.GET("/:id", controllers.GetStocktake)
+			stocktakes.PUT("/:id/counts", controllers.RecordStocktakeCounts)
+			stocktakes.GET("/:id/variance", controllers.StocktakeVariance)
+			stocktakes.POST("/:id/post", middleware.RoleMiddleware("admin"), controllers.PostStocktake)
+			stocktakes.POST("/:id/cancel", middleware.RoleMiddleware("admin"), controllers.CancelStocktake)
+		}
+
+		// Supplier routes
+		suppliers := protected.Group("/suppliers")
+		{
+			suppliers.POST("", controllers.CreateSupplier)
+			suppliers.GET("", controllers.GetSuppliers)
+			suppliers.GET("/:id", controllers.GetSupplier)
+			suppliers.PUT("/:id", controllers.UpdateSupplier)
+			suppliers.DELETE("/:id", controllers.DeleteSupplier)
+		}
+
+		// Purchase order routes
+		purchaseOrders := protected.Group("/purchase-orders")
+		{
+			purchaseOrders.POST("", controllers.CreatePurchaseOrder)
+			purchaseOrders.GET("", controllers.GetPurchaseOrders)
+			purchaseOrders.GET("/:id", controllers.GetPurchaseOrder)
+			purchaseOrders.POST("/:id/send", controllers.SendPurchaseOrder)
+			purchaseOrders.POST("/:id/cancel", controllers.CancelPurchaseOrder)
+			purchaseOrders.POST("/:id/receive", controllers.ReceivePurchaseOrder)
+		}
+
+		// Customer routes
+		customers := protected.Group("/customers")
+		{
+			customers.POST("", controllers.CreateCustomer)
+			customers.GET("", controllers.GetCustomers)
+			customers.GET("/:id", controllers.GetCustomer)
+			customers.PUT("/:id", controllers.UpdateCustomer)
+			customers.DELETE("/:id", controllers.DeleteCustomer)
+			customers.POST("/:id/payments", controllers.RecordCustomerPayment)
+			customers.GET("/:id/payments", controllers.GetCustomerPayments)
+			customers.GET("/:id/statement", controllers.CustomerStatement)
+		}
+
+		// Shift routes
+		shifts := protected.Group("/shifts")
+		{
+			shifts.POST("/open", controllers.OpenShift)
+			shifts.GET("/current", controllers.GetCurrentShift)
+			shifts.GET("", controllers.GetShifts)
+			shifts.GET("/:id", controllers.GetShift)
+			shifts.POST("/:id/cash", controllers.AddShiftCash)
+			shifts.POST("/:id/close", controllers.CloseShift)
+			shifts.GET("/:id/report", controllers.ShiftReport)
+		}
+
+		// Location routes
+		locations := protected.Group("/locations")
+		{
+			locations.POST("", controllers.CreateLocation)
+			locations.GET("", controllers.GetLocations)
+			locations.PUT("/:id", controllers.UpdateLocation)
+			locations.POST("/:id/default", middleware.RoleMiddleware("admin"), controllers.SetDefaultLocation)
+			locations.GET("/:id/stock", controllers.GetLocationStock)
+		}
+
+		// Stock transfer routes
+		transfers := protected.Group("/transfers")
+		{
+			transfers.POST("", controllers.CreateTransfer)
+			transfers.GET("", controllers.GetTransfers)
+			transfers.GET("/:id", controllers.GetTransfer)
+			transfers.POST("/:id/dispatch", controllers.DispatchTransfer)
+			transfers.POST("/:id/receive", controllers.ReceiveTransfer)
+			transfers.POST("/:id/cancel", controllers.CancelTransfer)
+		}
+
 		// Category routes
 		protected.POST("/categories", controllers.CreateCategory)
 		// protected.GET("/businesses/:business_id/categories", controllers.GetCategories)
@@ -130,10 +270,23 @@ func SetupRoutes(router *gin.Engine) {
 		sales := protected.Group("/sales")
 		{
 			sales.POST("", controllers.CreateSale)
+			sales.POST("/orders", controllers.CreateSaleOrder)
+			sales.GET("/orders", controllers.GetSaleOrders)
+			sales.GET("/orders/:id", controllers.GetSaleOrder)
+			sales.GET("/orders/:id/receipt", controllers.SaleReceipt)
+			sales.GET("/orders/:id/payments/:payment_id/status", controllers.MobilePaymentStatus)
+			sales.POST("/orders/:id/payments/:payment_id/retry", controllers.RetryMobilePayment)
+			sales.GET("/orders/:id/fiscal", controllers.GetFiscalInvoice)
+			sales.POST("/orders/:id/fiscal/retry", middleware.RoleMiddleware("admin", "manager"), controllers.RetryFiscalInvoice)
+			sales.POST("/orders/:id/void", middleware.RoleMiddleware("admin", "manager"), controllers.VoidSaleOrder)
+			sales.POST("/orders/:id/returns", controllers.CreateSaleReturn)
+			sales.GET("/returns", controllers.GetSaleReturns)
+			sales.GET("/returns/:id", controllers.GetSaleReturn)
 			sales.GET("", controllers.GetSales)
 			sales.GET("/products", controllers.GetProductsForSales)
 			sales.GET("/last-five-sales", controllers.GetLastFiveSales)
-			sales.DELETE("", controllers.DeleteSaleRecords)
+			sales.GET("/export", middleware.RoleMiddleware("admin"), controllers.ExportSales)
+			sales.POST("/archive", middleware.RoleMiddleware("admin"), controllers.ArchiveSales)
 		}
 
 		// User routes
@@ -144,11 +297,13 @@ func SetupRoutes(router *gin.Engine) {
 			users.GET("/business", controllers.GetBusinessUsers)
 			users.POST("/changePassword", controllers.ChangePassword)
 			users.POST("/createEmployee", controllers.CreateEmployeeUser)
+			users.PUT("/:id/role", middleware.RoleMiddleware("admin"), controllers.SetUserRole)
 			users.POST("/logout", controllers.Logout)
 		}
 
 		// Reports
 		protected.POST("/reports", controllers.GenerateReport)
+		protected.POST("/labels", controllers.GenerateLabels)
 		protected.GET("/session", controllers.VerifyAuth)
 	}
 }